@@ -1,19 +1,91 @@
 package actual
 
 import (
+	"container/list"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+// Cache keys and prefixes used by the client. Keys are built with the helpers
+// below so that handlers can invalidate a single entry or a whole family.
+const (
+	PayeesCacheKey         = "payees"
+	TagCachePrefix         = "tx_tag:"
+	PayeeTagCachePrefix    = "tx_payee_tag:"
+	TransactionCachePrefix = "tx_"
+)
+
+func TagCacheKey(tag string) string {
+	return TagCachePrefix + tag
+}
+
+func PayeeTagCacheKey(payeeID, tag string) string {
+	return PayeeTagCachePrefix + payeeID + ":" + tag
+}
+
 type cacheEntry struct {
+	key       string
 	data      any
+	createdAt time.Time
 	expiresAt time.Time
+	hits      uint64
 }
 
+// Cache is a TTL cache with an LRU size bound. The most recently used entry
+// sits at the front of order; the back is evicted first.
 type Cache struct {
-	mu      sync.RWMutex
-	entries map[string]*cacheEntry
-	ttl     time.Duration
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List
+	ttl        time.Duration
+	maxEntries int
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// CacheStats is a point-in-time summary of cache behaviour.
+type CacheStats struct {
+	Entries    int
+	MaxEntries int
+	TTL        time.Duration
+	Hits       uint64
+	Misses     uint64
+	Evictions  uint64
+}
+
+// HitRate returns the fraction of lookups served from the cache.
+func (s CacheStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// CacheEntryInfo describes a single entry without exposing its data.
+type CacheEntryInfo struct {
+	Key       string
+	Items     int
+	Hits      uint64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Expired   bool
+}
+
+func (e CacheEntryInfo) Age() time.Duration {
+	return time.Since(e.CreatedAt).Truncate(time.Second)
+}
+
+func (e CacheEntryInfo) TTLRemaining() time.Duration {
+	if e.Expired {
+		return 0
+	}
+	return time.Until(e.ExpiresAt).Truncate(time.Second)
 }
 
 var (
@@ -21,13 +93,16 @@ var (
 	cacheMu     sync.Mutex
 )
 
-func InitCache(ttl time.Duration) {
+// InitCache sets up the global cache. maxEntries <= 0 means unbounded.
+func InitCache(ttl time.Duration, maxEntries int) {
 	cacheMu.Lock()
 	defer cacheMu.Unlock()
 	if globalCache == nil {
 		globalCache = &Cache{
-			entries: make(map[string]*cacheEntry),
-			ttl:     ttl,
+			entries:    make(map[string]*list.Element),
+			order:      list.New(),
+			ttl:        ttl,
+			maxEntries: maxEntries,
 		}
 	}
 }
@@ -40,33 +115,165 @@ func ClearCache() {
 	cacheMu.Lock()
 	defer cacheMu.Unlock()
 	if globalCache != nil {
-		globalCache.mu.Lock()
-		globalCache.entries = make(map[string]*cacheEntry)
-		globalCache.mu.Unlock()
+		globalCache.Clear()
+	}
+}
+
+// InvalidateCacheKey drops a single entry from the global cache.
+func InvalidateCacheKey(key string) {
+	if globalCache != nil {
+		globalCache.Invalidate(key)
+	}
+}
+
+// InvalidateCachePrefix drops every entry whose key starts with prefix.
+func InvalidateCachePrefix(prefix string) int {
+	if globalCache == nil {
+		return 0
 	}
+	return globalCache.InvalidatePrefix(prefix)
 }
 
 func (c *Cache) get(key string) (any, bool) {
-	c.mu.RLock()
-	entry, ok := c.entries[key]
-	c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
 	if !ok {
+		c.misses++
 		return nil, false
 	}
+	entry := el.Value.(*cacheEntry)
 	if time.Now().After(entry.expiresAt) {
-		c.mu.Lock()
-		delete(c.entries, key)
-		c.mu.Unlock()
+		c.removeElement(el)
+		c.misses++
 		return nil, false
 	}
+	entry.hits++
+	c.hits++
+	c.order.MoveToFront(el)
 	return entry.data, true
 }
 
 func (c *Cache) set(key string, data any) {
 	c.mu.Lock()
-	c.entries[key] = &cacheEntry{
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.data = data
+		entry.createdAt = now
+		entry.expiresAt = now.Add(c.ttl)
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{
+		key:       key,
 		data:      data,
-		expiresAt: time.Now().Add(c.ttl),
+		createdAt: now,
+		expiresAt: now.Add(c.ttl),
+	})
+
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.removeElement(c.order.Back())
+		c.evictions++
 	}
+}
+
+func (c *Cache) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+// Clear drops all entries but keeps the hit/miss counters.
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
 	c.mu.Unlock()
 }
+
+// Invalidate drops a single key. It reports whether the key was present.
+func (c *Cache) Invalidate(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if ok {
+		c.removeElement(el)
+	}
+	return ok
+}
+
+// InvalidatePrefix drops every key starting with prefix and returns how many
+// entries were removed.
+func (c *Cache) InvalidatePrefix(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for key, el := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.removeElement(el)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Entries:    c.order.Len(),
+		MaxEntries: c.maxEntries,
+		TTL:        c.ttl,
+		Hits:       c.hits,
+		Misses:     c.misses,
+		Evictions:  c.evictions,
+	}
+}
+
+// Entries lists all entries sorted by key. Expired entries that have not been
+// evicted yet are included and flagged.
+func (c *Cache) Entries() []CacheEntryInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	infos := make([]CacheEntryInfo, 0, len(c.entries))
+	for _, el := range c.entries {
+		infos = append(infos, entryInfo(el.Value.(*cacheEntry), now))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+	return infos
+}
+
+// Peek returns an entry's data without counting as a hit or touching the LRU
+// order, for inspection from the admin UI.
+func (c *Cache) Peek(key string) (any, CacheEntryInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, CacheEntryInfo{}, false
+	}
+	entry := el.Value.(*cacheEntry)
+	return entry.data, entryInfo(entry, time.Now()), true
+}
+
+func entryInfo(e *cacheEntry, now time.Time) CacheEntryInfo {
+	items := 1
+	if v := reflect.ValueOf(e.data); v.Kind() == reflect.Slice {
+		items = v.Len()
+	}
+	return CacheEntryInfo{
+		Key:       e.key,
+		Items:     items,
+		Hits:      e.hits,
+		CreatedAt: e.createdAt,
+		ExpiresAt: e.expiresAt,
+		Expired:   now.After(e.expiresAt),
+	}
+}
@@ -0,0 +1,110 @@
+package actual
+
+import (
+	"container/list"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestCache(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+func cacheKeys(c *Cache) []string {
+	var keys []string
+	for _, e := range c.Entries() {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions []string // "set:k" or "get:k"
+		want    []string
+	}{
+		{"oldest goes first", []string{"set:a", "set:b", "set:c", "set:d"}, []string{"b", "c", "d"}},
+		{"a read refreshes an entry", []string{"set:a", "set:b", "set:c", "get:a", "set:d"}, []string{"a", "c", "d"}},
+		{"a rewrite refreshes an entry", []string{"set:a", "set:b", "set:c", "set:a", "set:d"}, []string{"a", "c", "d"}},
+		{"a miss does not evict", []string{"set:a", "set:b", "set:c", "get:x"}, []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCache(time.Minute, 3)
+			for _, action := range tt.actions {
+				op, key := action[:3], action[4:]
+				if op == "set" {
+					c.set(key, key)
+				} else {
+					c.get(key)
+				}
+			}
+			if got := cacheKeys(c); !slices.Equal(got, tt.want) {
+				t.Errorf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	c := newTestCache(time.Minute, 1)
+	c.set("a", 1)
+	c.get("a")
+	c.get("b")
+	c.set("b", 2)
+
+	s := c.Stats()
+	if s.Hits != 1 || s.Misses != 1 || s.Evictions != 1 || s.Entries != 1 {
+		t.Errorf("Stats = %+v", s)
+	}
+	if s.HitRate() != 0.5 {
+		t.Errorf("HitRate = %v, want 0.5", s.HitRate())
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	c := newTestCache(time.Millisecond, 0)
+	c.set("a", 1)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.get("a"); ok {
+		t.Error("expired entry was returned")
+	}
+	if len(c.Entries()) != 0 {
+		t.Error("expired entry was not dropped on read")
+	}
+}
+
+func TestCacheInvalidatePrefix(t *testing.T) {
+	c := newTestCache(time.Minute, 0)
+	for _, key := range []string{
+		PayeesCacheKey,
+		TagCacheKey("#gsu2026"),
+		TagCacheKey("#other"),
+		PayeeTagCacheKey("p1", "#gsu2026"),
+	} {
+		c.set(key, []Transaction{})
+	}
+
+	if n := c.InvalidatePrefix(TagCachePrefix); n != 2 {
+		t.Errorf("InvalidatePrefix(tag) removed %d, want 2", n)
+	}
+	want := []string{PayeesCacheKey, PayeeTagCacheKey("p1", "#gsu2026")}
+	if got := cacheKeys(c); !slices.Equal(got, want) {
+		t.Errorf("keys after tag invalidation = %v, want %v", got, want)
+	}
+
+	// Every transaction key shares TransactionCachePrefix; payees must survive.
+	if n := c.InvalidatePrefix(TransactionCachePrefix); n != 1 {
+		t.Errorf("InvalidatePrefix(tx) removed %d, want 1", n)
+	}
+	if got := cacheKeys(c); !slices.Equal(got, []string{PayeesCacheKey}) {
+		t.Errorf("keys after transaction invalidation = %v, want only payees", got)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"who-owes-me/internal/envutil"
@@ -63,8 +65,8 @@ type Payee struct {
 
 func (c *Client) GetPayees() ([]Payee, error) {
 	if globalCache != nil {
-		if cached, ok := globalCache.get("payees"); ok {
-			return cached.([]Payee), nil
+		if cached, ok := globalCache.get(PayeesCacheKey); ok {
+			return slices.Clone(cached.([]Payee)), nil
 		}
 	}
 
@@ -81,20 +83,49 @@ func (c *Client) GetPayees() ([]Payee, error) {
 	}
 
 	if globalCache != nil {
-		globalCache.set("payees", result.Data)
+		globalCache.set(PayeesCacheKey, slices.Clone(result.Data))
 	}
 
 	return result.Data, nil
 }
 
+// CreatePayee adds a payee in Actual and returns its ID. The cached payee
+// list is dropped so the new payee shows up right away.
+func (c *Client) CreatePayee(name string) (string, error) {
+	bodyBytes, err := json.Marshal(map[string]interface{}{
+		"payee": map[string]interface{}{"name": name},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	data, err := c.doRequest("POST", "/payees", bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return "", err
+	}
+	InvalidateCacheKey(PayeesCacheKey)
+
+	var result struct {
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", err
+	}
+	if result.Data == "" {
+		return "", fmt.Errorf("actual API did not return the new payee ID")
+	}
+	return result.Data, nil
+}
+
 type Transaction struct {
-	ID       string `json:"id"`
-	Date     string `json:"date"`
-	Amount   int    `json:"amount"` // in cents
-	Payee    string `json:"payee"`
-	Notes    string `json:"notes"`
-	Account  string `json:"account"`
-	Category string `json:"category"`
+	ID         string `json:"id"`
+	Date       string `json:"date"`
+	Amount     int    `json:"amount"` // in cents
+	Payee      string `json:"payee"`
+	Notes      string `json:"notes"`
+	Account    string `json:"account"`
+	Category   string `json:"category"`
+	ImportedID string `json:"imported_id,omitempty"`
 }
 
 func (c *Client) RunQuery(aqlQuery interface{}) ([]Transaction, error) {
@@ -133,10 +164,10 @@ func (c *Client) GetTransactionsByPayee(payeeID string) ([]Transaction, error) {
 }
 
 func (c *Client) GetTaggedTransactionsByPayee(payeeID string, tag string) ([]Transaction, error) {
-	key := "tx_payee_tag:" + payeeID + ":" + tag
+	key := PayeeTagCacheKey(payeeID, tag)
 	if globalCache != nil {
 		if cached, ok := globalCache.get(key); ok {
-			return cached.([]Transaction), nil
+			return slices.Clone(cached.([]Transaction)), nil
 		}
 	}
 
@@ -156,20 +187,38 @@ func (c *Client) GetTaggedTransactionsByPayee(payeeID string, tag string) ([]Tra
 	}
 
 	if globalCache != nil {
-		globalCache.set(key, txns)
+		globalCache.set(key, slices.Clone(txns))
 	}
 
 	return txns, nil
 }
 
+// GetTransactionsByTag returns tagged transactions, from the cache when
+// possible. Results are copies, so callers may edit them (e.g. clean notes for
+// display) without changing what is cached.
 func (c *Client) GetTransactionsByTag(tag string) ([]Transaction, error) {
-	key := "tx_tag:" + tag
+	key := TagCacheKey(tag)
 	if globalCache != nil {
 		if cached, ok := globalCache.get(key); ok {
-			return cached.([]Transaction), nil
+			return slices.Clone(cached.([]Transaction)), nil
 		}
 	}
 
+	txns, err := c.FetchTransactionsByTag(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	if globalCache != nil {
+		globalCache.set(key, slices.Clone(txns))
+	}
+
+	return txns, nil
+}
+
+// FetchTransactionsByTag queries Actual directly, bypassing the cache. Use it
+// before writing anything back that was derived from the fetched data.
+func (c *Client) FetchTransactionsByTag(tag string) ([]Transaction, error) {
 	query := map[string]interface{}{
 		"table": "transactions",
 		"select": []string{"*"},
@@ -179,14 +228,106 @@ func (c *Client) GetTransactionsByTag(tag string) ([]Transaction, error) {
 			},
 		},
 	}
-	txns, err := c.RunQuery(query)
+	return c.RunQuery(query)
+}
+
+// RefreshCacheKey drops a cache entry and fetches it again from Actual, so the
+// admin can refresh payees or a single tag without clearing everything.
+func (c *Client) RefreshCacheKey(key string) error {
+	InvalidateCacheKey(key)
+
+	var err error
+	switch {
+	case key == PayeesCacheKey:
+		_, err = c.GetPayees()
+	case strings.HasPrefix(key, TagCachePrefix):
+		_, err = c.GetTransactionsByTag(strings.TrimPrefix(key, TagCachePrefix))
+	case strings.HasPrefix(key, PayeeTagCachePrefix):
+		payeeID, tag, ok := strings.Cut(strings.TrimPrefix(key, PayeeTagCachePrefix), ":")
+		if !ok {
+			return fmt.Errorf("malformed cache key %q", key)
+		}
+		_, err = c.GetTaggedTransactionsByPayee(payeeID, tag)
+	default:
+		return fmt.Errorf("unknown cache key %q", key)
+	}
+	return err
+}
+
+// CreateTransaction adds a transaction to an account and returns its ID.
+// actual-http-api does not always echo the new ID back, so callers should set
+// ImportedID and the transaction is looked up by it when needed.
+func (c *Client) CreateTransaction(accountID string, tx Transaction) (string, error) {
+	payload := map[string]interface{}{
+		"learnCategories": false,
+		"runTransfers":    false,
+		"transaction": map[string]interface{}{
+			"date":        tx.Date,
+			"amount":      tx.Amount,
+			"payee":       tx.Payee,
+			"notes":       tx.Notes,
+			"imported_id": tx.ImportedID,
+			"cleared":     false,
+		},
+	}
+	bodyBytes, err := json.Marshal(payload)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	if globalCache != nil {
-		globalCache.set(key, txns)
+	data, err := c.doRequest("POST", "/accounts/"+accountID+"/transactions", bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return "", err
 	}
 
-	return txns, nil
+	var result struct {
+		Data interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &result); err == nil {
+		if id, ok := result.Data.(string); ok && id != "" && id != "ok" {
+			return id, nil
+		}
+	}
+
+	if tx.ImportedID == "" {
+		return "", fmt.Errorf("transaction created but its ID is unknown")
+	}
+	created, err := c.FindTransactionByImportedID(tx.ImportedID)
+	if err != nil {
+		return "", err
+	}
+	if created == nil {
+		return "", fmt.Errorf("transaction %s not found after creating it", tx.ImportedID)
+	}
+	return created.ID, nil
+}
+
+// UpdateTransaction patches the given fields of an existing transaction.
+func (c *Client) UpdateTransaction(id string, fields map[string]interface{}) error {
+	bodyBytes, err := json.Marshal(map[string]interface{}{"transaction": fields})
+	if err != nil {
+		return err
+	}
+	_, err = c.doRequest("PATCH", "/transactions/"+id, bytes.NewBuffer(bodyBytes))
+	return err
+}
+
+// FindTransactionByImportedID returns nil when no transaction matches.
+func (c *Client) FindTransactionByImportedID(importedID string) (*Transaction, error) {
+	txns, err := c.RunQuery(map[string]interface{}{
+		"table":  "transactions",
+		"select": []string{"*"},
+		"filter": map[string]interface{}{
+			"imported_id": importedID,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range txns {
+		if txns[i].ImportedID == importedID {
+			return &txns[i], nil
+		}
+	}
+	return nil, nil
 }
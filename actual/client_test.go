@@ -0,0 +1,46 @@
+package actual
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachedTransactionsAreCopies(t *testing.T) {
+	var queries atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		w.Write([]byte(`{"data":[{"id":"t1","amount":-500,"notes":"dues #tag"}]}`))
+	}))
+	defer srv.Close()
+
+	InitCache(time.Minute, 0)
+	t.Cleanup(ClearCache)
+	c := &Client{BaseURL: srv.URL, BudgetID: "b1", HTTP: srv.Client()}
+
+	first, err := c.GetTransactionsByTag("#tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first[0].Notes = "dues"
+
+	second, err := c.GetTransactionsByTag("#tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queries.Load() != 1 {
+		t.Errorf("made %d queries, want the second lookup served from cache", queries.Load())
+	}
+	if second[0].Notes != "dues #tag" {
+		t.Errorf("cached notes = %q; editing a result changed the cache", second[0].Notes)
+	}
+
+	if _, err := c.FetchTransactionsByTag("#tag"); err != nil {
+		t.Fatal(err)
+	}
+	if queries.Load() != 2 {
+		t.Errorf("FetchTransactionsByTag was served from cache")
+	}
+}
@@ -0,0 +1,118 @@
+package actual
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// PayeeMatch is a payee suggested for a name, with a similarity score in [0, 1].
+type PayeeMatch struct {
+	Payee
+	Score float64 `json:"score"`
+}
+
+// MinSuggestScore is the score below which a suggestion is not worth showing.
+const MinSuggestScore = 0.6
+
+// SuggestPayees ranks payees by how closely their name matches name and
+// returns at most limit matches scoring at least MinSuggestScore.
+func SuggestPayees(name string, payees []Payee, limit int) []PayeeMatch {
+	query := normalizeName(name)
+	if len(query) == 0 {
+		return nil
+	}
+
+	var matches []PayeeMatch
+	for _, p := range payees {
+		score := nameSimilarity(query, normalizeName(p.Name))
+		if score >= MinSuggestScore {
+			matches = append(matches, PayeeMatch{Payee: p, Score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// normalizeName lowercases a name and splits it into alphanumeric tokens.
+func normalizeName(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// nameSimilarity scores two tokenized names. It takes the better of a
+// whole-string edit distance and a token match, so "Jon Smith" matches
+// "John Smith", "Smith, John" and "John Smith (Venmo)". A lone initial
+// matches a token starting with it.
+func nameSimilarity(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	whole := stringSimilarity(strings.Join(a, " "), strings.Join(b, " "))
+
+	matched := 0.0
+	for _, ta := range a {
+		best := 0.0
+		for _, tb := range b {
+			s := stringSimilarity(ta, tb)
+			if len(ta) == 1 && strings.HasPrefix(tb, ta) {
+				s = 0.9
+			}
+			if s > best {
+				best = s
+			}
+		}
+		if best >= 0.75 {
+			matched += best
+		}
+	}
+	tokens := matched / float64(len(a))
+	// Extra payee tokens (e.g. "Venmo") cost a little but not much.
+	if len(b) > len(a) {
+		tokens *= 1 - 0.05*float64(len(b)-len(a))
+	}
+
+	if tokens > whole {
+		return tokens
+	}
+	return whole
+}
+
+// stringSimilarity is 1 minus the normalized Levenshtein distance.
+func stringSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
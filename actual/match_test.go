@@ -0,0 +1,60 @@
+package actual
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNameSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"John Smith", "john smith", 1},
+		{"John Smith", "Smith, John", 1},
+		{"J Smith", "John Smith", 0.95},
+		// One extra payee token costs 5%.
+		{"John Smith", "John Smith (Venmo)", 0.95},
+		{"John Smith", "John Smith Venmo Zelle", 0.9},
+		{"Bob", "Rob", 2.0 / 3},
+		{"Bob", "Tom", 1.0 / 3},
+		{"", "John", 0},
+	}
+	for _, tt := range tests {
+		got := nameSimilarity(normalizeName(tt.a), normalizeName(tt.b))
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("nameSimilarity(%q, %q) = %.4f, want %.4f", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSuggestPayees(t *testing.T) {
+	payees := []Payee{
+		{ID: "p1", Name: "John Smith (Venmo)"},
+		{ID: "p2", Name: "Team Store"},
+		{ID: "p3", Name: "John Smith"},
+		{ID: "p4", Name: "Jane Smythe"},
+		{ID: "p5", Name: "Jon Smith"},
+	}
+
+	got := SuggestPayees("John Smith", payees, 0)
+	wantIDs := []string{"p3", "p1", "p5"}
+	if len(got) != len(wantIDs) {
+		t.Fatalf("SuggestPayees = %+v, want %v", got, wantIDs)
+	}
+	for i, id := range wantIDs {
+		if got[i].ID != id {
+			t.Errorf("match %d = %s (%.2f), want %s", i, got[i].ID, got[i].Score, id)
+		}
+		if got[i].Score < MinSuggestScore {
+			t.Errorf("match %s scored %.2f, below MinSuggestScore", got[i].ID, got[i].Score)
+		}
+	}
+
+	if got := SuggestPayees("John Smith", payees, 1); len(got) != 1 || got[0].ID != "p3" {
+		t.Errorf("SuggestPayees with limit 1 = %+v, want only p3", got)
+	}
+	if got := SuggestPayees("  ", payees, 5); got != nil {
+		t.Errorf("SuggestPayees(blank) = %+v, want nil", got)
+	}
+}
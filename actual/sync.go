@@ -0,0 +1,170 @@
+package actual
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type ChangeKind string
+
+const (
+	ChangeCreated ChangeKind = "created"
+	ChangeUpdated ChangeKind = "updated"
+	ChangeDeleted ChangeKind = "deleted"
+)
+
+// ChangeEvent describes a tagged transaction that appeared, changed or
+// disappeared in Actual between two syncs. Previous is set for updates and
+// deletes.
+type ChangeEvent struct {
+	Kind        ChangeKind
+	Transaction Transaction
+	Previous    *Transaction
+	DetectedAt  time.Time
+}
+
+// SyncStatus is a summary of the last sync run for display.
+type SyncStatus struct {
+	Tag          string
+	Interval     time.Duration
+	LastRun      time.Time
+	LastError    string
+	Transactions int
+	Runs         int
+}
+
+// Syncer periodically fetches tagged transactions and diffs them against the
+// previous snapshot. The first run only records a baseline, so a restart does
+// not replay every transaction as created.
+type Syncer struct {
+	client   *Client
+	tag      string
+	interval time.Duration
+
+	// runMu serializes SyncOnce across the fetch, so a slow run can never
+	// replace the snapshot of a newer one.
+	runMu sync.Mutex
+
+	mu        sync.Mutex
+	snapshot  map[string]Transaction
+	lastRun   time.Time
+	lastErr   error
+	runs      int
+	listeners []func(ChangeEvent)
+}
+
+func NewSyncer(client *Client, tag string, interval time.Duration) *Syncer {
+	return &Syncer{
+		client:   client,
+		tag:      tag,
+		interval: interval,
+	}
+}
+
+// OnChange registers a listener that is called for every detected change.
+// Register listeners before calling Run.
+func (s *Syncer) OnChange(fn func(ChangeEvent)) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, fn)
+	s.mu.Unlock()
+}
+
+// Run syncs immediately and then on every interval until ctx is cancelled.
+func (s *Syncer) Run(ctx context.Context) {
+	if _, err := s.SyncOnce(); err != nil {
+		fmt.Printf("Actual sync failed: %v\n", err)
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.SyncOnce(); err != nil {
+				fmt.Printf("Actual sync failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// SyncOnce fetches the tagged transactions bypassing the cache, diffs them
+// against the last snapshot and notifies listeners. The fresh result is left
+// in the cache so page loads benefit from it.
+func (s *Syncer) SyncOnce() ([]ChangeEvent, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	InvalidateCacheKey(TagCacheKey(s.tag))
+	txns, err := s.client.GetTransactionsByTag(s.tag)
+
+	s.mu.Lock()
+	s.lastRun = time.Now()
+	s.lastErr = err
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	current := make(map[string]Transaction, len(txns))
+	for _, t := range txns {
+		current[t.ID] = t
+	}
+
+	var events []ChangeEvent
+	if s.snapshot != nil {
+		events = diffSnapshots(s.snapshot, current, s.lastRun)
+	}
+	s.snapshot = current
+	s.runs++
+	listeners := append([]func(ChangeEvent){}, s.listeners...)
+	s.mu.Unlock()
+
+	for _, ev := range events {
+		for _, fn := range listeners {
+			fn(ev)
+		}
+	}
+	return events, nil
+}
+
+func (s *Syncer) Status() SyncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := SyncStatus{
+		Tag:          s.tag,
+		Interval:     s.interval,
+		LastRun:      s.lastRun,
+		Transactions: len(s.snapshot),
+		Runs:         s.runs,
+	}
+	if s.lastErr != nil {
+		status.LastError = s.lastErr.Error()
+	}
+	return status
+}
+
+func diffSnapshots(prev, current map[string]Transaction, now time.Time) []ChangeEvent {
+	var events []ChangeEvent
+	for id, t := range current {
+		old, ok := prev[id]
+		if !ok {
+			events = append(events, ChangeEvent{Kind: ChangeCreated, Transaction: t, DetectedAt: now})
+			continue
+		}
+		if old != t {
+			old := old
+			events = append(events, ChangeEvent{Kind: ChangeUpdated, Transaction: t, Previous: &old, DetectedAt: now})
+		}
+	}
+	for id, old := range prev {
+		if _, ok := current[id]; !ok {
+			old := old
+			events = append(events, ChangeEvent{Kind: ChangeDeleted, Transaction: old, Previous: &old, DetectedAt: now})
+		}
+	}
+	return events
+}
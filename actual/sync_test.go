@@ -0,0 +1,66 @@
+package actual
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	base := map[string]Transaction{
+		"keep":   {ID: "keep", Amount: -1000, Notes: "dues #tag"},
+		"edit":   {ID: "edit", Amount: -500, Notes: "fee #tag"},
+		"remove": {ID: "remove", Amount: 200, Notes: "refund #tag"},
+	}
+	current := map[string]Transaction{
+		"keep": {ID: "keep", Amount: -1000, Notes: "dues #tag"},
+		"edit": {ID: "edit", Amount: -750, Notes: "fee #tag"},
+		"new":  {ID: "new", Amount: 300, Notes: "payment #tag"},
+	}
+	now := time.Now()
+
+	events := diffSnapshots(base, current, now)
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Transaction.ID < events[j].Transaction.ID
+	})
+
+	want := []struct {
+		id       string
+		kind     ChangeKind
+		previous bool
+	}{
+		{"edit", ChangeUpdated, true},
+		{"new", ChangeCreated, false},
+		{"remove", ChangeDeleted, true},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		ev := events[i]
+		if ev.Transaction.ID != w.id || ev.Kind != w.kind || (ev.Previous != nil) != w.previous {
+			t.Errorf("event %d = %s %s (previous %v), want %s %s (previous %v)",
+				i, ev.Transaction.ID, ev.Kind, ev.Previous != nil, w.id, w.kind, w.previous)
+		}
+		if !ev.DetectedAt.Equal(now) {
+			t.Errorf("event %d DetectedAt = %v, want %v", i, ev.DetectedAt, now)
+		}
+	}
+
+	if events[0].Previous.Amount != -500 || events[0].Transaction.Amount != -750 {
+		t.Errorf("update event = %+v, previous %+v", events[0].Transaction, *events[0].Previous)
+	}
+	if events[2].Transaction.Amount != 200 {
+		t.Errorf("delete event carries %+v, want the last known transaction", events[2].Transaction)
+	}
+}
+
+func TestDiffSnapshotsUnchanged(t *testing.T) {
+	snap := map[string]Transaction{"a": {ID: "a", Amount: 1}}
+	if events := diffSnapshots(snap, map[string]Transaction{"a": {ID: "a", Amount: 1}}, time.Now()); len(events) != 0 {
+		t.Errorf("identical snapshots produced %+v", events)
+	}
+	if events := diffSnapshots(map[string]Transaction{}, map[string]Transaction{}, time.Now()); len(events) != 0 {
+		t.Errorf("empty snapshots produced %+v", events)
+	}
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"net/http"
+
+	"who-owes-me/internal/envutil"
+)
+
+// CSRFCookie holds the signed token that a browser's state-changing requests
+// must echo back in a form field or header.
+const CSRFCookie = "csrf_token"
+
+// SetCSRFCookie gives the browser a CSRF token for as long as it stays open.
+func SetCSRFCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    Sign(token),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   envutil.Getenv("APP_ENV") == "production",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// CSRFToken returns the verified token from the CSRF cookie.
+func CSRFToken(r *http.Request) (string, error) {
+	signed, err := GetCookie(r, CSRFCookie)
+	if err != nil {
+		return "", err
+	}
+	return Verify(signed)
+}
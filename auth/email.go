@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"who-owes-me/internal/envutil"
+)
+
+// EmailIssuer is the Session.Issuer of logins made with an emailed link. The
+// session's Subject is the ID of the user the link was sent to.
+const EmailIssuer = "email"
+
+// LoginLinkTTL is how long an emailed sign-in link works.
+const LoginLinkTTL = 15 * time.Minute
+
+// MailConfig is the SMTP server that sends sign-in links.
+type MailConfig struct {
+	Addr     string // host:port
+	Username string // "" to send without authenticating
+	Password string
+	From     string
+	BaseURL  string // where the app is reached, for the link
+}
+
+// Mail is set by InitMail when email sign-in is configured.
+var Mail *MailConfig
+
+// InitMail enables sign-in by email from SMTP_HOST, SMTP_PORT (default 587),
+// SMTP_USERNAME, SMTP_PASSWORD, SMTP_FROM and APP_URL. Without SMTP_HOST it
+// does nothing.
+func InitMail() error {
+	host := envutil.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil
+	}
+	port := envutil.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := envutil.Getenv("SMTP_FROM")
+	if _, err := mail.ParseAddress(from); err != nil {
+		return fmt.Errorf("SMTP_FROM %q is not an email address", from)
+	}
+	baseURL := strings.TrimRight(envutil.Getenv("APP_URL"), "/")
+	if u, err := url.Parse(baseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("APP_URL must be the app's address, e.g. https://whoowesme.example.com")
+	}
+
+	Mail = &MailConfig{
+		Addr:     net.JoinHostPort(host, port),
+		Username: envutil.Getenv("SMTP_USERNAME"),
+		Password: envutil.Getenv("SMTP_PASSWORD"),
+		From:     from,
+		BaseURL:  baseURL,
+	}
+	return nil
+}
+
+// Enabled reports whether any login is configured. Without one the app runs
+// everyone as the dev user.
+func Enabled() bool {
+	return Provider != nil || Mail != nil || ForwardAuth != nil
+}
+
+// SendLoginLink emails to a link that signs them in with token. Credentials
+// are only sent over STARTTLS, or to localhost.
+func (m *MailConfig) SendLoginLink(to, token string) error {
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", to, err)
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+
+	var a smtp.Auth
+	if m.Username != "" {
+		host, _, _ := net.SplitHostPort(m.Addr)
+		a = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	link := m.BaseURL + "/login/email/" + url.PathEscape(Sign(token))
+	return smtp.SendMail(m.Addr, a, from.Address, []string{rcpt.Address}, loginLinkMessage(m.From, rcpt.Address, link))
+}
+
+func loginLinkMessage(from, to, link string) []byte {
+	lines := []string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: Your Who Owes Me sign-in link",
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		fmt.Sprintf("Open this link to sign in to Who Owes Me. It works once, within %d minutes:", int(LoginLinkTTL.Minutes())),
+		"",
+		link,
+		"",
+		"If you did not ask to sign in, you can ignore this email.",
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
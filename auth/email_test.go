@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"bufio"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fakeSMTP accepts one message on a local port and returns its address and
+// a channel that yields the message data.
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	data := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+		reply := func(s string) { rw.WriteString(s + "\r\n"); rw.Flush() }
+		reply("220 fake ESMTP")
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 fake")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				var msg strings.Builder
+				for {
+					l, err := rw.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					msg.WriteString(l)
+				}
+				data <- msg.String()
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), data
+}
+
+func TestSendLoginLink(t *testing.T) {
+	addr, data := fakeSMTP(t)
+	m := &MailConfig{Addr: addr, From: "Who Owes Me <team@example.com>", BaseURL: "https://app.example.com"}
+	if err := m.SendLoginLink("alice@example.com", "tok123"); err != nil {
+		t.Fatalf("SendLoginLink: %v", err)
+	}
+	msg := <-data
+	if !strings.Contains(msg, "To: alice@example.com\r\n") || !strings.Contains(msg, "Subject: Your Who Owes Me sign-in link") {
+		t.Errorf("headers missing from:\n%s", msg)
+	}
+	i := strings.Index(msg, "https://app.example.com/login/email/")
+	if i < 0 {
+		t.Fatalf("no link in:\n%s", msg)
+	}
+	signed, _ := url.PathUnescape(strings.Fields(msg[i+len("https://app.example.com/login/email/"):])[0])
+	if token, err := Verify(signed); err != nil || token != "tok123" {
+		t.Errorf("link token = %q, %v", token, err)
+	}
+
+	if err := m.SendLoginLink("alice@example.com\r\nBcc: eve@example.com", "t"); err == nil {
+		t.Error("a recipient with a header injection was accepted")
+	}
+}
+
+func TestInitMail(t *testing.T) {
+	t.Cleanup(func() { Mail = nil })
+	t.Setenv("SMTP_HOST", "")
+	if err := InitMail(); err != nil || Mail != nil {
+		t.Fatalf("InitMail without SMTP_HOST = %v, %+v", err, Mail)
+	}
+
+	t.Setenv("SMTP_HOST", "mailpit")
+	t.Setenv("SMTP_FROM", "team@example.com")
+	t.Setenv("APP_URL", "whoowesme.example.com")
+	if err := InitMail(); err == nil {
+		t.Error("APP_URL without a scheme was accepted")
+	}
+	t.Setenv("APP_URL", "https://whoowesme.example.com/")
+	t.Setenv("SMTP_FROM", "not an address")
+	if err := InitMail(); err == nil {
+		t.Error("invalid SMTP_FROM was accepted")
+	}
+	t.Setenv("SMTP_FROM", "team@example.com")
+	t.Setenv("SMTP_PORT", "1025")
+	if err := InitMail(); err != nil || Mail.Addr != "mailpit:1025" || Mail.BaseURL != "https://whoowesme.example.com" {
+		t.Errorf("InitMail = %v, %+v", err, Mail)
+	}
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"who-owes-me/internal/envutil"
+)
+
+// ForwardAuthIssuer is the identity issuer of logins vouched for by a
+// forward-auth proxy. Their subject is the username header.
+const ForwardAuthIssuer = "forward-auth"
+
+// ForwardAuthConfig trusts the login headers a reverse proxy such as Caddy
+// with Authelia forward-auth sets, but only on requests from its addresses.
+type ForwardAuthConfig struct {
+	Trusted      []netip.Prefix
+	UserHeader   string
+	GroupsHeader string // comma-separated
+	EmailHeader  string
+	NameHeader   string
+	LogoutURL    string // where /logout sends forward-auth logins; "" for /login
+}
+
+// ForwardAuth is set by InitForwardAuth when FORWARD_AUTH is on.
+var ForwardAuth *ForwardAuthConfig
+
+// InitForwardAuth turns on forward-auth when FORWARD_AUTH=true. The proxy's
+// addresses come from TRUSTED_PROXIES, comma-separated CIDRs or IPs; the
+// headers from FORWARD_AUTH_{USER,GROUPS,EMAIL,NAME}_HEADER, defaulting to
+// Authelia's Remote-User, Remote-Groups, Remote-Email and Remote-Name.
+func InitForwardAuth() error {
+	if envutil.Getenv("FORWARD_AUTH") != "true" {
+		return nil
+	}
+	trusted, err := ParseTrustedProxies(envutil.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return err
+	}
+	if len(trusted) == 0 {
+		return fmt.Errorf("TRUSTED_PROXIES must list the proxy's addresses")
+	}
+	header := func(name, def string) string {
+		if v := strings.TrimSpace(envutil.Getenv(name)); v != "" {
+			return v
+		}
+		return def
+	}
+	ForwardAuth = &ForwardAuthConfig{
+		Trusted:      trusted,
+		UserHeader:   header("FORWARD_AUTH_USER_HEADER", "Remote-User"),
+		GroupsHeader: header("FORWARD_AUTH_GROUPS_HEADER", "Remote-Groups"),
+		EmailHeader:  header("FORWARD_AUTH_EMAIL_HEADER", "Remote-Email"),
+		NameHeader:   header("FORWARD_AUTH_NAME_HEADER", "Remote-Name"),
+		LogoutURL:    envutil.Getenv("FORWARD_AUTH_LOGOUT_URL"),
+	}
+	return nil
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs and single IPs.
+func ParseTrustedProxies(spec string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(item); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an IP or CIDR", item)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// ForwardLogin is a login a trusted proxy vouched for.
+type ForwardLogin struct {
+	Username string
+	Groups   []string
+	Email    string
+	Name     string
+}
+
+// Claims are the login as OIDC claims, so ROLE_MAPPING applies to it as it
+// does at the OIDC callback.
+func (l *ForwardLogin) Claims() map[string]any {
+	groups := make([]any, len(l.Groups))
+	for i, g := range l.Groups {
+		groups[i] = g
+	}
+	return map[string]any{
+		"preferred_username": l.Username,
+		"groups":             groups,
+		"email":              l.Email,
+		"name":               l.Name,
+	}
+}
+
+// Trusts reports whether the request came straight from a trusted proxy.
+func (c *ForwardAuthConfig) Trusts(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range c.Trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Login returns the login in the request's headers, if it came from a
+// trusted proxy and names a user. Headers from anywhere else are ignored.
+func (c *ForwardAuthConfig) Login(r *http.Request) (*ForwardLogin, bool) {
+	username := strings.TrimSpace(r.Header.Get(c.UserHeader))
+	if username == "" || !c.Trusts(r) {
+		return nil, false
+	}
+	login := &ForwardLogin{
+		Username: username,
+		Email:    strings.TrimSpace(r.Header.Get(c.EmailHeader)),
+		Name:     strings.TrimSpace(r.Header.Get(c.NameHeader)),
+	}
+	for _, g := range strings.Split(r.Header.Get(c.GroupsHeader), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			login.Groups = append(login.Groups, g)
+		}
+	}
+	return login, true
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	got, err := ParseTrustedProxies(" 10.0.0.0/8, 192.168.1.7 ,fd00::/8,")
+	if err != nil || len(got) != 3 || got[1].String() != "192.168.1.7/32" {
+		t.Errorf("ParseTrustedProxies = %v, %v", got, err)
+	}
+	if _, err := ParseTrustedProxies("caddy"); err == nil {
+		t.Error("a hostname was accepted")
+	}
+}
+
+func TestForwardLogin(t *testing.T) {
+	trusted, _ := ParseTrustedProxies("192.0.2.0/24,::1")
+	c := &ForwardAuthConfig{Trusted: trusted, UserHeader: "Remote-User", GroupsHeader: "Remote-Groups", EmailHeader: "Remote-Email", NameHeader: "Remote-Name"}
+
+	for _, tt := range []struct {
+		remote, user string
+		ok           bool
+	}{
+		{"192.0.2.10:4000", "alice", true},
+		{"[::1]:4000", "alice", true},
+		{"[::ffff:192.0.2.10]:4000", "alice", true},
+		{"203.0.113.5:4000", "alice", false},
+		{"192.0.2.10:4000", "", false},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		r.Header.Set("Remote-User", tt.user)
+		r.Header.Set("Remote-Groups", "whoowesme_admin, dev")
+		r.Header.Set("Remote-Email", "alice@example.com")
+		login, ok := c.Login(r)
+		if ok != tt.ok {
+			t.Errorf("Login from %s as %q = %v, want %v", tt.remote, tt.user, ok, tt.ok)
+			continue
+		}
+		if ok && (login.Username != "alice" || !reflect.DeepEqual(login.Groups, []string{"whoowesme_admin", "dev"}) || login.Email != "alice@example.com") {
+			t.Errorf("Login = %+v", login)
+		}
+	}
+
+	login := &ForwardLogin{Username: "alice", Groups: []string{"whoowesme_admin"}}
+	rules, _ := ParseRoleMapping("groups=whoowesme_admin:admin")
+	if got := ResolveRole(rules, login.Claims()); got != RoleAdmin {
+		t.Errorf("ResolveRole(forward claims) = %s, want admin", got)
+	}
+}
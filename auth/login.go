@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"who-owes-me/internal/envutil"
+)
+
+const (
+	loginCookie = "oauth_state"
+	// loginTTL bounds how long the user may take at the identity provider.
+	loginTTL = 10 * time.Minute
+)
+
+// ErrLoginState is returned for a callback that does not match the login
+// that started it, or that came too late.
+var ErrLoginState = errors.New("login state is missing, invalid or expired")
+
+// LoginState ties a callback to the login redirect that started it: the
+// OAuth2 state, the PKCE verifier and the ID token nonce.
+type LoginState struct {
+	State    string
+	Verifier string
+	Nonce    string
+	Expires  time.Time
+}
+
+// StartLogin creates a fresh login state, keeps it in a short-lived signed
+// cookie and returns the provider URL to redirect to.
+func StartLogin(w http.ResponseWriter) string {
+	ls := LoginState{
+		State:    NewToken(),
+		Verifier: oauth2.GenerateVerifier(),
+		Nonce:    NewToken(),
+		Expires:  time.Now().Add(loginTTL),
+	}
+	value := strings.Join([]string{ls.State, ls.Verifier, ls.Nonce, strconv.FormatInt(ls.Expires.Unix(), 10)}, "|")
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookie,
+		Value:    Sign(value),
+		Path:     "/",
+		Expires:  ls.Expires,
+		MaxAge:   int(loginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   envutil.Getenv("APP_ENV") == "production",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return OAuth2Config.AuthCodeURL(ls.State, oauth2.S256ChallengeOption(ls.Verifier), oidc.Nonce(ls.Nonce))
+}
+
+// FinishLogin checks the callback's state against the login cookie, which it
+// clears, and returns the login state for the code exchange.
+func FinishLogin(w http.ResponseWriter, r *http.Request) (*LoginState, error) {
+	signed, err := GetCookie(r, loginCookie)
+	ClearCookie(w, loginCookie)
+	if err != nil {
+		return nil, ErrLoginState
+	}
+	value, err := Verify(signed)
+	if err != nil {
+		return nil, ErrLoginState
+	}
+	parts := strings.Split(value, "|")
+	if len(parts) != 4 {
+		return nil, ErrLoginState
+	}
+	expires, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || time.Now().After(time.Unix(expires, 0)) {
+		return nil, ErrLoginState
+	}
+	ls := &LoginState{State: parts[0], Verifier: parts[1], Nonce: parts[2], Expires: time.Unix(expires, 0)}
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("state")), []byte(ls.State)) != 1 {
+		return nil, ErrLoginState
+	}
+	return ls, nil
+}
+
+// CheckNonce verifies the ID token was issued for this login.
+func (ls *LoginState) CheckNonce(idToken *oidc.IDToken) error {
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(ls.Nonce)) != 1 {
+		return errors.New("ID token nonce does not match the login")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+func TestLoginState(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "")
+	InitSessionKeys()
+	saved := OAuth2Config
+	OAuth2Config = oauth2.Config{ClientID: "app", Endpoint: oauth2.Endpoint{AuthURL: "https://idp.example/auth"}}
+	t.Cleanup(func() { OAuth2Config = saved })
+
+	rec := httptest.NewRecorder()
+	redirect, err := url.Parse(StartLogin(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := redirect.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" || q.Get("state") == "" {
+		t.Fatalf("login redirect %s lacks PKCE, nonce or state", redirect)
+	}
+	cookie := rec.Result().Cookies()[0]
+	if cookie.MaxAge <= 0 || cookie.MaxAge > int(loginTTL.Seconds()) {
+		t.Errorf("login cookie MaxAge = %d", cookie.MaxAge)
+	}
+
+	callback := func(state string, c *http.Cookie) (*LoginState, error) {
+		req := httptest.NewRequest(http.MethodGet, "/callback?code=x&state="+url.QueryEscape(state), nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		return FinishLogin(httptest.NewRecorder(), req)
+	}
+
+	ls, err := callback(q.Get("state"), cookie)
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if ls.Nonce != q.Get("nonce") || oauth2.S256ChallengeFromVerifier(ls.Verifier) != q.Get("code_challenge") {
+		t.Error("login state does not match the redirect")
+	}
+	if err := ls.CheckNonce(&oidc.IDToken{Nonce: ls.Nonce}); err != nil {
+		t.Errorf("CheckNonce(matching) = %v", err)
+	}
+	if err := ls.CheckNonce(&oidc.IDToken{Nonce: "other"}); err == nil {
+		t.Error("CheckNonce accepted another login's nonce")
+	}
+
+	if _, err := callback("forged", cookie); !errors.Is(err, ErrLoginState) {
+		t.Errorf("wrong state = %v", err)
+	}
+	if _, err := callback(q.Get("state"), nil); !errors.Is(err, ErrLoginState) {
+		t.Errorf("missing cookie = %v", err)
+	}
+	expired := &http.Cookie{Name: loginCookie, Value: Sign(ls.State + "|" + ls.Verifier + "|" + ls.Nonce + "|" +
+		strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10))}
+	if _, err := callback(ls.State, expired); !errors.Is(err, ErrLoginState) {
+		t.Errorf("expired cookie = %v", err)
+	}
+}
@@ -2,16 +2,10 @@ package auth
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/rand"
-	"crypto/sha256"
-	"crypto/subtle"
 	"crypto/tls"
-	"encoding/hex"
 	"fmt"
 	"net"
 	"net/http"
-	"strings"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
@@ -22,13 +16,8 @@ var (
 	Provider     *oidc.Provider
 	OAuth2Config oauth2.Config
 	Verifier     *oidc.IDTokenVerifier
-	cookieSecret = make([]byte, 32)
 )
 
-func init() {
-	rand.Read(cookieSecret)
-}
-
 // GetClientContext creates an OIDC context with proper local docker mapping and HTTP overrides
 func GetClientContext(ctx context.Context) context.Context {
 	customTransport := http.DefaultTransport.(*http.Transport).Clone()
@@ -74,15 +63,25 @@ func InitOIDC() error {
 		ClientSecret: clientSecret,
 		RedirectURL:  redirectURL,
 		Endpoint:     provider.Endpoint(),
-		Scopes:       []string{oidc.ScopeOpenID, "profile", "email", "groups"},
+		// offline_access asks for a refresh token, so sessions can be
+		// renewed without sending the user back to the login page.
+		Scopes: []string{oidc.ScopeOpenID, oidc.ScopeOfflineAccess, "profile", "email", "groups"},
 	}
 
 	return nil
 }
 
+// Refresh trades a refresh token for fresh tokens. The provider may rotate
+// the refresh token, so callers must keep the one returned.
+func Refresh(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	return OAuth2Config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+}
+
 type CustomClaims struct {
 	Groups            []string `json:"groups"`
 	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
 	PreferredUsername string   `json:"preferred_username"`
 }
 
@@ -117,45 +116,3 @@ func GetCookie(r *http.Request, name string) (string, error) {
 	}
 	return cookie.Value, nil
 }
-
-// SetSessionState securely signs and sets the user session state
-func SetSessionState(w http.ResponseWriter, isAdmin bool, username string) {
-	adminStr := "false"
-	if isAdmin {
-		adminStr = "true"
-	}
-	val := fmt.Sprintf("%s|%s", adminStr, username)
-	
-	mac := hmac.New(sha256.New, cookieSecret)
-	mac.Write([]byte(val))
-	sig := hex.EncodeToString(mac.Sum(nil))
-	
-	SetCookie(w, "app_session", val+"|"+sig)
-}
-
-// GetSessionState retrieves and verifies the session state from the signed cookie
-func GetSessionState(r *http.Request) (bool, string, error) {
-	cookie, err := GetCookie(r, "app_session")
-	if err != nil {
-		return false, "", err
-	}
-	
-	parts := strings.Split(cookie, "|")
-	if len(parts) != 3 {
-		return false, "", fmt.Errorf("invalid cookie format")
-	}
-	
-	adminStr := parts[0]
-	username := parts[1]
-	sig := parts[2]
-
-	val := fmt.Sprintf("%s|%s", adminStr, username)
-	mac := hmac.New(sha256.New, cookieSecret)
-	mac.Write([]byte(val))
-	expectedSig := hex.EncodeToString(mac.Sum(nil))
-
-	if subtle.ConstantTimeCompare([]byte(sig), []byte(expectedSig)) == 1 {
-		return adminStr == "true", username, nil
-	}
-	return false, "", fmt.Errorf("invalid cookie signature")
-}
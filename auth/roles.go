@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"who-owes-me/internal/envutil"
+)
+
+// Role is what a login may do, decided from its OIDC claims at login.
+type Role string
+
+const (
+	RoleViewer    Role = "viewer"    // their own ledger
+	RoleCaptain   Role = "captain"   // every ledger and the roster
+	RoleTreasurer Role = "treasurer" // every ledger and the money: splits, sync, write-back
+	RoleAdmin     Role = "admin"     // everything
+)
+
+// roleRank orders roles by privilege; a login matching several rules gets
+// the highest.
+var roleRank = map[Role]int{RoleViewer: 0, RoleCaptain: 1, RoleTreasurer: 2, RoleAdmin: 3}
+
+// Permission guards a group of routes.
+type Permission string
+
+const (
+	PermViewLedgers Permission = "view_ledgers" // the dashboard and anyone's ledger
+	PermEditUsers   Permission = "edit_users"   // add and edit users, link payees
+	PermEditSplits  Permission = "edit_splits"  // splits, sync, reconcile, write-back
+	PermManage      Permission = "manage"       // delete and merge users, data, backups, sessions, audit
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:    nil,
+	RoleCaptain:   {PermViewLedgers, PermEditUsers},
+	RoleTreasurer: {PermViewLedgers, PermEditSplits},
+	RoleAdmin:     {PermViewLedgers, PermEditUsers, PermEditSplits, PermManage},
+}
+
+// Can reports whether the role grants p. Unknown roles grant nothing.
+func (r Role) Can(p Permission) bool {
+	return slices.Contains(rolePermissions[r], p)
+}
+
+// Min returns whichever of r and other ranks lower.
+func (r Role) Min(other Role) Role {
+	if roleRank[other] < roleRank[r] {
+		return other
+	}
+	return r
+}
+
+// ParseRole accepts a role name in any case.
+func ParseRole(s string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := roleRank[role]; !ok {
+		return "", fmt.Errorf("unknown role %q; use admin, treasurer, captain or viewer", s)
+	}
+	return role, nil
+}
+
+// RoleRule grants Role to logins whose claim at Path (dot-separated, into
+// nested objects) equals Value, or contains it when the claim is a list.
+type RoleRule struct {
+	Path  string
+	Value string
+	Role  Role
+}
+
+// defaultRoleMapping keeps the behaviour from before roles existed.
+const defaultRoleMapping = "groups=whoowesme_admin:admin"
+
+// RoleMapping is the ordered list of rules from ROLE_MAPPING.
+var RoleMapping []RoleRule
+
+// InitRoleMapping loads ROLE_MAPPING, a comma-separated list of
+// <claim path>=<value>:<role> rules, e.g.
+//
+//	groups=whoowesme_admin:admin,groups=finance:treasurer,team.captain=true:captain
+//
+// Without it members of the whoowesme_admin group are admins.
+func InitRoleMapping() error {
+	spec := envutil.Getenv("ROLE_MAPPING")
+	if spec == "" {
+		spec = defaultRoleMapping
+	}
+	rules, err := ParseRoleMapping(spec)
+	if err != nil {
+		return err
+	}
+	RoleMapping = rules
+	return nil
+}
+
+// ParseRoleMapping parses the ROLE_MAPPING format described at InitRoleMapping.
+func ParseRoleMapping(spec string) ([]RoleRule, error) {
+	var rules []RoleRule
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		path, rest, ok := strings.Cut(item, "=")
+		i := strings.LastIndexByte(rest, ':')
+		if !ok || i < 0 || strings.TrimSpace(path) == "" {
+			return nil, fmt.Errorf("role rule %q: want <claim path>=<value>:<role>", item)
+		}
+		role, err := ParseRole(rest[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("role rule %q: %w", item, err)
+		}
+		rules = append(rules, RoleRule{Path: strings.TrimSpace(path), Value: rest[:i], Role: role})
+	}
+	return rules, nil
+}
+
+// ResolveRole returns the highest role any rule grants for the claims, or
+// RoleViewer.
+func ResolveRole(rules []RoleRule, claims map[string]any) Role {
+	role := RoleViewer
+	for _, rule := range rules {
+		if roleRank[rule.Role] > roleRank[role] && claimMatches(claimAt(claims, rule.Path), rule.Value) {
+			role = rule.Role
+		}
+	}
+	return role
+}
+
+func claimAt(claims map[string]any, path string) any {
+	var v any = claims
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = obj[key]
+	}
+	return v
+}
+
+func claimMatches(claim any, want string) bool {
+	switch c := claim.(type) {
+	case nil:
+		return false
+	case []any:
+		for _, item := range c {
+			if claimMatches(item, want) {
+				return true
+			}
+		}
+		return false
+	case string:
+		return c == want
+	default:
+		// Booleans and numbers compare by their JSON spelling.
+		return fmt.Sprint(c) == want
+	}
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseRoleMapping(t *testing.T) {
+	rules, err := ParseRoleMapping(" groups=whoowesme_admin:admin, team.role=a:b:Captain ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RoleRule{
+		{Path: "groups", Value: "whoowesme_admin", Role: RoleAdmin},
+		{Path: "team.role", Value: "a:b", Role: RoleCaptain},
+	}
+	if len(rules) != len(want) || rules[0] != want[0] || rules[1] != want[1] {
+		t.Errorf("rules = %+v, want %+v", rules, want)
+	}
+
+	for _, bad := range []string{"groups", "groups=x", "=x:admin", "groups=x:owner"} {
+		if _, err := ParseRoleMapping(bad); err == nil {
+			t.Errorf("ParseRoleMapping(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestResolveRole(t *testing.T) {
+	rules, err := ParseRoleMapping("groups=finance:treasurer,team.captain=true:captain,groups=whoowesme_admin:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(s string) map[string]any {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	tests := []struct {
+		claims string
+		want   Role
+	}{
+		{`{}`, RoleViewer},
+		{`{"groups":["players"]}`, RoleViewer},
+		{`{"groups":"finance"}`, RoleTreasurer},
+		{`{"team":{"captain":true}}`, RoleCaptain},
+		{`{"team":{"captain":false}}`, RoleViewer},
+		{`{"team":true}`, RoleViewer},
+		{`{"groups":["finance"],"team":{"captain":true}}`, RoleTreasurer},
+		{`{"groups":["finance","whoowesme_admin"]}`, RoleAdmin},
+	}
+	for _, tt := range tests {
+		if got := ResolveRole(rules, claims(tt.claims)); got != tt.want {
+			t.Errorf("ResolveRole(%s) = %s, want %s", tt.claims, got, tt.want)
+		}
+	}
+}
+
+func TestRoleCan(t *testing.T) {
+	if RoleViewer.Can(PermViewLedgers) || Role("owner").Can(PermViewLedgers) {
+		t.Error("viewer and unknown roles should grant nothing")
+	}
+	if !RoleCaptain.Can(PermEditUsers) || RoleCaptain.Can(PermEditSplits) {
+		t.Error("captain should edit users but not splits")
+	}
+	if !RoleTreasurer.Can(PermEditSplits) || RoleTreasurer.Can(PermManage) {
+		t.Error("treasurer should edit splits but not manage")
+	}
+	for _, p := range []Permission{PermViewLedgers, PermEditUsers, PermEditSplits, PermManage} {
+		if !RoleAdmin.Can(p) {
+			t.Errorf("admin lacks %s", p)
+		}
+	}
+}
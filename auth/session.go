@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"who-owes-me/internal/envutil"
+)
+
+// SessionCookie names the cookie that holds the signed session token.
+const SessionCookie = "app_session"
+
+const minKeyLength = 32
+
+// sessionKeys sign cookies. The first key signs; all of them verify, so a
+// rotated-out key keeps existing sessions working until it is dropped.
+var sessionKeys [][]byte
+
+// ErrInvalidSignature is returned by Verify for tampered or foreign values.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// InitSessionKeys loads the signing key from SESSION_SECRET and any rotated-out
+// keys from SESSION_SECRET_PREVIOUS (comma-separated); both accept the _FILE
+// variant. Without SESSION_SECRET a random key is used and generated reports
+// true, so every restart logs everyone out.
+func InitSessionKeys() (generated bool, err error) {
+	current := envutil.Getenv("SESSION_SECRET")
+	if current == "" {
+		key := make([]byte, minKeyLength)
+		rand.Read(key)
+		sessionKeys = [][]byte{key}
+		return true, nil
+	}
+
+	keys := [][]byte{[]byte(current)}
+	for _, k := range strings.Split(envutil.Getenv("SESSION_SECRET_PREVIOUS"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, []byte(k))
+		}
+	}
+	for i, k := range keys {
+		if len(k) < minKeyLength {
+			name := "SESSION_SECRET"
+			if i > 0 {
+				name = "SESSION_SECRET_PREVIOUS"
+			}
+			return false, fmt.Errorf("%s must be at least %d characters", name, minKeyLength)
+		}
+	}
+	sessionKeys = keys
+	return false, nil
+}
+
+func sign(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign appends an HMAC of value made with the current session key.
+func Sign(value string) string {
+	if sessionKeys == nil {
+		InitSessionKeys()
+	}
+	return value + "." + sign(sessionKeys[0], value)
+}
+
+// Verify checks a value made by Sign against every session key and returns
+// the original value.
+func Verify(signed string) (string, error) {
+	i := strings.LastIndexByte(signed, '.')
+	if i < 0 {
+		return "", ErrInvalidSignature
+	}
+	value, sig := signed[:i], signed[i+1:]
+	for _, key := range sessionKeys {
+		if hmac.Equal([]byte(sig), []byte(sign(key, value))) {
+			return value, nil
+		}
+	}
+	return "", ErrInvalidSignature
+}
+
+// sealKey derives the encryption key for Seal from a session key, so one
+// secret is not used both to sign and to encrypt.
+func sealKey(key []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("who-owes-me sealed values"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts value with a key derived from the current session key, for
+// secrets the server has to keep, such as OIDC refresh tokens. An empty value
+// stays empty.
+func Seal(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if sessionKeys == nil {
+		InitSessionKeys()
+	}
+	aead, err := sealKey(sessionKeys[0])
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+// Unseal decrypts a value made by Seal with any of the session keys.
+func Unseal(sealed string) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	for _, key := range sessionKeys {
+		aead, err := sealKey(key)
+		if err != nil {
+			return "", err
+		}
+		if len(data) < aead.NonceSize() {
+			break
+		}
+		if value, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil); err == nil {
+			return string(value), nil
+		}
+	}
+	return "", ErrInvalidSignature
+}
+
+// NewToken returns a random URL-safe token.
+func NewToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// HashToken is how tokens are stored, so a leaked table cannot be replayed.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SessionTTL is how long a login lasts, from SESSION_TTL (default 7 days).
+func SessionTTL() time.Duration {
+	if d, err := time.ParseDuration(envutil.Getenv("SESSION_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 7 * 24 * time.Hour
+}
+
+// SetSessionCookie stores the signed token in a cookie that expires with the
+// session.
+func SetSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    Sign(token),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   envutil.Getenv("APP_ENV") == "production",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// SessionToken returns the verified token from the session cookie.
+func SessionToken(r *http.Request) (string, error) {
+	signed, err := GetCookie(r, SessionCookie)
+	if err != nil {
+		return "", err
+	}
+	return Verify(signed)
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSessionKeyRotation(t *testing.T) {
+	oldKey := strings.Repeat("o", 32)
+	newKey := strings.Repeat("n", 32)
+
+	t.Setenv("SESSION_SECRET", oldKey)
+	t.Setenv("SESSION_SECRET_PREVIOUS", "")
+	if _, err := InitSessionKeys(); err != nil {
+		t.Fatal(err)
+	}
+	signed := Sign("token")
+
+	// After rotation the old key still verifies, but new values use the new key.
+	t.Setenv("SESSION_SECRET", newKey)
+	t.Setenv("SESSION_SECRET_PREVIOUS", oldKey)
+	if _, err := InitSessionKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := Verify(signed); err != nil || v != "token" {
+		t.Errorf("Verify(old signature) = %q, %v", v, err)
+	}
+	if resigned := Sign("token"); resigned == signed {
+		t.Error("Sign still uses the rotated-out key")
+	}
+
+	t.Setenv("SESSION_SECRET_PREVIOUS", "")
+	InitSessionKeys()
+	if _, err := Verify(signed); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify after dropping the old key = %v, want ErrInvalidSignature", err)
+	}
+	if _, err := Verify("token.forged"); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify(forged) = %v", err)
+	}
+}
+
+func TestInitSessionKeys(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "")
+	if generated, err := InitSessionKeys(); err != nil || !generated {
+		t.Errorf("no secret = %v, %v; want a generated key", generated, err)
+	}
+	t.Setenv("SESSION_SECRET", "short")
+	if _, err := InitSessionKeys(); err == nil {
+		t.Error("a short SESSION_SECRET was accepted")
+	}
+	t.Setenv("SESSION_SECRET", strings.Repeat("k", 32))
+	t.Setenv("SESSION_SECRET_PREVIOUS", "short")
+	if _, err := InitSessionKeys(); err == nil {
+		t.Error("a short SESSION_SECRET_PREVIOUS was accepted")
+	}
+}
+
+func TestSealRoundTripsAcrossRotation(t *testing.T) {
+	oldKey := strings.Repeat("o", 32)
+	t.Setenv("SESSION_SECRET", oldKey)
+	t.Setenv("SESSION_SECRET_PREVIOUS", "")
+	InitSessionKeys()
+
+	sealed, err := Seal("refresh-token")
+	if err != nil || sealed == "" || strings.Contains(sealed, "refresh-token") {
+		t.Fatalf("Seal = %q, %v", sealed, err)
+	}
+	if again, _ := Seal("refresh-token"); again == sealed {
+		t.Error("Seal is deterministic")
+	}
+	if empty, _ := Seal(""); empty != "" {
+		t.Errorf("Seal(\"\") = %q, want empty", empty)
+	}
+
+	t.Setenv("SESSION_SECRET", strings.Repeat("n", 32))
+	t.Setenv("SESSION_SECRET_PREVIOUS", oldKey)
+	InitSessionKeys()
+	if v, err := Unseal(sealed); err != nil || v != "refresh-token" {
+		t.Errorf("Unseal after rotation = %q, %v", v, err)
+	}
+
+	t.Setenv("SESSION_SECRET_PREVIOUS", "")
+	InitSessionKeys()
+	if _, err := Unseal(sealed); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Unseal after dropping the old key = %v, want ErrInvalidSignature", err)
+	}
+	if _, err := Unseal("refresh-token"); err == nil {
+		t.Error("Unseal accepted a plaintext value")
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// APITokenPrefix starts every personal API token, so a leaked one is easy to
+// recognise.
+const APITokenPrefix = "wom_"
+
+// Scope limits what a personal API token may do on top of its owner's role.
+type Scope string
+
+const (
+	ScopeReadOwn     Scope = "read:own"     // the owner's own ledger
+	ScopeReadAll     Scope = "read:all"     // every ledger
+	ScopeWriteSplits Scope = "write:splits" // splits, sync, reconcile, write-back
+)
+
+// Scopes lists every scope in the order they are offered.
+var Scopes = []Scope{ScopeReadOwn, ScopeReadAll, ScopeWriteSplits}
+
+// scopePermissions is what each scope adds; read:own needs no permission.
+var scopePermissions = map[Scope]Permission{
+	ScopeReadAll:     PermViewLedgers,
+	ScopeWriteSplits: PermEditSplits,
+}
+
+// Permission is what role must hold for a token with the scope, or "" when
+// anyone may have it.
+func (s Scope) Permission() Permission {
+	return scopePermissions[s]
+}
+
+// ParseScopes validates a list of scope names, dropping duplicates.
+func ParseScopes(names []string) ([]Scope, error) {
+	var scopes []Scope
+	for _, name := range names {
+		s := Scope(strings.TrimSpace(name))
+		if !slices.Contains(Scopes, s) {
+			return nil, fmt.Errorf("unknown scope %q", name)
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("choose at least one scope")
+	}
+	return scopes, nil
+}
+
+// ScopesAllow reports whether any of scopes carries p.
+func ScopesAllow(scopes []Scope, p Permission) bool {
+	for _, s := range scopes {
+		if scopePermissions[s] == p {
+			return true
+		}
+	}
+	return false
+}
+
+// BearerToken returns the token from an "Authorization: Bearer" header, or "".
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes([]string{"read:all", " write:splits", "read:all"})
+	if err != nil || len(scopes) != 2 || scopes[0] != ScopeReadAll || scopes[1] != ScopeWriteSplits {
+		t.Errorf("ParseScopes = %v, %v", scopes, err)
+	}
+	for _, bad := range [][]string{nil, {"admin"}, {"read:own", ""}} {
+		if _, err := ParseScopes(bad); err == nil {
+			t.Errorf("ParseScopes(%q) succeeded", bad)
+		}
+	}
+
+	if ScopesAllow([]Scope{ScopeReadOwn}, PermViewLedgers) || !ScopesAllow([]Scope{ScopeReadOwn, ScopeReadAll}, PermViewLedgers) {
+		t.Error("read:all should be what grants PermViewLedgers")
+	}
+	if ScopesAllow(Scopes, PermManage) || ScopesAllow(Scopes, PermEditUsers) {
+		t.Error("no scope should grant managing or editing users")
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	for header, want := range map[string]string{
+		"Bearer wom_abc": "wom_abc",
+		"bearer wom_abc": "wom_abc",
+		"Basic abc":      "",
+		"":               "",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", header)
+		if got := BearerToken(r); got != want {
+			t.Errorf("BearerToken(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"who-owes-me/db"
+	"who-owes-me/internal/envutil"
+)
+
+const usage = `usage: who-owes-me [command]
+
+Without a command the web server is started.
+
+Commands:
+  migrate status        list schema migrations and whether they are applied
+  migrate up [n]        apply pending migrations (all, or the next n)
+  migrate down [n]      revert the last n applied migrations (default 1);
+                        the baseline cannot be reverted
+  copy-to-postgres [file]
+                        copy a SQLite database (default DB_PATH or data.db)
+                        into the empty Postgres database at DATABASE_URL
+  backup [file]         snapshot the SQLite database to file, or into
+                        BACKUP_DIR (keeping BACKUP_KEEP) when no file is given
+  restore <file>        replace the SQLite database with a backup; stop the
+                        server first
+  export [file]         write users and splits to a zip of JSON and CSV
+                        (default who-owes-me-export-<timestamp>.zip)
+  import [-dry-run] [-on-conflict skip|update] <file>
+                        merge an export zip or JSON file into the database;
+                        existing users and splits are kept unless
+                        -on-conflict update is given
+`
+
+// runCommand handles CLI subcommands. It returns false when args do not name
+// a command and the server should start instead.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	var err error
+	switch args[0] {
+	case "migrate":
+		err = runMigrate(args[1:])
+	case "copy-to-postgres":
+		err = runCopyToPostgres(args[1:])
+	case "backup":
+		err = runBackup(args[1:])
+	case "restore":
+		err = runRestore(args[1:])
+	case "export":
+		err = runExport(args[1:])
+	case "import":
+		err = runImport(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("migrate needs one of: status, up, down")
+	}
+
+	steps := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid step count %q", args[1])
+		}
+		steps = n
+	}
+
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch args[0] {
+	case "status":
+		statuses, err := db.MigrationStatuses(conn)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt
+			}
+			fmt.Printf("%04d  %-30s %s\n", s.Version, s.Name, state)
+		}
+	case "up":
+		duplicates, err := db.UpgradeLegacySchema(conn)
+		if err != nil {
+			return err
+		}
+		for _, d := range duplicates {
+			fmt.Printf("warning: %s\n", d)
+		}
+		n, err := db.MigrateUp(conn, steps)
+		fmt.Printf("Applied %d migration(s)\n", n)
+		return err
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		n, err := db.MigrateDown(conn, steps)
+		fmt.Printf("Reverted %d migration(s)\n", n)
+		return err
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	return nil
+}
+
+// prepareDB brings a database up to the current schema, as starting the
+// server would.
+func prepareDB(conn *sql.DB) error {
+	duplicates, err := db.UpgradeLegacySchema(conn)
+	if err != nil {
+		return err
+	}
+	for _, d := range duplicates {
+		fmt.Printf("warning: %s\n", d)
+	}
+	_, err = db.MigrateUp(conn, 0)
+	return err
+}
+
+func runCopyToPostgres(args []string) error {
+	if envutil.Getenv("DATABASE_URL") == "" {
+		return fmt.Errorf("set DATABASE_URL to the target Postgres database")
+	}
+	path := envutil.Getenv("DB_PATH")
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if path == "" {
+		path = "data.db"
+	}
+
+	src, err := db.OpenSQLite(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := db.Open()
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if err := prepareDB(src); err != nil {
+		return fmt.Errorf("migrating %s: %w", path, err)
+	}
+	if err := prepareDB(dst); err != nil {
+		return fmt.Errorf("migrating Postgres: %w", err)
+	}
+
+	results, err := db.CopyDatabase(context.Background(), src, dst)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		fmt.Printf("%-20s %d row(s)\n", r.Table, r.Rows)
+	}
+	fmt.Printf("Copied %s into Postgres\n", path)
+	return nil
+}
+
+func runBackup(args []string) error {
+	conn, err := db.Open()
+	if err != nil {
+		return err
+	}
+	store := db.NewStore(conn)
+	defer store.Close()
+
+	ctx := context.Background()
+	if len(args) > 0 {
+		if err := store.Backup(ctx, args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Backup written to %s\n", args[0])
+		return nil
+	}
+
+	dir := envutil.Getenv("BACKUP_DIR")
+	if dir == "" {
+		return fmt.Errorf("give a file to write or set BACKUP_DIR")
+	}
+	path, err := db.Snapshot(ctx, store, dir, db.BackupKeep())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backup written to %s\n", path)
+	return nil
+}
+
+func runRestore(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("restore needs the backup file to restore")
+	}
+	if envutil.Getenv("DATABASE_URL") != "" {
+		return fmt.Errorf("restore only works for SQLite; use pg_restore for Postgres")
+	}
+	dbPath := envutil.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "data.db"
+	}
+
+	saved, err := db.Restore(context.Background(), args[0], dbPath)
+	if saved != "" {
+		fmt.Printf("Previous database saved to %s\n", saved)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s from %s\n", dbPath, args[0])
+	return nil
+}
+
+// openStore opens the configured database and brings it up to the current
+// schema for commands that read or write rows.
+func openStore() (*db.SQLStore, error) {
+	conn, err := db.Open()
+	if err != nil {
+		return nil, err
+	}
+	if err := prepareDB(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db.NewStore(conn), nil
+}
+
+func runExport(args []string) error {
+	path := "who-owes-me-export-" + time.Now().UTC().Format("20060102-150405") + ".zip"
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	archive, err := store.Export(context.Background())
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := db.WriteArchive(f, archive); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d user(s) and %d split(s) to %s\n", len(archive.Users), len(archive.Splits), path)
+	return nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would change without saving")
+	onConflict := fs.String("on-conflict", db.ImportSkip, "what to do with rows that already exist: skip or update")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import needs the file to import")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	archive, err := db.ReadArchive(data)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	summary, err := store.Import(context.Background(), archive, db.ImportOptions{OnConflict: *onConflict, DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+	for _, note := range summary.Notes {
+		fmt.Println(note)
+	}
+	if summary.DryRun {
+		fmt.Printf("Dry run, nothing saved. %s\n", summary)
+		return nil
+	}
+	fmt.Printf("Imported. %s\n", summary)
+	return nil
+}
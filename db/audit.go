@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audit actions.
+const (
+	AuditMergeUsers          = "merge_users"
+	AuditDeleteUser          = "delete_user"
+	AuditRevokeSessions      = "revoke_sessions"
+	AuditApproveRegistration = "approve_registration"
+	AuditDismissRegistration = "dismiss_registration"
+	AuditLinkIdentity        = "link_identity"
+	AuditCreateAPIToken      = "create_api_token"
+	AuditRevokeAPIToken      = "revoke_api_token"
+	AuditCreateShareLink     = "create_share_link"
+	AuditRevokeShareLink     = "revoke_share_link"
+	AuditStartImpersonation  = "start_impersonation"
+	AuditEndImpersonation    = "end_impersonation"
+)
+
+// AuditEntry records an admin action that rewrote data.
+type AuditEntry struct {
+	ID        int    `json:"id"`
+	CreatedAt string `json:"created_at"`
+	Actor     string `json:"actor"` // login of the admin who acted
+	Action    string `json:"action"`
+	Details   string `json:"details"`
+}
+
+// MergeResult describes a MergeUsers call.
+type MergeResult struct {
+	From     User
+	Into     User
+	Moved    int // splits moved to the survivor as they were
+	Combined int // splits added into one the survivor already had
+}
+
+func (m MergeResult) String() string {
+	s := fmt.Sprintf("merged %s (%s, #%d) into %s (%s, #%d): %d split(s) moved, %d combined",
+		m.From.Name, m.From.OIDCSub, m.From.ID, m.Into.Name, m.Into.OIDCSub, m.Into.ID, m.Moved, m.Combined)
+	if len(m.From.PayeeIDs) > 0 {
+		s += "; payees " + strings.Join(m.From.PayeeIDs, ", ") + " moved"
+	}
+	return s
+}
+
+func (s *SQLStore) AddAuditEntry(ctx context.Context, actor, action, details string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := s.addAuditEntry(ctx, tx, actor, action, details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addAuditEntry writes the entry inside tx, so it commits or rolls back with
+// the change it describes.
+func (s *SQLStore) addAuditEntry(ctx context.Context, tx *sql.Tx, actor, action, details string) error {
+	_, err := tx.ExecContext(ctx, s.dialect.rebind("INSERT INTO audit_log (created_at, actor, action, details) VALUES (?, ?, ?, ?)"),
+		timestamp(time.Now()), actor, action, details)
+	return err
+}
+
+// GetAuditLog returns the newest entries first, at most limit of them.
+func (s *SQLStore) GetAuditLog(ctx context.Context, limit int) ([]AuditEntry, error) {
+	rows, err := s.query(ctx, "SELECT id, created_at, actor, action, details FROM audit_log ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.Actor, &e.Action, &e.Details); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,271 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"who-owes-me/internal/envutil"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrBackupUnsupported is returned when backing up anything but SQLite.
+// Postgres has its own tools for this (pg_dump).
+var ErrBackupUnsupported = errors.New("online backup is only supported for SQLite; use pg_dump for Postgres")
+
+const (
+	snapshotPrefix = "who-owes-me-"
+	snapshotSuffix = ".db"
+	// snapshotTime has fixed-width nanoseconds, so a scheduled snapshot and a
+	// manual one in the same second get different names that still sort by
+	// age.
+	snapshotTime = "20060102-150405.000000000"
+
+	defaultBackupKeep = 7
+)
+
+// BackupKeep is how many snapshots to keep in BACKUP_DIR, from BACKUP_KEEP.
+func BackupKeep() int {
+	if n, err := strconv.Atoi(envutil.Getenv("BACKUP_KEEP")); err == nil {
+		return n
+	}
+	return defaultBackupKeep
+}
+
+// Backup writes a consistent copy of the database to path with SQLite's online
+// backup API, so it is safe to run while the server is writing. path must not
+// exist yet.
+func (s *SQLStore) Backup(ctx context.Context, path string) error {
+	if s.dialect != dialectSQLite {
+		return ErrBackupUnsupported
+	}
+	return backupSQLite(ctx, s.db, path)
+}
+
+func backupSQLite(ctx context.Context, src *sql.DB, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	dst, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(dc any) error {
+		return srcConn.Raw(func(sc any) error {
+			backup, err := dc.(*sqlite3.SQLiteConn).Backup("main", sc.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// Snapshot backs the store up into dir under a timestamped name, then deletes
+// all but the newest keep snapshots there. keep <= 0 keeps every snapshot.
+func Snapshot(ctx context.Context, store Store, dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := snapshotPath(dir)
+	if err := store.Backup(ctx, path); err != nil {
+		return "", err
+	}
+	if keep > 0 {
+		if err := pruneSnapshots(dir, keep); err != nil {
+			return path, fmt.Errorf("pruning old snapshots: %w", err)
+		}
+	}
+	return path, nil
+}
+
+// snapshotPath returns a new snapshot name in dir that is not taken yet.
+func snapshotPath(dir string) string {
+	for {
+		path := filepath.Join(dir, snapshotPrefix+time.Now().UTC().Format(snapshotTime)+snapshotSuffix)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+	}
+}
+
+// pruneSnapshots deletes the oldest snapshots in dir beyond keep. Snapshot
+// names embed a sortable timestamp, so name order is age order.
+func pruneSnapshots(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), snapshotPrefix) && strings.HasSuffix(e.Name(), snapshotSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// StartBackups snapshots the store into dir every interval until ctx is
+// cancelled, keeping the newest keep snapshots.
+func StartBackups(ctx context.Context, store Store, dir string, interval time.Duration, keep int) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				path, err := Snapshot(ctx, store, dir, keep)
+				if err != nil {
+					fmt.Printf("Scheduled backup failed: %v\n", err)
+					continue
+				}
+				fmt.Printf("Scheduled backup written to %s\n", path)
+			}
+		}
+	}()
+}
+
+// Restore replaces the SQLite database at dbPath with the backup at
+// backupPath. The backup must pass an integrity check and must not come from
+// a newer schema than this build knows; older backups are migrated on the
+// next start. The current database, if any, is first saved next to it as
+// <dbPath>.pre-restore-<timestamp>, whose path is returned. The server must
+// not be running.
+func Restore(ctx context.Context, backupPath, dbPath string) (string, error) {
+	version, err := checkBackup(ctx, backupPath)
+	if err != nil {
+		return "", err
+	}
+	if latest := latestSchemaVersion(dialectSQLite); version > latest {
+		return "", fmt.Errorf("backup is at schema version %d, newer than this build's %d; restore it with a newer release", version, latest)
+	}
+
+	var saved string
+	if _, err := os.Stat(dbPath); err == nil {
+		current, err := OpenSQLite(dbPath)
+		if err != nil {
+			return "", err
+		}
+		saved = dbPath + ".pre-restore-" + time.Now().UTC().Format("20060102-150405")
+		err = backupSQLite(ctx, current, saved)
+		current.Close()
+		if err != nil {
+			return "", fmt.Errorf("saving current database: %w", err)
+		}
+	}
+
+	// Copy next to the target and rename, so the swap is atomic.
+	tmp := dbPath + ".restoring"
+	if err := copyFile(backupPath, tmp); err != nil {
+		os.Remove(tmp)
+		return saved, err
+	}
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp)
+			return saved, err
+		}
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		os.Remove(tmp)
+		return saved, err
+	}
+	return saved, nil
+}
+
+// checkBackup opens a backup read-only and returns its schema version. A
+// database without schema_migrations predates migrations and reports 0.
+func checkBackup(ctx context.Context, path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var check string
+	if err := conn.QueryRowContext(ctx, "PRAGMA quick_check").Scan(&check); err != nil {
+		return 0, fmt.Errorf("%s is not a readable SQLite database: %w", path, err)
+	}
+	if check != "ok" {
+		return 0, fmt.Errorf("%s failed the integrity check: %s", path, check)
+	}
+
+	var tables int
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'").Scan(&tables); err != nil {
+		return 0, err
+	}
+	if tables == 0 {
+		return 0, fmt.Errorf("%s is not a who-owes-me database", path)
+	}
+
+	var version sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if err != nil && strings.Contains(err.Error(), "no such table") {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := newTestStore(t)
+	createTestUser(t, store, "Alice", "alice", "p1")
+
+	backupPath := filepath.Join(dir, "backup.db")
+	if err := store.Backup(ctx, backupPath); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	if err := store.Backup(ctx, backupPath); err == nil {
+		t.Error("Backup over an existing file succeeded")
+	}
+
+	// A live database that has moved on since the backup.
+	dbPath := filepath.Join(dir, "data.db")
+	live, err := openConn("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateUp(live, 0); err != nil {
+		t.Fatal(err)
+	}
+	NewSQLiteStore(live).CreateUser(ctx, "Bob", "bob", "regular", "")
+	live.Close()
+
+	saved, err := Restore(ctx, backupPath, dbPath)
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if _, err := os.Stat(saved); err != nil {
+		t.Errorf("previous database was not saved: %v", err)
+	}
+
+	restored, err := OpenSQLite(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	users, _ := NewSQLiteStore(restored).GetAllUsers(ctx)
+	if len(users) != 1 || users[0].OIDCSub != "alice" {
+		t.Errorf("users after restore = %+v, want only alice", users)
+	}
+}
+
+func TestRestoreRejectsNewerSchema(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := newTestStore(t)
+
+	backupPath := filepath.Join(dir, "backup.db")
+	if err := store.Backup(ctx, backupPath); err != nil {
+		t.Fatal(err)
+	}
+	conn, _ := sql.Open("sqlite3", backupPath)
+	conn.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (9999, 'future', '')")
+	conn.Close()
+
+	dbPath := filepath.Join(dir, "data.db")
+	if _, err := Restore(ctx, backupPath, dbPath); err == nil {
+		t.Fatal("Restore of a newer schema succeeded")
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Error("a rejected restore touched the database file")
+	}
+
+	notDB := filepath.Join(dir, "notes.txt")
+	os.WriteFile(notDB, []byte("not a database"), 0o644)
+	if _, err := Restore(ctx, notDB, dbPath); err == nil {
+		t.Error("Restore of a non-database file succeeded")
+	}
+}
+
+func TestSnapshotPrunesOldest(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"who-owes-me-20260101-000000.db", "who-owes-me-20260102-000000.db", "who-owes-me-20260103-000000.db", "unrelated.db"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+	}
+
+	if err := pruneSnapshots(dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{"unrelated.db", "who-owes-me-20260102-000000.db", "who-owes-me-20260103-000000.db"}
+	if len(names) != len(want) {
+		t.Fatalf("files after prune = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("files after prune = %v, want %v", names, want)
+			break
+		}
+	}
+}
+
+func TestSnapshotNamesAreUnique(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := newTestStore(t)
+
+	first, err := Snapshot(ctx, store, dir, 0)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	second, err := Snapshot(ctx, store, dir, 0)
+	if err != nil {
+		t.Fatalf("second Snapshot in the same second: %v", err)
+	}
+	if first == second || second < first {
+		t.Errorf("snapshots %s then %s, want distinct names in age order", first, second)
+	}
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// copyTables lists every table CopyDatabase moves, parents before the rows
+// that reference them.
+var copyTables = []string{"users", "user_payees", "user_identities", "share_links", "share_link_views", "expense_splits", "actual_writebacks", "audit_log", "sessions", "registrations", "api_tokens", "login_links"}
+
+type CopyResult struct {
+	Table string
+	Rows  int
+}
+
+// CopyDatabase copies every row of copyTables from src into dst in a single
+// transaction, keeping IDs. Both databases must be migrated to the same
+// schema version and dst must be empty. On Postgres the ID sequences are moved
+// past the copied rows afterwards.
+func CopyDatabase(ctx context.Context, src, dst *sql.DB) ([]CopyResult, error) {
+	srcVersion, err := SchemaVersion(src)
+	if err != nil {
+		return nil, err
+	}
+	dstVersion, err := SchemaVersion(dst)
+	if err != nil {
+		return nil, err
+	}
+	if srcVersion != dstVersion {
+		return nil, fmt.Errorf("source is at schema version %d but target is at %d", srcVersion, dstVersion)
+	}
+
+	d := dialectOf(dst)
+	tx, err := dst.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range copyTables {
+		var n int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return nil, fmt.Errorf("target table %s is not empty", table)
+		}
+	}
+
+	var results []CopyResult
+	for _, table := range copyTables {
+		n, err := copyTable(ctx, src, tx, d, table)
+		if err != nil {
+			return nil, fmt.Errorf("copying %s: %w", table, err)
+		}
+		results = append(results, CopyResult{Table: table, Rows: n})
+
+		if d == dialectPostgres {
+			_, err := tx.ExecContext(ctx, fmt.Sprintf(
+				"SELECT setval(pg_get_serial_sequence('%[1]s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %[1]s", table))
+			if err != nil {
+				return nil, fmt.Errorf("resetting %s id sequence: %w", table, err)
+			}
+		}
+	}
+	return results, tx.Commit()
+}
+
+func copyTable(ctx context.Context, src *sql.DB, tx *sql.Tx, d dialect, table string) (int, error) {
+	rows, err := src.QueryContext(ctx, "SELECT * FROM "+table+" ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	insert, err := tx.PrepareContext(ctx, d.rebind(fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders)))
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+
+	values := make([]any, len(columns))
+	ptrs := make([]any, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	n := 0
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return n, err
+		}
+		// Drivers may hand TEXT back as []byte, which Postgres would store
+		// as bytea.
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		if _, err := insert.ExecContext(ctx, values...); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
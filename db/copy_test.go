@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCopyDatabase(t *testing.T) {
+	src := newTestStore(t)
+	dst := newTestStore(t)
+	ctx := context.Background()
+
+	alice := createTestUser(t, src, "Alice", "alice", "p1")
+	bob := createTestUser(t, src, "Bob", "bob", "")
+	src.SetSplit(ctx, "t1", alice.ID, -500, "2026-01-01", "fee")
+	src.SetAutoSplit(ctx, "t2", bob.ID, 700, "2026-01-02", "payment")
+	src.ArchiveSplitsForTx(ctx, "t1")
+	src.SetWriteback(ctx, "receivable", "1", "tx9", -500, "Receivable: Alice")
+
+	results, err := CopyDatabase(ctx, src.db, dst.db)
+	if err != nil {
+		t.Fatalf("CopyDatabase: %v", err)
+	}
+	want := map[string]int{"users": 2, "user_payees": 1, "expense_splits": 2, "actual_writebacks": 1}
+	for _, r := range results {
+		if r.Rows != want[r.Table] {
+			t.Errorf("copied %d %s rows, want %d", r.Rows, r.Table, want[r.Table])
+		}
+	}
+
+	got, err := dst.GetUserByID(ctx, bob.ID)
+	if err != nil || got.OIDCSub != "bob" {
+		t.Errorf("bob in target = %+v, %v; IDs must be preserved", got, err)
+	}
+	splits, _ := dst.GetSplitsForUser(ctx, bob.ID)
+	if len(splits) != 1 || splits[0].AmountOwed != 700 || !splits[0].AutoCreated {
+		t.Errorf("bob's splits in target = %+v", splits)
+	}
+	ids, _ := dst.GetSplitTransactionIDs(ctx)
+	if !ids["t1"] {
+		t.Error("archived split was not copied")
+	}
+	w, _ := dst.GetWriteback(ctx, "receivable", "1")
+	if w == nil || w.ActualTransactionID != "tx9" {
+		t.Errorf("writeback in target = %+v", w)
+	}
+
+	// New rows must not collide with copied IDs.
+	carol := createTestUser(t, dst, "Carol", "carol", "")
+	if carol.ID <= bob.ID {
+		t.Errorf("new user got ID %d, not past copied ID %d", carol.ID, bob.ID)
+	}
+
+	if _, err := CopyDatabase(ctx, src.db, dst.db); err == nil {
+		t.Error("CopyDatabase into a non-empty target succeeded")
+	}
+}
@@ -5,70 +5,61 @@ import (
 	"fmt"
 	"log"
 
-	_ "github.com/mattn/go-sqlite3"
 	"who-owes-me/internal/envutil"
 )
 
-var DB *sql.DB
+// Open connects to the configured database without touching the schema.
+// DATABASE_URL selects Postgres; otherwise the SQLite file at DB_PATH is used.
+func Open() (*sql.DB, error) {
+	if url := envutil.Getenv("DATABASE_URL"); url != "" {
+		return openConn("postgres", url)
+	}
 
-func InitDB() {
-	var err error
 	dbPath := envutil.Getenv("DB_PATH")
 	if dbPath == "" {
 		dbPath = "data.db"
 	}
+	return openConn("sqlite3", dbPath)
+}
 
-	DB, err = sql.Open("sqlite3", dbPath)
+func openConn(driver, dsn string) (*sql.DB, error) {
+	conn, err := sql.Open(driver, dsn)
 	if err != nil {
-		log.Fatalf("Error opening database: %v", err)
+		return nil, err
 	}
-
-	if err = DB.Ping(); err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+	if err = conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
 	}
-
-	createTables()
+	return conn, nil
 }
 
-func createTables() {
-	usersTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT NOT NULL,
-		oidc_sub TEXT UNIQUE NOT NULL,
-		aid_class TEXT NOT NULL,
-		actual_payee_id TEXT NOT NULL
-	);`
-
-	expenseSplitsTable := `
-	CREATE TABLE IF NOT EXISTS expense_splits (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		actual_transaction_id TEXT NOT NULL,
-		user_id INTEGER NOT NULL,
-		amount_owed INTEGER NOT NULL,
-		auto_created INTEGER NOT NULL DEFAULT 0,
-		FOREIGN KEY (user_id) REFERENCES users (id),
-		UNIQUE(actual_transaction_id, user_id)
-	);`
-
-	_, err := DB.Exec(usersTable)
+// InitDB opens the configured database, applies pending migrations and
+// returns the store the handlers use.
+func InitDB() Store {
+	conn, err := Open()
 	if err != nil {
-		log.Fatalf("Error creating users table: %v", err)
+		log.Fatalf("Error connecting to database: %v", err)
 	}
 
-	_, err = DB.Exec(expenseSplitsTable)
+	duplicates, err := UpgradeLegacySchema(conn)
 	if err != nil {
-		log.Fatalf("Error creating expense_splits table: %v", err)
+		log.Fatalf("Error upgrading legacy database: %v", err)
+	}
+	for _, d := range duplicates {
+		log.Printf("WARNING: %s; unlink all but one of them in the admin page", d)
 	}
 
-	// Apply migrations
-	DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_expense_splits_tx_user ON expense_splits(actual_transaction_id, user_id)")
-	DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_payee ON users(actual_payee_id) WHERE actual_payee_id != ''")
-	DB.Exec("ALTER TABLE expense_splits ADD COLUMN auto_created INTEGER NOT NULL DEFAULT 0")
-	DB.Exec("ALTER TABLE expense_splits ADD COLUMN expense_date TEXT NOT NULL DEFAULT ''")
-	DB.Exec("ALTER TABLE expense_splits ADD COLUMN expense_note TEXT NOT NULL DEFAULT ''")
+	applied, err := MigrateUp(conn, 0)
+	if err != nil {
+		log.Fatalf("Error migrating database: %v", err)
+	}
+	if applied > 0 {
+		log.Printf("Applied %d database migration(s)", applied)
+	}
 
-	fmt.Println("Database initialized successfully.")
+	fmt.Printf("Database initialized successfully (%s).\n", dialectOf(conn))
+	return NewStore(conn)
 }
 
 // User represents a user in the system
@@ -78,6 +69,11 @@ type User struct {
 	OIDCSub       string `json:"oidc_sub"`
 	AidClass      string `json:"aid_class"`
 	ActualPayeeID string `json:"actual_payee_id"`
+	Active        bool   `json:"active"`
+	ArchivedAt    string `json:"archived_at"` // when the user was deactivated, "" while active
+	// PayeeIDs lists every payee the user pays from, primary first.
+	PayeeIDs []string `json:"payee_ids"`
+	Profile
 }
 
 // ExpenseSplit represents how an Actual Budget transaction is split
@@ -89,130 +85,17 @@ type ExpenseSplit struct {
 	AutoCreated         bool   `json:"auto_created"`
 	ExpenseDate         string `json:"expense_date"`
 	ExpenseNote         string `json:"expense_note"`
+	Archived            bool   `json:"archived"`
 }
 
-// --- User Queries ---
-
-func CreateUser(name, oidcSub, aidClass, actualPayeeID string) error {
-	_, err := DB.Exec(`
-		INSERT INTO users (name, oidc_sub, aid_class, actual_payee_id) 
-		VALUES (?, ?, ?, ?)
-	`, name, oidcSub, aidClass, actualPayeeID)
-	return err
-}
-
-func UpdateUser(id int, name, oidcSub, aidClass, actualPayeeID string) error {
-	_, err := DB.Exec(`
-		UPDATE users 
-		SET name = ?, oidc_sub = ?, aid_class = ?, actual_payee_id = ?
-		WHERE id = ?
-	`, name, oidcSub, aidClass, actualPayeeID, id)
-	return err
-}
-
-func GetUserBySub(sub string) (*User, error) {
-	row := DB.QueryRow("SELECT id, name, oidc_sub, aid_class, actual_payee_id FROM users WHERE oidc_sub = ?", sub)
-	var u User
-	err := row.Scan(&u.ID, &u.Name, &u.OIDCSub, &u.AidClass, &u.ActualPayeeID)
-	if err != nil {
-		return nil, err
-	}
-	return &u, nil
-}
-
-func GetUserByID(id int) (*User, error) {
-	row := DB.QueryRow("SELECT id, name, oidc_sub, aid_class, actual_payee_id FROM users WHERE id = ?", id)
-	var u User
-	err := row.Scan(&u.ID, &u.Name, &u.OIDCSub, &u.AidClass, &u.ActualPayeeID)
-	if err != nil {
-		return nil, err
-	}
-	return &u, nil
-}
-
-func GetAllUsers() ([]User, error) {
-	rows, err := DB.Query("SELECT id, name, oidc_sub, aid_class, actual_payee_id FROM users")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var users []User
-	for rows.Next() {
-		var u User
-		if err := rows.Scan(&u.ID, &u.Name, &u.OIDCSub, &u.AidClass, &u.ActualPayeeID); err != nil {
-			return nil, err
-		}
-		users = append(users, u)
-	}
-	return users, nil
-}
-
-// --- Split Queries ---
-
-func SetAutoSplit(txID string, userID int, amount int, date string, note string) error {
-	_, err := DB.Exec(`
-		INSERT INTO expense_splits (actual_transaction_id, user_id, amount_owed, auto_created, expense_date, expense_note) 
-		VALUES (?, ?, ?, 1, ?, ?)
-		ON CONFLICT(actual_transaction_id, user_id) DO UPDATE SET 
-			amount_owed=excluded.amount_owed, auto_created=excluded.auto_created,
-			expense_date=excluded.expense_date, expense_note=excluded.expense_note
-	`, txID, userID, amount, date, note)
-	return err
-}
-
-func ClearSplitsForTx(txID string) error {
-	_, err := DB.Exec("DELETE FROM expense_splits WHERE actual_transaction_id = ?", txID)
-	return err
-}
-
-func SetSplit(txID string, userID int, amount int, date string, note string) error {
-	_, err := DB.Exec(`
-		INSERT INTO expense_splits (actual_transaction_id, user_id, amount_owed, auto_created, expense_date, expense_note) 
-		VALUES (?, ?, ?, 0, ?, ?)
-		ON CONFLICT(actual_transaction_id, user_id) DO UPDATE SET 
-			amount_owed=excluded.amount_owed, auto_created=0,
-			expense_date=excluded.expense_date, expense_note=excluded.expense_note
-	`, txID, userID, amount, date, note)
-	return err
-}
-
-func GetAllSplits() ([]ExpenseSplit, error) {
-	rows, err := DB.Query("SELECT id, actual_transaction_id, user_id, amount_owed, auto_created, expense_date, expense_note FROM expense_splits")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var splits []ExpenseSplit
-	for rows.Next() {
-		var s ExpenseSplit
-		var autoCreated int
-		if err := rows.Scan(&s.ID, &s.ActualTransactionID, &s.UserID, &s.AmountOwed, &autoCreated, &s.ExpenseDate, &s.ExpenseNote); err != nil {
-			return nil, err
-		}
-		s.AutoCreated = autoCreated == 1
-		splits = append(splits, s)
-	}
-	return splits, nil
-}
-
-func GetSplitsForUser(userID int) ([]ExpenseSplit, error) {
-	rows, err := DB.Query("SELECT id, actual_transaction_id, user_id, amount_owed, auto_created, expense_date, expense_note FROM expense_splits WHERE user_id = ?", userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var splits []ExpenseSplit
-	for rows.Next() {
-		var s ExpenseSplit
-		var autoCreated int
-		if err := rows.Scan(&s.ID, &s.ActualTransactionID, &s.UserID, &s.AmountOwed, &autoCreated, &s.ExpenseDate, &s.ExpenseNote); err != nil {
-			return nil, err
-		}
-		s.AutoCreated = autoCreated == 1
-		splits = append(splits, s)
-	}
-	return splits, nil
+// Writeback records a transaction this app created or edited in Actual, so
+// repeated write-backs update it instead of posting duplicates.
+type Writeback struct {
+	ID                  int    `json:"id"`
+	Kind                string `json:"kind"` // "receivable" or "note"
+	Ref                 string `json:"ref"`  // user ID for receivables, transaction ID for notes
+	ActualTransactionID string `json:"actual_transaction_id"`
+	Amount              int    `json:"amount"`
+	Notes               string `json:"notes"`
+	UpdatedAt           string `json:"updated_at"`
 }
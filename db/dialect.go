@@ -0,0 +1,63 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// dialect is the SQL flavour of a connection. Queries are written once with
+// SQLite's "?" placeholders and rebound for Postgres.
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+func dialectOf(conn *sql.DB) dialect {
+	if _, ok := conn.Driver().(*pq.Driver); ok {
+		return dialectPostgres
+	}
+	return dialectSQLite
+}
+
+// String names the dialect; it is also the migrations subdirectory.
+func (d dialect) String() string {
+	if d == dialectPostgres {
+		return "postgres"
+	}
+	return "sqlite"
+}
+
+// rebind rewrites "?" placeholders to Postgres' "$1", "$2", ... Queries never
+// contain a literal "?", so no quoting rules are needed.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// TimeLayout is how timestamps are stored, in UTC.
+const TimeLayout = "2006-01-02 15:04:05"
+
+// timestamp formats t the way SQLite's datetime('now') does, so TEXT
+// timestamps sort and display the same on both backends.
+func timestamp(t time.Time) string {
+	return t.UTC().Format(TimeLayout)
+}
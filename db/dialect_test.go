@@ -0,0 +1,41 @@
+package db
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		d     dialect
+		query string
+		want  string
+	}{
+		{dialectSQLite, "SELECT * FROM users WHERE id = ? AND name = ?", "SELECT * FROM users WHERE id = ? AND name = ?"},
+		{dialectPostgres, "SELECT * FROM users WHERE id = ? AND name = ?", "SELECT * FROM users WHERE id = $1 AND name = $2"},
+		{dialectPostgres, "INSERT INTO t (a, b, c) VALUES (?, ?, ?)", "INSERT INTO t (a, b, c) VALUES ($1, $2, $3)"},
+		{dialectPostgres, "SELECT COUNT(*) FROM users", "SELECT COUNT(*) FROM users"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.rebind(tt.query); got != tt.want {
+			t.Errorf("%s rebind(%q) = %q, want %q", tt.d, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestMigrationsMatchAcrossDialects(t *testing.T) {
+	sqlite, err := loadMigrations(dialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postgres, err := loadMigrations(dialectPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("sqlite has %d migrations, postgres %d", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		s, p := sqlite[i], postgres[i]
+		if s.Version != p.Version || s.Name != p.Name || (s.Down == "") != (p.Down == "") {
+			t.Errorf("migration %d differs: sqlite %04d_%s, postgres %04d_%s", i, s.Version, s.Name, p.Version, p.Name)
+		}
+	}
+}
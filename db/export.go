@@ -0,0 +1,636 @@
+package db
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Archive is the portable form of the users and splits tables. Splits refer
+// to users by the IDs in the archive, which are remapped on import.
+type Archive struct {
+	SchemaVersion int            `json:"schema_version"`
+	ExportedAt    string         `json:"exported_at"`
+	Users         []User         `json:"users"`
+	Splits        []ExpenseSplit `json:"expense_splits"`
+}
+
+// Files inside an export zip. The JSON file carries everything; the CSV files
+// hold the same rows for editing in a spreadsheet and take precedence on
+// import when present.
+const (
+	archiveJSON      = "who-owes-me.json"
+	archiveUsersCSV  = "users.csv"
+	archiveSplitsCSV = "expense_splits.csv"
+)
+
+var (
+	userCSVHeader  = []string{"id", "name", "oidc_sub", "aid_class", "actual_payee_id", "active", "archived_at", "payee_ids", "email", "phone", "venmo", "paypal"}
+	splitCSVHeader = []string{"id", "actual_transaction_id", "user_id", "amount_owed", "auto_created", "expense_date", "expense_note", "archived"}
+)
+
+// Conflict modes for Import, used when an imported user or split matches an
+// existing row.
+const (
+	ImportSkip   = "skip"   // keep the existing row
+	ImportUpdate = "update" // overwrite the existing row with the imported one
+)
+
+type ImportOptions struct {
+	OnConflict string // ImportSkip or ImportUpdate
+	DryRun     bool   // report what would change, then roll back
+}
+
+// ImportSummary counts what an import changed (or, on a dry run, would have
+// changed). Notes explain each conflict and how it was resolved.
+type ImportSummary struct {
+	DryRun        bool
+	UsersCreated  int
+	UsersUpdated  int
+	UsersSkipped  int
+	SplitsCreated int
+	SplitsUpdated int
+	SplitsSkipped int
+	Notes         []string
+}
+
+func (s ImportSummary) String() string {
+	return fmt.Sprintf("Users: %d created, %d updated, %d unchanged; splits: %d created, %d updated, %d unchanged",
+		s.UsersCreated, s.UsersUpdated, s.UsersSkipped, s.SplitsCreated, s.SplitsUpdated, s.SplitsSkipped)
+}
+
+// Export reads every user and split, archived splits included.
+func (s *SQLStore) Export(ctx context.Context) (*Archive, error) {
+	version, err := SchemaVersion(s.db)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archive{SchemaVersion: version, ExportedAt: time.Now().UTC().Format(time.RFC3339)}
+
+	if a.Users, err = s.GetAllUsers(ctx); err != nil {
+		return nil, err
+	}
+
+	splitRows, err := s.query(ctx, "SELECT "+splitColumns+" FROM expense_splits ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	if a.Splits, err = scanSplits(splitRows); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// WriteArchive writes a as a zip holding the JSON file and one CSV per table.
+func WriteArchive(w io.Writer, a *Archive) error {
+	zw := zip.NewWriter(w)
+
+	f, err := zw.Create(archiveJSON)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(a); err != nil {
+		return err
+	}
+
+	users := [][]string{userCSVHeader}
+	for _, u := range a.Users {
+		users = append(users, []string{strconv.Itoa(u.ID), u.Name, u.OIDCSub, u.AidClass, u.ActualPayeeID, strconv.FormatBool(u.Active), u.ArchivedAt, strings.Join(u.PayeeIDs, ";"),
+			u.Email, u.Phone, u.Venmo, u.PayPal})
+	}
+	if err := writeCSV(zw, archiveUsersCSV, users); err != nil {
+		return err
+	}
+
+	splits := [][]string{splitCSVHeader}
+	for _, s := range a.Splits {
+		splits = append(splits, []string{
+			strconv.Itoa(s.ID), s.ActualTransactionID, strconv.Itoa(s.UserID), strconv.Itoa(s.AmountOwed),
+			strconv.FormatBool(s.AutoCreated), s.ExpenseDate, s.ExpenseNote, strconv.FormatBool(s.Archived),
+		})
+	}
+	if err := writeCSV(zw, archiveSplitsCSV, splits); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeCSV(zw *zip.Writer, name string, records [][]string) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	cw.WriteAll(records)
+	return cw.Error()
+}
+
+// ReadArchive parses an export zip, or a bare JSON export. In a zip the CSV
+// files, when present, replace the rows from the JSON file, so edits made in
+// a spreadsheet are what gets imported.
+func ReadArchive(data []byte) (*Archive, error) {
+	if !bytes.HasPrefix(data, []byte("PK")) {
+		var a Archive
+		if err := json.Unmarshal(data, &a); err != nil {
+			return nil, fmt.Errorf("not an export zip or JSON file: %w", err)
+		}
+		return &a, nil
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	jf, ok := files[archiveJSON]
+	if !ok {
+		return nil, fmt.Errorf("archive has no %s", archiveJSON)
+	}
+	var a Archive
+	if err := readZipFile(jf, func(r io.Reader) error { return json.NewDecoder(r).Decode(&a) }); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", archiveJSON, err)
+	}
+
+	if f, ok := files[archiveUsersCSV]; ok {
+		if err := readZipFile(f, func(r io.Reader) (err error) { a.Users, err = parseUsersCSV(r); return }); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", archiveUsersCSV, err)
+		}
+	}
+	if f, ok := files[archiveSplitsCSV]; ok {
+		if err := readZipFile(f, func(r io.Reader) (err error) { a.Splits, err = parseSplitsCSV(r); return }); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", archiveSplitsCSV, err)
+		}
+	}
+	return &a, nil
+}
+
+func readZipFile(f *zip.File, read func(io.Reader) error) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return read(rc)
+}
+
+// readCSV returns the rows after the header, keyed by column name. Columns
+// may come in any order, but every one in required must be present.
+func readCSV(r io.Reader, required []string) ([]map[string]string, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	index := map[string]int{}
+	for i, col := range records[0] {
+		index[strings.TrimSpace(col)] = i
+	}
+	for _, col := range required {
+		if _, ok := index[col]; !ok {
+			return nil, fmt.Errorf("missing column %q", col)
+		}
+	}
+
+	var rows []map[string]string
+	for _, rec := range records[1:] {
+		row := map[string]string{}
+		for col, i := range index {
+			if i < len(rec) {
+				row[col] = strings.TrimSpace(rec[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseUsersCSV(r io.Reader) ([]User, error) {
+	// Exports from before user deactivation have no active or archived_at
+	// column, and their users are all active. Older exports have no
+	// payee_ids column either; it lists every payee, ";"-separated. The
+	// profile columns are optional too.
+	rows, err := readCSV(r, userCSVHeader[:5])
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	emails := map[string]int{} // lowercased email to line
+	for i, row := range rows {
+		id, err := strconv.Atoi(row["id"])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid id %q", i+2, row["id"])
+		}
+		if email := strings.ToLower(strings.TrimSpace(row["email"])); email != "" {
+			if line, ok := emails[email]; ok {
+				return nil, fmt.Errorf("line %d: email %q is also on line %d", i+2, row["email"], line)
+			}
+			emails[email] = i + 2
+		}
+		active := true
+		if row["active"] != "" {
+			if active, err = strconv.ParseBool(row["active"]); err != nil {
+				return nil, fmt.Errorf("line %d: invalid active %q", i+2, row["active"])
+			}
+		}
+		users = append(users, User{
+			ID: id, Name: row["name"], OIDCSub: row["oidc_sub"], AidClass: row["aid_class"], ActualPayeeID: row["actual_payee_id"],
+			Active: active, ArchivedAt: row["archived_at"], PayeeIDs: splitList(row["payee_ids"]),
+			Profile: Profile{Email: row["email"], Phone: row["phone"], Venmo: row["venmo"], PayPal: row["paypal"]},
+		})
+	}
+	return users, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseSplitsCSV(r io.Reader) ([]ExpenseSplit, error) {
+	rows, err := readCSV(r, splitCSVHeader)
+	if err != nil {
+		return nil, err
+	}
+	var splits []ExpenseSplit
+	for i, row := range rows {
+		var s ExpenseSplit
+		var errs []error
+		parseInt := func(col string, dst *int) {
+			n, err := strconv.Atoi(row[col])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s %q", col, row[col]))
+			}
+			*dst = n
+		}
+		parseBool := func(col string, dst *bool) {
+			b, err := strconv.ParseBool(row[col])
+			if err != nil && row[col] != "" {
+				errs = append(errs, fmt.Errorf("invalid %s %q", col, row[col]))
+			}
+			*dst = b
+		}
+		parseInt("id", &s.ID)
+		parseInt("user_id", &s.UserID)
+		parseInt("amount_owed", &s.AmountOwed)
+		parseBool("auto_created", &s.AutoCreated)
+		parseBool("archived", &s.Archived)
+		if err := errors.Join(errs...); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+2, err)
+		}
+		s.ActualTransactionID = row["actual_transaction_id"]
+		s.ExpenseDate = row["expense_date"]
+		s.ExpenseNote = row["expense_note"]
+		splits = append(splits, s)
+	}
+	return splits, nil
+}
+
+// userStatusVersion is the schema version that added users.active.
+const userStatusVersion = 2
+
+// upgrade fills in fields that archives from older schema versions lack.
+func (a *Archive) upgrade() {
+	if a.SchemaVersion < userStatusVersion {
+		for i := range a.Users {
+			a.Users[i].Active = true
+		}
+	}
+}
+
+// validate rejects archives this build cannot import and rows that could not
+// be inserted.
+func (a *Archive) validate() error {
+	if latest := latestSchemaVersion(dialectSQLite); a.SchemaVersion > latest {
+		return fmt.Errorf("archive is at schema version %d, newer than this build's %d", a.SchemaVersion, latest)
+	}
+
+	ids := map[int]bool{}
+	subs := map[string]bool{}
+	payees := map[string]bool{}
+	var errs []error
+	for _, u := range a.Users {
+		switch {
+		case u.Name == "" || u.OIDCSub == "":
+			errs = append(errs, fmt.Errorf("user %d needs a name and a login", u.ID))
+		case ids[u.ID]:
+			errs = append(errs, fmt.Errorf("user id %d appears twice", u.ID))
+		case subs[u.OIDCSub]:
+			errs = append(errs, fmt.Errorf("login %q appears twice", u.OIDCSub))
+		}
+		if _, err := NormalizeProfile(u.Profile); err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %w", u.ID, err))
+		}
+		for _, payeeID := range archivePayeeIDs(u) {
+			if payees[payeeID] {
+				errs = append(errs, fmt.Errorf("payee %s is linked to more than one user", payeeID))
+			}
+			payees[payeeID] = true
+		}
+		ids[u.ID] = true
+		subs[u.OIDCSub] = true
+	}
+
+	type splitKey struct {
+		tx   string
+		user int
+	}
+	seen := map[splitKey]bool{}
+	for _, s := range a.Splits {
+		key := splitKey{s.ActualTransactionID, s.UserID}
+		switch {
+		case s.ActualTransactionID == "":
+			errs = append(errs, fmt.Errorf("split %d has no transaction", s.ID))
+		case !ids[s.UserID]:
+			errs = append(errs, fmt.Errorf("split %d refers to user %d, which is not in the archive", s.ID, s.UserID))
+		case seen[key]:
+			errs = append(errs, fmt.Errorf("transaction %s is split to user %d twice", s.ActualTransactionID, s.UserID))
+		}
+		seen[key] = true
+	}
+	return errors.Join(errs...)
+}
+
+// Import merges an archive into the database in one transaction. Users are
+// matched to existing ones by login first and then by linked payee; splits by
+// transaction and user. Matches are conflicts, resolved by opts.OnConflict.
+// Any error rolls the whole import back, and so does a dry run.
+func (s *SQLStore) Import(ctx context.Context, a *Archive, opts ImportOptions) (*ImportSummary, error) {
+	if opts.OnConflict != ImportSkip && opts.OnConflict != ImportUpdate {
+		return nil, fmt.Errorf("unknown conflict mode %q; use %s or %s", opts.OnConflict, ImportSkip, ImportUpdate)
+	}
+	a.upgrade()
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	im := &importer{ctx: ctx, tx: tx, s: s, d: s.dialect, update: opts.OnConflict == ImportUpdate}
+	summary := &ImportSummary{DryRun: opts.DryRun}
+	userIDs := map[int]int{} // archive user ID -> database user ID
+	for _, u := range a.Users {
+		id, err := im.importUser(u, summary)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", u.OIDCSub, err)
+		}
+		userIDs[u.ID] = id
+	}
+	for _, sp := range a.Splits {
+		sp.UserID = userIDs[sp.UserID]
+		if err := im.importSplit(sp, summary); err != nil {
+			return nil, fmt.Errorf("split of %s: %w", sp.ActualTransactionID, err)
+		}
+	}
+
+	if opts.DryRun {
+		return summary, nil
+	}
+	return summary, tx.Commit()
+}
+
+type importer struct {
+	ctx    context.Context
+	tx     *sql.Tx
+	s      *SQLStore
+	d      dialect
+	update bool
+}
+
+func (im *importer) exec(query string, args ...any) error {
+	_, err := im.tx.ExecContext(im.ctx, im.d.rebind(query), args...)
+	return err
+}
+
+func (im *importer) findUser(column string, value any) (*User, error) {
+	u, err := scanUser(im.tx.QueryRowContext(im.ctx, im.d.rebind("SELECT "+userColumns+" FROM users WHERE "+column+" = ?"), value))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return u, err
+}
+
+// payeeOwner returns the user who has payeeID, or nil.
+func (im *importer) payeeOwner(payeeID string) (*User, error) {
+	var userID int
+	err := im.tx.QueryRowContext(im.ctx, im.d.rebind("SELECT user_id FROM user_payees WHERE actual_payee_id = ?"), payeeID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return im.findUser("id", userID)
+}
+
+// payeeIDs lists a user's payees in the same order loadPayeeIDs does.
+func (im *importer) payeeIDs(u *User) ([]string, error) {
+	rows, err := im.tx.QueryContext(im.ctx, im.d.rebind("SELECT actual_payee_id FROM user_payees WHERE user_id = ? ORDER BY id"), u.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	if u.ActualPayeeID != "" {
+		ids = append(ids, u.ActualPayeeID)
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		if id != u.ActualPayeeID {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// setPayees replaces a user's payees with payeeIDs.
+func (im *importer) setPayees(userID int, payeeIDs []string) error {
+	if err := im.exec("DELETE FROM user_payees WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, id := range payeeIDs {
+		if err := im.s.addUserPayee(im.ctx, im.tx, userID, id); err != nil {
+			return fmt.Errorf("payee %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// archivePayeeIDs returns every payee of an archived user, primary first.
+// Archives from before multiple payees only have the primary one.
+func archivePayeeIDs(u User) []string {
+	ids := []string{}
+	if u.ActualPayeeID != "" {
+		ids = append(ids, u.ActualPayeeID)
+	}
+	for _, id := range u.PayeeIDs {
+		if id != "" && id != u.ActualPayeeID {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (im *importer) importUser(u User, summary *ImportSummary) (int, error) {
+	if u.AidClass == "" {
+		u.AidClass = "regular"
+	}
+	u.PayeeIDs = archivePayeeIDs(u)
+	profile, err := NormalizeProfile(u.Profile)
+	if err != nil {
+		return 0, err
+	}
+	u.Profile = profile
+
+	existing, err := im.findUser("oidc_sub", u.OIDCSub)
+	if err != nil {
+		return 0, err
+	}
+	for _, payeeID := range u.PayeeIDs {
+		if existing != nil {
+			break
+		}
+		if existing, err = im.payeeOwner(payeeID); err != nil {
+			return 0, err
+		}
+	}
+
+	if existing == nil {
+		var id int
+		err := im.tx.QueryRowContext(im.ctx, im.d.rebind(`
+			INSERT INTO users (name, oidc_sub, aid_class, actual_payee_id, active, archived_at, email, phone, venmo, paypal)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id
+		`), u.Name, u.OIDCSub, u.AidClass, u.ActualPayeeID, boolInt(u.Active), u.ArchivedAt,
+			u.Email, u.Phone, u.Venmo, u.PayPal).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		if err := im.setPayees(id, u.PayeeIDs); err != nil {
+			return 0, err
+		}
+		summary.UsersCreated++
+		return id, nil
+	}
+
+	if existing.PayeeIDs, err = im.payeeIDs(existing); err != nil {
+		return 0, err
+	}
+	u.ID = existing.ID
+	switch {
+	case sameUser(*existing, u):
+		summary.UsersSkipped++
+	case !im.update:
+		summary.UsersSkipped++
+		summary.Notes = append(summary.Notes, fmt.Sprintf("kept existing user %s (%s) for imported %s (%s)", existing.Name, existing.OIDCSub, u.Name, u.OIDCSub))
+	default:
+		for _, payeeID := range u.PayeeIDs {
+			other, err := im.payeeOwner(payeeID)
+			if err != nil {
+				return 0, err
+			}
+			if other != nil && other.ID != existing.ID {
+				return 0, fmt.Errorf("payee %s is already linked to %s", payeeID, other.Name)
+			}
+		}
+		if u.OIDCSub != existing.OIDCSub {
+			if other, err := im.findUser("oidc_sub", u.OIDCSub); err != nil {
+				return 0, err
+			} else if other != nil {
+				return 0, fmt.Errorf("login is already used by %s", other.Name)
+			}
+		}
+		err := im.exec("UPDATE users SET name = ?, oidc_sub = ?, aid_class = ?, actual_payee_id = ?, active = ?, archived_at = ?, email = ?, phone = ?, venmo = ?, paypal = ? WHERE id = ?",
+			u.Name, u.OIDCSub, u.AidClass, u.ActualPayeeID, boolInt(u.Active), u.ArchivedAt,
+			u.Email, u.Phone, u.Venmo, u.PayPal, existing.ID)
+		if err != nil {
+			return 0, err
+		}
+		if err := im.setPayees(existing.ID, u.PayeeIDs); err != nil {
+			return 0, err
+		}
+		summary.UsersUpdated++
+		summary.Notes = append(summary.Notes, fmt.Sprintf("updated user %s (%s) from imported %s (%s)", existing.Name, existing.OIDCSub, u.Name, u.OIDCSub))
+	}
+	return existing.ID, nil
+}
+
+func sameUser(a, b User) bool {
+	return a.ID == b.ID && a.Name == b.Name && a.OIDCSub == b.OIDCSub && a.AidClass == b.AidClass &&
+		a.ActualPayeeID == b.ActualPayeeID && a.Active == b.Active && a.ArchivedAt == b.ArchivedAt &&
+		slices.Equal(a.PayeeIDs, b.PayeeIDs) && a.Profile == b.Profile
+}
+
+func (im *importer) importSplit(sp ExpenseSplit, summary *ImportSummary) error {
+	var existing ExpenseSplit
+	var autoCreated, archived int
+	err := im.tx.QueryRowContext(im.ctx, im.d.rebind("SELECT "+splitColumns+" FROM expense_splits WHERE actual_transaction_id = ? AND user_id = ?"),
+		sp.ActualTransactionID, sp.UserID).
+		Scan(&existing.ID, &existing.ActualTransactionID, &existing.UserID, &existing.AmountOwed, &autoCreated, &existing.ExpenseDate, &existing.ExpenseNote, &archived)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if err == sql.ErrNoRows {
+		if err := im.exec(`
+			INSERT INTO expense_splits (actual_transaction_id, user_id, amount_owed, auto_created, expense_date, expense_note, archived)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, sp.ActualTransactionID, sp.UserID, sp.AmountOwed, boolInt(sp.AutoCreated), sp.ExpenseDate, sp.ExpenseNote, boolInt(sp.Archived)); err != nil {
+			return err
+		}
+		summary.SplitsCreated++
+		return nil
+	}
+
+	existing.AutoCreated = autoCreated == 1
+	existing.Archived = archived == 1
+	sp.ID = existing.ID
+	if sp == existing || !im.update {
+		summary.SplitsSkipped++
+		return nil
+	}
+	if err := im.exec(`
+		UPDATE expense_splits SET amount_owed = ?, auto_created = ?, expense_date = ?, expense_note = ?, archived = ?
+		WHERE id = ?
+	`, sp.AmountOwed, boolInt(sp.AutoCreated), sp.ExpenseDate, sp.ExpenseNote, boolInt(sp.Archived), existing.ID); err != nil {
+		return err
+	}
+	summary.SplitsUpdated++
+	return nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,207 @@
+package db
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// exportTestArchive exports a store with two users and three splits, one of
+// them archived, and returns the zip bytes.
+func exportTestArchive(t *testing.T) []byte {
+	t.Helper()
+	ctx := context.Background()
+	src := newTestStore(t)
+	alice := createTestUser(t, src, "Alice", "alice", "p1")
+	bob := createTestUser(t, src, "Bob", "bob", "")
+	src.UpdateUserProfile(ctx, bob.ID, Profile{Email: "bob@example.com", Venmo: "bob-v"})
+	src.SetSplit(ctx, "t1", alice.ID, -500, "2026-01-01", "dues, spring")
+	src.SetSplit(ctx, "t1", bob.ID, -500, "2026-01-01", "dues, spring")
+	src.SetAutoSplit(ctx, "t2", alice.ID, 1000, "2026-01-05", "payment")
+	src.ArchiveSplitsForTx(ctx, "t2")
+
+	a, err := src.Export(ctx)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteArchive(&buf, a); err != nil {
+		t.Fatalf("WriteArchive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	a, err := ReadArchive(exportTestArchive(t))
+	if err != nil {
+		t.Fatalf("ReadArchive: %v", err)
+	}
+	if a.SchemaVersion == 0 || len(a.Users) != 2 || len(a.Splits) != 3 {
+		t.Fatalf("archive = version %d, %d users, %d splits", a.SchemaVersion, len(a.Users), len(a.Splits))
+	}
+
+	dst := newTestStore(t)
+	// Occupy the first ID so imported users must be remapped.
+	createTestUser(t, dst, "Carol", "carol", "p3")
+
+	summary, err := dst.Import(ctx, a, ImportOptions{OnConflict: ImportSkip})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if summary.UsersCreated != 2 || summary.SplitsCreated != 3 {
+		t.Errorf("summary = %s", summary)
+	}
+
+	bob, err := dst.GetUserBySub(ctx, "bob")
+	if err != nil {
+		t.Fatalf("GetUserBySub(bob): %v", err)
+	}
+	if want := (Profile{Email: "bob@example.com", Venmo: "bob-v"}); bob.Profile != want {
+		t.Errorf("bob's profile = %+v, want %+v", bob.Profile, want)
+	}
+	splits, _ := dst.GetSplitsForUser(ctx, bob.ID)
+	if len(splits) != 1 || splits[0].AmountOwed != -500 || splits[0].ExpenseNote != "dues, spring" {
+		t.Errorf("bob's splits = %+v", splits)
+	}
+	if active, _ := dst.GetSplitsForTx(ctx, "t2"); len(active) != 0 {
+		t.Errorf("archived split came back active: %+v", active)
+	}
+
+	// Importing the same archive again changes nothing.
+	summary, err = dst.Import(ctx, a, ImportOptions{OnConflict: ImportUpdate})
+	if err != nil {
+		t.Fatalf("second Import: %v", err)
+	}
+	if summary.UsersCreated+summary.UsersUpdated+summary.SplitsCreated+summary.SplitsUpdated != 0 {
+		t.Errorf("re-import summary = %s, want no changes", summary)
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	ctx := context.Background()
+	a := &Archive{
+		Users: []User{
+			{ID: 10, Name: "Alice Smith", OIDCSub: "asmith", AidClass: "needs_help", ActualPayeeID: "p1"},
+		},
+		Splits: []ExpenseSplit{
+			{ID: 1, ActualTransactionID: "t1", UserID: 10, AmountOwed: -900},
+		},
+	}
+
+	for _, tt := range []struct {
+		mode       string
+		wantName   string
+		wantAmount int
+	}{
+		{ImportSkip, "Alice", -500},
+		{ImportUpdate, "Alice Smith", -900},
+	} {
+		t.Run(tt.mode, func(t *testing.T) {
+			store := newTestStore(t)
+			alice := createTestUser(t, store, "Alice", "alice", "p1")
+			store.SetSplit(ctx, "t1", alice.ID, -500, "", "")
+
+			summary, err := store.Import(ctx, a, ImportOptions{OnConflict: tt.mode})
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			if summary.UsersCreated != 0 || len(summary.Notes) != 1 {
+				t.Errorf("summary = %s, notes %v; want the payee match reported", summary, summary.Notes)
+			}
+
+			got, _ := store.GetUserByID(ctx, alice.ID)
+			if got.Name != tt.wantName {
+				t.Errorf("user name = %q, want %q", got.Name, tt.wantName)
+			}
+			splits, _ := store.GetSplitsForTx(ctx, "t1")
+			if len(splits) != 1 || splits[0].AmountOwed != tt.wantAmount {
+				t.Errorf("splits = %+v, want one of %d", splits, tt.wantAmount)
+			}
+		})
+	}
+}
+
+func TestImportDryRunAndErrorsSaveNothing(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	createTestUser(t, store, "Carol", "carol", "p3")
+
+	a := &Archive{Users: []User{{ID: 1, Name: "Dave", OIDCSub: "dave"}}}
+	summary, err := store.Import(ctx, a, ImportOptions{OnConflict: ImportSkip, DryRun: true})
+	if err != nil || summary.UsersCreated != 1 {
+		t.Fatalf("dry run = %v, %v", summary, err)
+	}
+	if _, err := store.GetUserBySub(ctx, "dave"); err == nil {
+		t.Error("dry run saved a user")
+	}
+
+	// Dave is valid, but taking Carol's payee on update fails the import.
+	bad := &Archive{Users: []User{
+		{ID: 1, Name: "Dave", OIDCSub: "dave"},
+		{ID: 2, Name: "Eve", OIDCSub: "eve", ActualPayeeID: "p9"},
+	}}
+	createTestUser(t, store, "Eve", "eve", "p4")
+	createTestUser(t, store, "Frank", "frank", "p9")
+	if _, err := store.Import(ctx, bad, ImportOptions{OnConflict: ImportUpdate}); err == nil {
+		t.Fatal("Import moving a linked payee succeeded")
+	}
+	if _, err := store.GetUserBySub(ctx, "dave"); err == nil {
+		t.Error("failed import left a user behind")
+	}
+
+	for name, a := range map[string]*Archive{
+		"newer schema":   {SchemaVersion: 9999},
+		"duplicate sub":  {Users: []User{{ID: 1, Name: "A", OIDCSub: "x"}, {ID: 2, Name: "B", OIDCSub: "x"}}},
+		"unknown user":   {Splits: []ExpenseSplit{{ID: 1, ActualTransactionID: "t1", UserID: 5}}},
+		"missing fields": {Users: []User{{ID: 1, Name: "A"}}},
+	} {
+		if _, err := store.Import(ctx, a, ImportOptions{OnConflict: ImportSkip}); err == nil {
+			t.Errorf("Import(%s) succeeded", name)
+		}
+	}
+	if _, err := store.Import(ctx, &Archive{}, ImportOptions{OnConflict: "merge"}); err == nil {
+		t.Error("Import with an unknown conflict mode succeeded")
+	}
+}
+
+func TestReadArchivePrefersCSV(t *testing.T) {
+	data := exportTestArchive(t)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite the zip with an edited users.csv, as a spreadsheet would.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		body, _ := io.ReadAll(rc)
+		rc.Close()
+		if f.Name == archiveUsersCSV {
+			body = []byte(strings.Replace(string(body), "Bob", "Robert", 1))
+		}
+		w, _ := zw.Create(f.Name)
+		w.Write(body)
+	}
+	zw.Close()
+
+	a, err := ReadArchive(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ReadArchive: %v", err)
+	}
+	if a.Users[1].Name != "Robert" {
+		t.Errorf("users = %+v, want the CSV edit", a.Users)
+	}
+	if len(a.Splits) != 3 || a.Splits[0].ExpenseNote != "dues, spring" || !a.Splits[2].Archived {
+		t.Errorf("splits = %+v", a.Splits)
+	}
+
+	if _, err := ReadArchive([]byte("id,name\n1,x\n")); err == nil {
+		t.Error("ReadArchive accepted a bare CSV file")
+	}
+}
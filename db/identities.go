@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Login is who the identity provider says signed in.
+type Login struct {
+	Issuer        string
+	Subject       string // the stable sub claim
+	Username      string // preferred_username, which can be renamed
+	Email         string
+	EmailVerified bool
+}
+
+// Identity links an OIDC issuer and subject to a user.
+type Identity struct {
+	ID          int    `json:"id"`
+	UserID      int    `json:"user_id"`
+	Issuer      string `json:"issuer"`
+	Subject     string `json:"subject"`
+	Email       string `json:"email"` // last verified email, or ""
+	CreatedAt   string `json:"created_at"`
+	LastLoginAt string `json:"last_login_at"`
+}
+
+// MatchLogin finds the user a login belongs to. A linked identity wins;
+// otherwise a user is matched by users.oidc_sub (the username or subject,
+// as before identities existed) or, failing that, by a verified email that
+// another of exactly one user's logins was seen with, and the login is
+// linked to them. Profile emails are never used: players edit them and
+// nothing verifies them. The fallbacks
+// skip users already linked to another subject from the same issuer, so a
+// recycled username or address cannot take over their account. Returns
+// sql.ErrNoRows when nobody matches.
+func (s *SQLStore) MatchLogin(ctx context.Context, l Login) (*User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	email := ""
+	if l.EmailVerified {
+		email = strings.ToLower(strings.TrimSpace(l.Email))
+	}
+	now := timestamp(time.Now())
+
+	var userID int
+	err = tx.QueryRowContext(ctx, s.dialect.rebind("SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?"),
+		l.Issuer, l.Subject).Scan(&userID)
+	switch {
+	case err == nil:
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind("UPDATE user_identities SET email = ?, last_login_at = ? WHERE issuer = ? AND subject = ?"),
+			email, now, l.Issuer, l.Subject); err != nil {
+			return nil, err
+		}
+	case err == sql.ErrNoRows:
+		var how string
+		if userID, how, err = s.matchUnlinked(ctx, tx, l.Issuer, []string{l.Username, l.Subject}, email); err != nil {
+			return nil, err
+		}
+		if err := s.linkIdentity(ctx, tx, userID, l.Issuer, l.Subject, email); err != nil {
+			return nil, err
+		}
+		details := fmt.Sprintf("linked subject %s from %s to user #%d by %s", l.Subject, l.Issuer, userID, how)
+		if err := s.addAuditEntry(ctx, tx, l.Username, AuditLinkIdentity, details); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetUserByID(ctx, userID)
+}
+
+// GetUserByIdentity returns the user linked to an issuer and subject, or
+// sql.ErrNoRows.
+func (s *SQLStore) GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	var userID int
+	err := s.queryRow(ctx, "SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?", issuer, subject).Scan(&userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUserByID(ctx, userID)
+}
+
+func (s *SQLStore) linkIdentity(ctx context.Context, tx *sql.Tx, userID int, issuer, subject, email string) error {
+	now := timestamp(time.Now())
+	_, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO user_identities (user_id, issuer, subject, email, created_at, last_login_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`), userID, issuer, subject, email, now, now)
+	return err
+}
+
+// matchUnlinked finds a user with no identity from issuer by one of the
+// logins in users.oidc_sub, or else by the verified email of their other
+// identities. It reports which one matched.
+func (s *SQLStore) matchUnlinked(ctx context.Context, tx *sql.Tx, issuer string, logins []string, email string) (int, string, error) {
+	const unlinked = "NOT EXISTS (SELECT 1 FROM user_identities i WHERE i.user_id = users.id AND i.issuer = ?)"
+
+	for _, login := range logins {
+		if login == "" {
+			continue
+		}
+		var id int
+		err := tx.QueryRowContext(ctx, s.dialect.rebind("SELECT id FROM users WHERE oidc_sub = ? AND "+unlinked), login, issuer).Scan(&id)
+		if err != sql.ErrNoRows {
+			return id, "login " + login, err
+		}
+	}
+	if email == "" {
+		return 0, "", sql.ErrNoRows
+	}
+
+	rows, err := tx.QueryContext(ctx, s.dialect.rebind("SELECT DISTINCT user_id FROM user_identities WHERE email = ?"), email)
+	if err != nil {
+		return 0, "", err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, "", err
+	}
+	if len(ids) != 1 {
+		// Nobody, or a shared address that could be anyone's.
+		return 0, "", sql.ErrNoRows
+	}
+
+	var linked bool
+	if err := tx.QueryRowContext(ctx, s.dialect.rebind("SELECT EXISTS (SELECT 1 FROM user_identities WHERE user_id = ? AND issuer = ?)"),
+		ids[0], issuer).Scan(&linked); err != nil {
+		return 0, "", err
+	}
+	if linked {
+		return 0, "", sql.ErrNoRows
+	}
+	return ids[0], "email " + email, nil
+}
+
+// GetUserIdentities returns the identities linked to a user, oldest first.
+func (s *SQLStore) GetUserIdentities(ctx context.Context, userID int) ([]Identity, error) {
+	rows, err := s.query(ctx, `
+		SELECT id, user_id, issuer, subject, email, created_at, last_login_at
+		FROM user_identities WHERE user_id = ? ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []Identity
+	for rows.Next() {
+		var i Identity
+		if err := rows.Scan(&i.ID, &i.UserID, &i.Issuer, &i.Subject, &i.Email, &i.CreatedAt, &i.LastLoginAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
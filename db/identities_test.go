@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestMatchLogin(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	const iss = "https://auth.example"
+
+	alice := createTestUser(t, store, "Alice", "alice", "")
+	carol := createTestUser(t, store, "Carol", "carol", "")
+	createTestUser(t, store, "Dave", "dave", "")
+	createTestUser(t, store, "Erin", "erin", "")
+	// Profile emails are self-edited and never match a login.
+	store.UpdateUserProfile(ctx, alice.ID, Profile{Email: "carol@example.com"})
+	// Addresses seen on verified logins from another provider do.
+	const other = "https://other.example"
+	store.MatchLogin(ctx, Login{Issuer: other, Subject: "c", Username: "carol", Email: "Carol@example.com", EmailVerified: true})
+	store.MatchLogin(ctx, Login{Issuer: other, Subject: "d", Username: "dave", Email: "shared@example.com", EmailVerified: true})
+	store.MatchLogin(ctx, Login{Issuer: other, Subject: "e", Username: "erin", Email: "shared@example.com", EmailVerified: true})
+
+	match := func(l Login) *User {
+		t.Helper()
+		u, err := store.MatchLogin(ctx, l)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			t.Fatalf("MatchLogin(%+v): %v", l, err)
+		}
+		return u
+	}
+
+	// A legacy row is matched by username once and linked to the subject,
+	// so a later rename still finds it.
+	if u := match(Login{Issuer: iss, Subject: "sub-a", Username: "alice"}); u == nil || u.ID != alice.ID {
+		t.Fatalf("first login of alice = %+v", u)
+	}
+	if u := match(Login{Issuer: iss, Subject: "sub-a", Username: "alice.renamed"}); u == nil || u.ID != alice.ID {
+		t.Errorf("renamed alice = %+v", u)
+	}
+	// Someone else who takes the old username does not get alice's account.
+	if u := match(Login{Issuer: iss, Subject: "sub-x", Username: "alice"}); u != nil {
+		t.Errorf("recycled username matched %+v", u)
+	}
+	// The same username from another issuer is a second identity.
+	if u := match(Login{Issuer: other, Subject: "sub-a", Username: "alice"}); u == nil || u.ID != alice.ID {
+		t.Errorf("alice from another issuer = %+v", u)
+	}
+
+	// Email only counts when verified, and only when it is unambiguous.
+	if u := match(Login{Issuer: iss, Subject: "sub-c", Username: "cj", Email: "carol@example.com"}); u != nil {
+		t.Errorf("unverified email matched %+v", u)
+	}
+	if u := match(Login{Issuer: iss, Subject: "sub-c", Username: "cj", Email: "carol@example.com", EmailVerified: true}); u == nil || u.ID != carol.ID {
+		t.Errorf("verified email = %+v", u)
+	}
+	if u := match(Login{Issuer: iss, Subject: "sub-s", Username: "s", Email: "shared@example.com", EmailVerified: true}); u != nil {
+		t.Errorf("shared email matched %+v", u)
+	}
+
+	ids, err := store.GetUserIdentities(ctx, alice.ID)
+	if err != nil || len(ids) != 2 || ids[0].Subject != "sub-a" || ids[0].Issuer != iss {
+		t.Errorf("alice's identities = %+v, %v", ids, err)
+	}
+	if entries, _ := store.GetAuditLog(ctx, 10); len(entries) != 6 || entries[0].Action != AuditLinkIdentity {
+		t.Errorf("audit log = %+v", entries)
+	}
+
+	// Merging moves the identities to the survivor; deleting removes them.
+	if _, err := store.MergeUsers(ctx, carol.ID, alice.ID, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if u := match(Login{Issuer: iss, Subject: "sub-c"}); u == nil || u.ID != alice.ID {
+		t.Errorf("carol's login after merge = %+v", u)
+	}
+	if _, err := store.DeleteUser(ctx, alice.ID, SplitDisposition{}); err != nil {
+		t.Fatal(err)
+	}
+	if u := match(Login{Issuer: iss, Subject: "sub-a"}); u != nil {
+		t.Errorf("deleted user's login matched %+v", u)
+	}
+}
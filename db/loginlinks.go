@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrLoginLinkTooSoon is returned by CreateLoginLink when the address was
+// sent a link less than loginLinkInterval ago.
+var ErrLoginLinkTooSoon = errors.New("a sign-in link was sent to this address moments ago")
+
+// loginLinkInterval is the least time between two links to one address.
+const loginLinkInterval = time.Minute
+
+// CreateLoginLink stores a one-time sign-in link for the user, sent to email,
+// that works until expiresAt. Expired links are cleared out on the way.
+func (s *SQLStore) CreateLoginLink(ctx context.Context, tokenHash string, userID int, email string, expiresAt time.Time) error {
+	now := time.Now()
+	email = strings.ToLower(strings.TrimSpace(email))
+	if _, err := s.exec(ctx, "DELETE FROM login_links WHERE expires_at <= ?", timestamp(now)); err != nil {
+		return err
+	}
+	var recent int
+	if err := s.queryRow(ctx, "SELECT COUNT(*) FROM login_links WHERE email = ? AND created_at > ?",
+		email, timestamp(now.Add(-loginLinkInterval))).Scan(&recent); err != nil {
+		return err
+	}
+	if recent > 0 {
+		return ErrLoginLinkTooSoon
+	}
+	_, err := s.exec(ctx, "INSERT INTO login_links (token_hash, user_id, email, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		tokenHash, userID, email, timestamp(now), timestamp(expiresAt))
+	return err
+}
+
+// ConsumeLoginLink deletes the unexpired link with the given token hash and
+// returns the user it was made for and the address it was sent to, or
+// sql.ErrNoRows.
+func (s *SQLStore) ConsumeLoginLink(ctx context.Context, tokenHash string) (int, string, error) {
+	var userID int
+	var email string
+	err := s.queryRow(ctx, "DELETE FROM login_links WHERE token_hash = ? AND expires_at > ? RETURNING user_id, email",
+		tokenHash, timestamp(time.Now())).Scan(&userID, &email)
+	return userID, email, err
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGetUserByEmail(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	createTestUser(t, store, "Bob", "bob", "p2")
+	store.UpdateUserProfile(ctx, alice.ID, Profile{Email: "Alice@Example.com"})
+
+	if u, err := store.GetUserByEmail(ctx, " alice@example.COM "); err != nil || u.ID != alice.ID {
+		t.Errorf("GetUserByEmail(alice) = %+v, %v", u, err)
+	}
+	for _, email := range []string{"nobody@example.com", ""} {
+		if _, err := store.GetUserByEmail(ctx, email); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetUserByEmail(%q) = %v, want sql.ErrNoRows", email, err)
+		}
+	}
+}
+
+func TestLoginLinks(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	bob := createTestUser(t, store, "Bob", "bob", "p2")
+
+	if err := store.CreateLoginLink(ctx, "h1", alice.ID, "Alice@Example.com", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("CreateLoginLink: %v", err)
+	}
+	if err := store.CreateLoginLink(ctx, "h2", alice.ID, "alice@example.com", time.Now().Add(time.Hour)); !errors.Is(err, ErrLoginLinkTooSoon) {
+		t.Errorf("second link = %v, want ErrLoginLinkTooSoon", err)
+	}
+	store.CreateLoginLink(ctx, "old", bob.ID, "bob@example.com", time.Now().Add(-time.Minute))
+
+	if id, email, err := store.ConsumeLoginLink(ctx, "h1"); err != nil || id != alice.ID || email != "alice@example.com" {
+		t.Errorf("ConsumeLoginLink(h1) = %d, %q, %v", id, email, err)
+	}
+	for _, hash := range []string{"h1", "h2", "old"} {
+		if _, _, err := store.ConsumeLoginLink(ctx, hash); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("ConsumeLoginLink(%s) = %v, want sql.ErrNoRows", hash, err)
+		}
+	}
+}
+
+func TestEmailChangeEndsEmailLogins(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	store.UpdateUserProfile(ctx, alice.ID, Profile{Email: "alice@example.com"})
+	subject := strconv.Itoa(alice.ID)
+	expires := time.Now().Add(time.Hour)
+	store.CreateSession(ctx, &Session{TokenHash: "email", Username: "alice", Issuer: "email", Subject: subject, Role: "viewer"}, expires)
+	store.CreateSession(ctx, &Session{TokenHash: "sso", Username: "alice", Issuer: "https://id.example.com", Subject: "a1", Role: "viewer"}, expires)
+	store.CreateLoginLink(ctx, "link", alice.ID, "alice@example.com", expires)
+
+	store.UpdateUserProfile(ctx, alice.ID, Profile{Email: "alice@example.com", Venmo: "alice"})
+	if sessions, _ := store.GetSessions(ctx); len(sessions) != 2 {
+		t.Errorf("sessions after a venmo change = %d, want 2", len(sessions))
+	}
+	store.UpdateUserProfile(ctx, alice.ID, Profile{Email: "a@example.com"})
+	if sessions, _ := store.GetSessions(ctx); len(sessions) != 1 || sessions[0].TokenHash != "sso" {
+		t.Errorf("sessions after an email change = %+v, want the SSO one", sessions)
+	}
+	if _, _, err := store.ConsumeLoginLink(ctx, "link"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("link to the old address = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestEmailLoginsMigration(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	bob := createTestUser(t, store, "Bob", "bob", "p2")
+
+	// Revert 0016, when two users could share an email and email sessions
+	// named the address.
+	if _, err := MigrateDown(store.db, 1); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	store.db.Exec("UPDATE users SET email = 'family@example.com'")
+	expires := time.Now().Add(time.Hour)
+	store.CreateSession(ctx, &Session{TokenHash: "kept", Username: "alice", Issuer: "email", Subject: "family@example.com"}, expires)
+	store.CreateSession(ctx, &Session{TokenHash: "gone", Username: "carol", Issuer: "email", Subject: "carol@example.com"}, expires)
+	if _, err := MigrateUp(store.db, 0); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+
+	if u, _ := store.GetUserByID(ctx, alice.ID); u.Email != "family@example.com" {
+		t.Errorf("alice's email = %q, want it kept", u.Email)
+	}
+	if u, _ := store.GetUserByID(ctx, bob.ID); u.Email != "" {
+		t.Errorf("bob's email = %q, want it dropped", u.Email)
+	}
+	sessions, _ := store.GetSessions(ctx)
+	if len(sessions) != 1 || sessions[0].TokenHash != "kept" || sessions[0].Subject != strconv.Itoa(alice.ID) {
+		t.Errorf("sessions = %+v, want alice's, naming her ID", sessions)
+	}
+}
@@ -0,0 +1,328 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// Migration is a numbered schema change loaded from
+// migrations/<dialect>/NNNN_name.up.sql and its matching .down.sql. Every
+// dialect ships the same versions.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt string
+}
+
+var migrationFileExpr = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+func loadMigrations(d dialect) ([]Migration, error) {
+	dir := path.Join("migrations", d.String())
+	files, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, f := range files {
+		m := migrationFileExpr.FindStringSubmatch(f.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", f.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := migrationFiles.ReadFile(path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// latestSchemaVersion is the highest migration version shipped for d.
+func latestSchemaVersion(d dialect) int {
+	migrations, err := loadMigrations(d)
+	if err != nil || len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+func ensureMigrationsTable(conn *sql.DB) error {
+	_, err := conn.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	);`)
+	return err
+}
+
+func appliedMigrations(conn *sql.DB) (map[int]string, error) {
+	if err := ensureMigrationsTable(conn); err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]string{}
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// SchemaVersion returns the highest applied migration, or 0 for a fresh database.
+func SchemaVersion(conn *sql.DB) (int, error) {
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+func MigrationStatuses(conn *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(dialectOf(conn))
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		statuses[i] = MigrationStatus{Migration: m, Applied: ok, AppliedAt: appliedAt}
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies up to steps pending migrations in order, each in its own
+// transaction. steps <= 0 applies all of them. It returns how many ran.
+func MigrateUp(conn *sql.DB, steps int) (int, error) {
+	statuses, err := MigrationStatuses(conn)
+	if err != nil {
+		return 0, err
+	}
+
+	ran := 0
+	for _, s := range statuses {
+		if s.Applied {
+			continue
+		}
+		if steps > 0 && ran >= steps {
+			break
+		}
+		if err := applyMigration(conn, s.Migration, true); err != nil {
+			return ran, fmt.Errorf("migration %04d_%s: %w", s.Version, s.Name, err)
+		}
+		ran++
+	}
+	return ran, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first.
+func MigrateDown(conn *sql.DB, steps int) (int, error) {
+	statuses, err := MigrationStatuses(conn)
+	if err != nil {
+		return 0, err
+	}
+
+	ran := 0
+	for i := len(statuses) - 1; i >= 0 && ran < steps; i-- {
+		s := statuses[i]
+		if !s.Applied {
+			continue
+		}
+		if s.Down == "" {
+			return ran, fmt.Errorf("migration %04d_%s cannot be reverted", s.Version, s.Name)
+		}
+		if err := applyMigration(conn, s.Migration, false); err != nil {
+			return ran, fmt.Errorf("reverting %04d_%s: %w", s.Version, s.Name, err)
+		}
+		ran++
+	}
+	return ran, nil
+}
+
+func applyMigration(conn *sql.DB, m Migration, up bool) error {
+	d := dialectOf(conn)
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		if _, err := tx.Exec(m.Up); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(d.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"), m.Version, m.Name, timestamp(time.Now())); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.Exec(m.Down); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(d.rebind("DELETE FROM schema_migrations WHERE version = ?"), m.Version); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UpgradeLegacySchema brings databases created before migrations existed up
+// to the baseline, and must run before MigrateUp. Those were built by CREATE
+// TABLE plus ad-hoc ALTERs on boot, so older files can be missing columns the
+// baseline's CREATE TABLE IF NOT EXISTS would not add. It does nothing once
+// any migration has been applied, and on Postgres, which never had a legacy
+// schema.
+//
+// Legacy databases may link several users to one payee, which the baseline's
+// unique payee index forbids. Rather than refuse to start, the index is
+// created non-unique and the duplicates are returned for the admin to fix.
+func UpgradeLegacySchema(conn *sql.DB) ([]string, error) {
+	if dialectOf(conn) != dialectSQLite {
+		return nil, nil
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil || len(applied) > 0 {
+		return nil, err
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	columns, err := tableColumns(tx, "expense_splits")
+	if err != nil || len(columns) == 0 {
+		return nil, err
+	}
+
+	legacyColumns := []struct{ name, ddl string }{
+		{"auto_created", "INTEGER NOT NULL DEFAULT 0"},
+		{"expense_date", "TEXT NOT NULL DEFAULT ''"},
+		{"expense_note", "TEXT NOT NULL DEFAULT ''"},
+		{"archived", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, c := range legacyColumns {
+		if columns[c.name] {
+			continue
+		}
+		if _, err := tx.Exec("ALTER TABLE expense_splits ADD COLUMN " + c.name + " " + c.ddl); err != nil {
+			return nil, err
+		}
+	}
+
+	duplicates, err := duplicatePayeeLinks(tx)
+	if err != nil {
+		return nil, err
+	}
+	if len(duplicates) > 0 {
+		if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_users_payee ON users(actual_payee_id) WHERE actual_payee_id != ''"); err != nil {
+			return nil, err
+		}
+	}
+	return duplicates, tx.Commit()
+}
+
+// duplicatePayeeLinks describes every payee linked to more than one user.
+func duplicatePayeeLinks(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query(`
+		SELECT actual_payee_id, group_concat(name, ', ')
+		FROM users
+		WHERE actual_payee_id != ''
+		GROUP BY actual_payee_id
+		HAVING COUNT(*) > 1
+		ORDER BY actual_payee_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var duplicates []string
+	for rows.Next() {
+		var payeeID, names string
+		if err := rows.Scan(&payeeID, &names); err != nil {
+			return nil, err
+		}
+		duplicates = append(duplicates, fmt.Sprintf("payee %s is linked to %s", payeeID, names))
+	}
+	return duplicates, rows.Err()
+}
+
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[strings.ToLower(name)] = true
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,35 @@
+-- Flags stay INTEGER 0/1 and timestamps TEXT, as in SQLite, so both backends
+-- share the same queries and scanning code.
+
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	oidc_sub TEXT UNIQUE NOT NULL,
+	aid_class TEXT NOT NULL,
+	actual_payee_id TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS expense_splits (
+	id SERIAL PRIMARY KEY,
+	actual_transaction_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL REFERENCES users (id),
+	amount_owed INTEGER NOT NULL,
+	auto_created INTEGER NOT NULL DEFAULT 0,
+	expense_date TEXT NOT NULL DEFAULT '',
+	expense_note TEXT NOT NULL DEFAULT '',
+	archived INTEGER NOT NULL DEFAULT 0,
+	UNIQUE (actual_transaction_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS actual_writebacks (
+	id SERIAL PRIMARY KEY,
+	kind TEXT NOT NULL,
+	ref TEXT NOT NULL,
+	actual_transaction_id TEXT NOT NULL,
+	amount INTEGER NOT NULL DEFAULT 0,
+	notes TEXT NOT NULL DEFAULT '',
+	updated_at TEXT NOT NULL,
+	UNIQUE (kind, ref)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_payee ON users (actual_payee_id) WHERE actual_payee_id != '';
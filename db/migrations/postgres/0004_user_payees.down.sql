@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_payees;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_payee ON users (actual_payee_id) WHERE actual_payee_id != '';
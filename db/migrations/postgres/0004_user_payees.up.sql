@@ -0,0 +1,19 @@
+-- Every Actual payee a user pays from (Venmo, Zelle, cash...). A payee
+-- belongs to at most one user. users.actual_payee_id stays as the primary
+-- payee, used for receivable write-back, and is always listed here too.
+CREATE TABLE IF NOT EXISTS user_payees (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users (id),
+	actual_payee_id TEXT NOT NULL UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_payees_user ON user_payees(user_id);
+
+-- Legacy databases may link one payee to several users; the first keeps it.
+INSERT INTO user_payees (user_id, actual_payee_id)
+SELECT id, actual_payee_id FROM users WHERE actual_payee_id != '' ORDER BY id
+ON CONFLICT (actual_payee_id) DO NOTHING;
+
+-- user_payees now keeps payees unique, so the baseline index on the primary
+-- payee would only be a second rule that can disagree with it.
+DROP INDEX IF EXISTS idx_users_payee;
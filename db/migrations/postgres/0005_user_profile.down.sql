@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN paypal;
+ALTER TABLE users DROP COLUMN venmo;
+ALTER TABLE users DROP COLUMN phone;
+ALTER TABLE users DROP COLUMN email;
@@ -0,0 +1,6 @@
+-- Contact details and payment handles, so balances can be chased from the
+-- app instead of a separate spreadsheet.
+ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN phone TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN venmo TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN paypal TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,15 @@
+-- Server-side login sessions. The cookie holds a random token; only its
+-- SHA-256 is stored, so the session cookie cannot be rebuilt from the table.
+CREATE TABLE IF NOT EXISTS sessions (
+	id SERIAL PRIMARY KEY,
+	token_hash TEXT NOT NULL UNIQUE,
+	username TEXT NOT NULL,
+	is_admin INTEGER NOT NULL DEFAULT 0,
+	created_at TEXT NOT NULL,
+	last_seen_at TEXT NOT NULL,
+	expires_at TEXT NOT NULL,
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_username ON sessions(username);
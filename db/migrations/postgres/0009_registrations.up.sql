@@ -0,0 +1,10 @@
+-- Logins that reached the app before an admin created their user, waiting to
+-- be approved. Name and email come from the OIDC claims.
+CREATE TABLE IF NOT EXISTS registrations (
+	id SERIAL PRIMARY KEY,
+	oidc_sub TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL DEFAULT '',
+	email TEXT NOT NULL DEFAULT '',
+	requested_at TEXT NOT NULL,
+	last_login_at TEXT NOT NULL
+);
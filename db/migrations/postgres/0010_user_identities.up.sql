@@ -0,0 +1,23 @@
+-- The OIDC identities a user logs in with, keyed by issuer and the stable
+-- subject rather than the renameable username in users.oidc_sub. Users are
+-- linked on their first login after this migration.
+CREATE TABLE IF NOT EXISTS user_identities (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users (id),
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	email TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL,
+	last_login_at TEXT NOT NULL,
+	UNIQUE (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities(user_id);
+
+-- Sessions and queued registrations remember the login behind them, so the
+-- user is found through user_identities. Older sessions have neither and are
+-- still looked up by username until they expire.
+ALTER TABLE sessions ADD COLUMN issuer TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN subject TEXT NOT NULL DEFAULT '';
+ALTER TABLE registrations ADD COLUMN issuer TEXT NOT NULL DEFAULT '';
+ALTER TABLE registrations ADD COLUMN subject TEXT NOT NULL DEFAULT '';
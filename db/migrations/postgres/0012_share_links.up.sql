@@ -0,0 +1,26 @@
+-- Expiring links that show one user's ledger without a login, e.g. to a
+-- parent who pays their fees. Only the SHA-256 of the link's token is
+-- stored. Revoked links are kept so their view log stays readable.
+CREATE TABLE IF NOT EXISTS share_links (
+	id SERIAL PRIMARY KEY,
+	token_hash TEXT NOT NULL UNIQUE,
+	user_id INTEGER NOT NULL REFERENCES users (id),
+	label TEXT NOT NULL DEFAULT '',
+	created_by TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	expires_at TEXT NOT NULL,
+	revoked_at TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_user ON share_links(user_id);
+
+-- Every time a share link is opened.
+CREATE TABLE IF NOT EXISTS share_link_views (
+	id SERIAL PRIMARY KEY,
+	link_id INTEGER NOT NULL REFERENCES share_links (id),
+	viewed_at TEXT NOT NULL,
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_link_views_link ON share_link_views(link_id);
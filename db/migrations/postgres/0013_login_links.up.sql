@@ -0,0 +1,11 @@
+-- One-time email sign-in links for players without an OIDC login. Only the
+-- SHA-256 of the token is stored; using a link deletes it.
+CREATE TABLE IF NOT EXISTS login_links (
+	id SERIAL PRIMARY KEY,
+	token_hash TEXT NOT NULL UNIQUE,
+	email TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	expires_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_links_email ON login_links(email);
@@ -0,0 +1,17 @@
+UPDATE api_tokens SET subject = COALESCE((SELECT LOWER(u.email) FROM users u WHERE CAST(u.id AS TEXT) = api_tokens.subject), '')
+WHERE issuer = 'email';
+UPDATE sessions SET subject = COALESCE((SELECT LOWER(u.email) FROM users u WHERE CAST(u.id AS TEXT) = sessions.subject), '')
+WHERE issuer = 'email';
+
+DROP INDEX IF EXISTS idx_users_email;
+
+DROP TABLE IF EXISTS login_links;
+CREATE TABLE IF NOT EXISTS login_links (
+	id SERIAL PRIMARY KEY,
+	token_hash TEXT NOT NULL UNIQUE,
+	email TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	expires_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_links_email ON login_links(email);
@@ -0,0 +1,29 @@
+-- Emailed sign-in links and the sessions they start belong to a user, not an
+-- address, and no two users may share a profile email. Pending links are
+-- dropped; they only last a few minutes.
+DROP TABLE IF EXISTS login_links;
+CREATE TABLE IF NOT EXISTS login_links (
+	id SERIAL PRIMARY KEY,
+	token_hash TEXT NOT NULL UNIQUE,
+	user_id INTEGER NOT NULL REFERENCES users (id),
+	email TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	expires_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_links_email ON login_links(email);
+
+-- Of users sharing an email, the oldest keeps it.
+UPDATE users SET email = '' WHERE email <> '' AND EXISTS (
+	SELECT 1 FROM users u WHERE LOWER(u.email) = LOWER(users.email) AND u.id < users.id
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (LOWER(email)) WHERE email <> '';
+
+-- Email sessions and their tokens named the address; they now name the
+-- user ID. Those whose address no one has any more are signed out.
+DELETE FROM sessions WHERE issuer = 'email' AND subject NOT IN (SELECT LOWER(email) FROM users WHERE email <> '');
+UPDATE sessions SET subject = (SELECT CAST(u.id AS TEXT) FROM users u WHERE LOWER(u.email) = sessions.subject)
+WHERE issuer = 'email';
+DELETE FROM api_tokens WHERE issuer = 'email' AND subject NOT IN (SELECT LOWER(email) FROM users WHERE email <> '');
+UPDATE api_tokens SET subject = (SELECT CAST(u.id AS TEXT) FROM users u WHERE LOWER(u.email) = api_tokens.subject)
+WHERE issuer = 'email';
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	oidc_sub TEXT UNIQUE NOT NULL,
+	aid_class TEXT NOT NULL,
+	actual_payee_id TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS expense_splits (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	actual_transaction_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	amount_owed INTEGER NOT NULL,
+	auto_created INTEGER NOT NULL DEFAULT 0,
+	expense_date TEXT NOT NULL DEFAULT '',
+	expense_note TEXT NOT NULL DEFAULT '',
+	archived INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY (user_id) REFERENCES users (id),
+	UNIQUE(actual_transaction_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS actual_writebacks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	ref TEXT NOT NULL,
+	actual_transaction_id TEXT NOT NULL,
+	amount INTEGER NOT NULL DEFAULT 0,
+	notes TEXT NOT NULL DEFAULT '',
+	updated_at TEXT NOT NULL,
+	UNIQUE(kind, ref)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_expense_splits_tx_user ON expense_splits(actual_transaction_id, user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_payee ON users(actual_payee_id) WHERE actual_payee_id != '';
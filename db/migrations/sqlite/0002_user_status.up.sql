@@ -0,0 +1,4 @@
+-- Players who leave are deactivated instead of deleted, so their ledger
+-- stays. archived_at is empty while the user is active.
+ALTER TABLE users ADD COLUMN active INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN archived_at TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE sessions DROP COLUMN token_expires_at;
+ALTER TABLE sessions DROP COLUMN id_token;
+ALTER TABLE sessions DROP COLUMN refresh_token;
@@ -0,0 +1,6 @@
+-- OIDC tokens live with the session instead of in cookies. The refresh token
+-- renews the session; the ID token is only kept as the logout hint. Both are
+-- encrypted with a key derived from SESSION_SECRET (see 0014).
+ALTER TABLE sessions ADD COLUMN refresh_token TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN id_token TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN token_expires_at TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE sessions ADD COLUMN is_admin INTEGER NOT NULL DEFAULT 0;
+UPDATE sessions SET is_admin = 1 WHERE role = 'admin';
+ALTER TABLE sessions DROP COLUMN role;
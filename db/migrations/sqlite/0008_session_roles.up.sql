@@ -0,0 +1,5 @@
+-- Sessions carry a role (admin, treasurer, captain, viewer) instead of an
+-- admin flag.
+ALTER TABLE sessions ADD COLUMN role TEXT NOT NULL DEFAULT 'viewer';
+UPDATE sessions SET role = 'admin' WHERE is_admin = 1;
+ALTER TABLE sessions DROP COLUMN is_admin;
@@ -0,0 +1,6 @@
+ALTER TABLE registrations DROP COLUMN subject;
+ALTER TABLE registrations DROP COLUMN issuer;
+ALTER TABLE sessions DROP COLUMN subject;
+ALTER TABLE sessions DROP COLUMN issuer;
+
+DROP TABLE IF EXISTS user_identities;
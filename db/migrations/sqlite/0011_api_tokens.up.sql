@@ -0,0 +1,18 @@
+-- Personal access tokens for scripts. Like sessions, only the SHA-256 of the
+-- token is stored. The token acts as its creator's login, with the role they
+-- had when it was made, limited to its comma-separated scopes.
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	token_hash TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL,
+	username TEXT NOT NULL,
+	issuer TEXT NOT NULL DEFAULT '',
+	subject TEXT NOT NULL DEFAULT '',
+	role TEXT NOT NULL DEFAULT 'viewer',
+	scopes TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	expires_at TEXT NOT NULL,
+	last_used_at TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_username ON api_tokens(username);
@@ -0,0 +1,2 @@
+-- Encrypted tokens mean nothing to older releases, so they are dropped.
+UPDATE sessions SET refresh_token = '', id_token = '';
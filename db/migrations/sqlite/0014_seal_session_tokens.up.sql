@@ -0,0 +1,5 @@
+-- Session OIDC tokens are now stored encrypted with a key derived from
+-- SESSION_SECRET, since a refresh token can mint new tokens at the provider.
+-- Tokens stored in the clear before are dropped; those sessions keep working
+-- until they expire but are no longer renewed.
+UPDATE sessions SET refresh_token = '', id_token = '';
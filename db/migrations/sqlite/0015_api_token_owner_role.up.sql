@@ -0,0 +1,11 @@
+-- API tokens act with the lower of the role they were made with and the
+-- role their owner was given at their latest login, kept here. Existing
+-- tokens take it from the owner's newest session, or viewer until they
+-- next sign in.
+ALTER TABLE api_tokens ADD COLUMN owner_role TEXT NOT NULL DEFAULT 'viewer';
+UPDATE api_tokens SET owner_role = COALESCE((
+	SELECT s.role FROM sessions s
+	WHERE (api_tokens.subject <> '' AND s.issuer = api_tokens.issuer AND s.subject = api_tokens.subject)
+	   OR (api_tokens.subject = '' AND s.subject = '' AND s.username = api_tokens.username)
+	ORDER BY s.created_at DESC LIMIT 1
+), 'viewer');
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Profile holds a player's contact details and payment handles.
+type Profile struct {
+	Email  string `json:"email"`
+	Phone  string `json:"phone"`
+	Venmo  string `json:"venmo"`  // username, without the leading @
+	PayPal string `json:"paypal"` // paypal.me name
+}
+
+// ErrInvalidProfile wraps every validation failure from NormalizeProfile.
+var ErrInvalidProfile = errors.New("invalid profile")
+
+var (
+	handlePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+	phonePattern  = regexp.MustCompile(`^[0-9+()\-. ]{3,32}$`)
+)
+
+// NormalizeProfile trims the fields, strips the "@" and URL prefixes people
+// paste with their handles, and checks what is left.
+func NormalizeProfile(p Profile) (Profile, error) {
+	p.Email = strings.TrimSpace(p.Email)
+	p.Phone = strings.TrimSpace(p.Phone)
+	p.Venmo = trimHandle(p.Venmo, "venmo.com/u/", "venmo.com/")
+	p.PayPal = trimHandle(p.PayPal, "paypal.me/", "paypal.com/paypalme/")
+
+	if p.Email != "" {
+		addr, err := mail.ParseAddress(p.Email)
+		if err != nil || addr.Address != p.Email {
+			return p, fmt.Errorf("%w: %q is not an email address", ErrInvalidProfile, p.Email)
+		}
+	}
+	if p.Phone != "" && !phonePattern.MatchString(p.Phone) {
+		return p, fmt.Errorf("%w: %q is not a phone number", ErrInvalidProfile, p.Phone)
+	}
+	if p.Venmo != "" && !handlePattern.MatchString(p.Venmo) {
+		return p, fmt.Errorf("%w: %q is not a Venmo username", ErrInvalidProfile, p.Venmo)
+	}
+	if p.PayPal != "" && !handlePattern.MatchString(p.PayPal) {
+		return p, fmt.Errorf("%w: %q is not a PayPal.me name", ErrInvalidProfile, p.PayPal)
+	}
+	return p, nil
+}
+
+func trimHandle(s string, prefixes ...string) string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "https://")
+	s = strings.TrimPrefix(s, "www.")
+	for _, prefix := range prefixes {
+		if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+			s = s[len(prefix):]
+			break
+		}
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(s, "@"), "/")
+}
+
+// UpdateUserProfile validates and saves a user's profile. An email already on
+// another user's profile is refused, since emailed sign-in links go by it.
+// Changing the email signs out the sessions and tokens that came from links
+// sent to the old one.
+func (s *SQLStore) UpdateUserProfile(ctx context.Context, id int, p Profile) error {
+	p, err := NormalizeProfile(p)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old string
+	if err := tx.QueryRowContext(ctx, s.dialect.rebind("SELECT email FROM users WHERE id = ?"), id).Scan(&old); err != nil {
+		return err
+	}
+	if taken, err := s.emailTaken(ctx, tx, p.Email, id); err != nil {
+		return err
+	} else if taken {
+		return fmt.Errorf("%w: %s is already on another player's profile", ErrInvalidProfile, p.Email)
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind("UPDATE users SET email = ?, phone = ?, venmo = ?, paypal = ? WHERE id = ?"),
+		p.Email, p.Phone, p.Venmo, p.PayPal, id); err != nil {
+		return err
+	}
+	if !strings.EqualFold(old, p.Email) {
+		if err := s.deleteEmailLogins(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// emailTaken reports whether email is on the profile of a user other than id.
+func (s *SQLStore) emailTaken(ctx context.Context, tx *sql.Tx, email string, id int) (bool, error) {
+	if email == "" {
+		return false, nil
+	}
+	var n int
+	err := tx.QueryRowContext(ctx, s.dialect.rebind("SELECT COUNT(*) FROM users WHERE LOWER(email) = ? AND id <> ?"),
+		strings.ToLower(email), id).Scan(&n)
+	return n > 0, err
+}
+
+// deleteEmailLogins revokes a user's emailed sign-in links and the sessions
+// and API tokens that came from them.
+func (s *SQLStore) deleteEmailLogins(ctx context.Context, tx *sql.Tx, id int) error {
+	subject := strconv.Itoa(id)
+	for _, q := range []struct {
+		query string
+		arg   any
+	}{
+		{"DELETE FROM sessions WHERE issuer = 'email' AND subject = ?", subject},
+		{"DELETE FROM api_tokens WHERE issuer = 'email' AND subject = ?", subject},
+		{"DELETE FROM login_links WHERE user_id = ?", id},
+	} {
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(q.query), q.arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNormalizeProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      Profile
+		want    Profile
+		wantErr bool
+	}{
+		{name: "empty", in: Profile{}, want: Profile{}},
+		{
+			name: "pasted handles",
+			in:   Profile{Email: " a@example.com ", Phone: "+1 (555) 010-0000", Venmo: "@alice-s", PayPal: "https://paypal.me/AliceS/"},
+			want: Profile{Email: "a@example.com", Phone: "+1 (555) 010-0000", Venmo: "alice-s", PayPal: "AliceS"},
+		},
+		{name: "venmo link", in: Profile{Venmo: "venmo.com/u/alice"}, want: Profile{Venmo: "alice"}},
+		{name: "bad email", in: Profile{Email: "Alice <a@example.com>"}, wantErr: true},
+		{name: "bad phone", in: Profile{Phone: "call me"}, wantErr: true},
+		{name: "bad handle", in: Profile{Venmo: "alice smith"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeProfile(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidProfile) {
+					t.Errorf("NormalizeProfile(%+v) = %v, want ErrInvalidProfile", tt.in, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("NormalizeProfile(%+v) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateUserProfile(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+
+	if err := store.UpdateUserProfile(ctx, alice.ID, Profile{Email: "alice@example.com", Venmo: "@alice"}); err != nil {
+		t.Fatalf("UpdateUserProfile: %v", err)
+	}
+	got, _ := store.GetUserByID(ctx, alice.ID)
+	if got.Email != "alice@example.com" || got.Venmo != "alice" || got.Name != "Alice" {
+		t.Errorf("alice = %+v", got)
+	}
+
+	if err := store.UpdateUserProfile(ctx, alice.ID, Profile{Email: "nope"}); !errors.Is(err, ErrInvalidProfile) {
+		t.Errorf("invalid email = %v, want ErrInvalidProfile", err)
+	}
+	if got, _ := store.GetUserByID(ctx, alice.ID); got.Email != "alice@example.com" {
+		t.Errorf("email after rejected update = %q", got.Email)
+	}
+	bob := createTestUser(t, store, "Bob", "bob", "p2")
+	if err := store.UpdateUserProfile(ctx, bob.ID, Profile{Email: "ALICE@example.com"}); !errors.Is(err, ErrInvalidProfile) {
+		t.Errorf("alice's email on bob = %v, want ErrInvalidProfile", err)
+	}
+	if err := store.UpdateUserProfile(ctx, alice.ID, Profile{Email: "Alice@Example.com"}); err != nil {
+		t.Errorf("alice re-saving her own email = %v", err)
+	}
+	if err := store.UpdateUserProfile(ctx, 999, Profile{}); err == nil {
+		t.Error("UpdateUserProfile of a missing user succeeded")
+	}
+}
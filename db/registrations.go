@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Registration is a login that has no user yet, queued for an admin to
+// approve. Name and Email are what the identity provider sent.
+type Registration struct {
+	ID          int    `json:"id"`
+	OIDCSub     string `json:"oidc_sub"`
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	Issuer      string `json:"issuer"`
+	Subject     string `json:"subject"` // with Issuer, linked to the user on approval
+	RequestedAt string `json:"requested_at"`
+	LastLoginAt string `json:"last_login_at"`
+}
+
+const registrationColumns = "id, oidc_sub, name, email, issuer, subject, requested_at, last_login_at"
+
+func scanRegistration(row rowScanner) (*Registration, error) {
+	var reg Registration
+	if err := row.Scan(&reg.ID, &reg.OIDCSub, &reg.Name, &reg.Email, &reg.Issuer, &reg.Subject, &reg.RequestedAt, &reg.LastLoginAt); err != nil {
+		return nil, err
+	}
+	return &reg, nil
+}
+
+// AddRegistration queues a login, or refreshes its details and last login if
+// it is already queued.
+func (s *SQLStore) AddRegistration(ctx context.Context, reg Registration) error {
+	now := timestamp(time.Now())
+	_, err := s.exec(ctx, `
+		INSERT INTO registrations (oidc_sub, name, email, issuer, subject, requested_at, last_login_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(oidc_sub) DO UPDATE SET
+			name = excluded.name,
+			email = excluded.email,
+			issuer = excluded.issuer,
+			subject = excluded.subject,
+			last_login_at = excluded.last_login_at
+	`, reg.OIDCSub, strings.TrimSpace(reg.Name), strings.TrimSpace(reg.Email), reg.Issuer, reg.Subject, now, now)
+	return err
+}
+
+// GetRegistrations returns the queue, oldest request first.
+func (s *SQLStore) GetRegistrations(ctx context.Context) ([]Registration, error) {
+	rows, err := s.query(ctx, "SELECT "+registrationColumns+" FROM registrations ORDER BY requested_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var regs []Registration
+	for rows.Next() {
+		reg, err := scanRegistration(rows)
+		if err != nil {
+			return nil, err
+		}
+		regs = append(regs, *reg)
+	}
+	return regs, rows.Err()
+}
+
+// ApproveRegistration creates the user for a queued login and removes it from
+// the queue. An empty name falls back to the one from the claims, then to the
+// login; the email is kept when it is valid. The login the request came from
+// is linked to the new user, so it is recognised without logging in again.
+func (s *SQLStore) ApproveRegistration(ctx context.Context, id int, name, aidClass, actualPayeeID string) (*User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	reg, err := scanRegistration(tx.QueryRowContext(ctx, s.dialect.rebind("SELECT "+registrationColumns+" FROM registrations WHERE id = ?"), id))
+	if err != nil {
+		return nil, err
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = reg.Name
+	}
+	if name == "" {
+		name = reg.OIDCSub
+	}
+	// The email is left off if it is invalid or another user has it.
+	var email string
+	if p, err := NormalizeProfile(Profile{Email: reg.Email}); err == nil {
+		taken, err := s.emailTaken(ctx, tx, p.Email, 0)
+		if err != nil {
+			return nil, err
+		}
+		if !taken {
+			email = p.Email
+		}
+	}
+
+	userID, err := s.createUser(ctx, tx, name, reg.OIDCSub, aidClass, actualPayeeID, email)
+	if err != nil {
+		return nil, err
+	}
+	if reg.Subject != "" {
+		if err := s.linkIdentity(ctx, tx, userID, reg.Issuer, reg.Subject, ""); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetUserByID(ctx, userID)
+}
+
+// DeleteRegistration drops a login from the queue. It will be queued again if
+// it logs in again.
+func (s *SQLStore) DeleteRegistration(ctx context.Context, id int) error {
+	res, err := s.exec(ctx, "DELETE FROM registrations WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestRegistrations(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	store.AddRegistration(ctx, Registration{OIDCSub: "jane", Name: "Jane", Email: "JANE@example.com"})
+	store.AddRegistration(ctx, Registration{OIDCSub: "joe", Email: "not an email"})
+	if err := store.AddRegistration(ctx, Registration{OIDCSub: "jane", Name: "Jane Doe", Email: "jane@example.com"}); err != nil {
+		t.Fatalf("AddRegistration again: %v", err)
+	}
+	regs, err := store.GetRegistrations(ctx)
+	if err != nil || len(regs) != 2 || regs[0].OIDCSub != "jane" || regs[0].Name != "Jane Doe" {
+		t.Fatalf("GetRegistrations = %+v, %v", regs, err)
+	}
+
+	user, err := store.ApproveRegistration(ctx, regs[0].ID, "", "needs_help", "p1")
+	if err != nil {
+		t.Fatalf("ApproveRegistration: %v", err)
+	}
+	if user.Name != "Jane Doe" || user.OIDCSub != "jane" || user.AidClass != "needs_help" || user.ActualPayeeID != "p1" || user.Email != "jane@example.com" {
+		t.Errorf("approved user = %+v", user)
+	}
+	if _, err := store.ApproveRegistration(ctx, regs[0].ID, "", "regular", ""); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("approving twice = %v, want sql.ErrNoRows", err)
+	}
+
+	// An invalid email is dropped rather than failing the approval; a taken
+	// payee fails it and leaves the login queued.
+	if _, err := store.ApproveRegistration(ctx, regs[1].ID, "Joe", "regular", "p1"); err == nil {
+		t.Error("approving with a taken payee succeeded")
+	}
+	joe, err := store.ApproveRegistration(ctx, regs[1].ID, "Joe", "regular", "")
+	if err != nil || joe.Email != "" {
+		t.Errorf("ApproveRegistration(joe) = %+v, %v", joe, err)
+	}
+	if regs, _ := store.GetRegistrations(ctx); len(regs) != 0 {
+		t.Errorf("queue after approving = %+v", regs)
+	}
+
+	// Adding the user by hand also settles the request.
+	store.AddRegistration(ctx, Registration{OIDCSub: "max"})
+	createTestUser(t, store, "Max", "max", "")
+	if regs, _ := store.GetRegistrations(ctx); len(regs) != 0 {
+		t.Errorf("queue after CreateUser = %+v", regs)
+	}
+
+	store.AddRegistration(ctx, Registration{OIDCSub: "spam"})
+	regs, _ = store.GetRegistrations(ctx)
+	if err := store.DeleteRegistration(ctx, regs[0].ID); err != nil {
+		t.Errorf("DeleteRegistration: %v", err)
+	}
+	if err := store.DeleteRegistration(ctx, regs[0].ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteRegistration twice = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Session is a server-side login. The browser only holds the token whose
+// hash is TokenHash.
+type Session struct {
+	ID         int    `json:"id"`
+	TokenHash  string `json:"-"`
+	Username   string `json:"username"`
+	Issuer     string `json:"issuer"`
+	Subject    string `json:"subject"` // with Issuer, the login; "" for sessions from before identities
+	Role       string `json:"role"`    // an auth.Role
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	ExpiresAt  string `json:"expires_at"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	SessionTokens
+}
+
+// SessionTokens are the OIDC tokens behind a session. They never leave the
+// server.
+type SessionTokens struct {
+	RefreshToken   string `json:"-"`
+	IDToken        string `json:"-"` // kept for the logout id_token_hint
+	TokenExpiresAt string `json:"-"` // when the access token expires; "" if unknown
+}
+
+const sessionColumns = "id, token_hash, username, issuer, subject, role, created_at, last_seen_at, expires_at, user_agent, ip, refresh_token, id_token, token_expires_at"
+
+func scanSession(row rowScanner) (*Session, error) {
+	var sess Session
+	err := row.Scan(&sess.ID, &sess.TokenHash, &sess.Username, &sess.Issuer, &sess.Subject, &sess.Role, &sess.CreatedAt, &sess.LastSeenAt, &sess.ExpiresAt, &sess.UserAgent, &sess.IP,
+		&sess.RefreshToken, &sess.IDToken, &sess.TokenExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+// CreateSession stores a session that lasts until expiresAt and sets its ID.
+// Expired sessions are cleared out on the way.
+func (s *SQLStore) CreateSession(ctx context.Context, sess *Session, expiresAt time.Time) error {
+	now := timestamp(time.Now())
+	if _, err := s.exec(ctx, "DELETE FROM sessions WHERE expires_at <= ?", now); err != nil {
+		return err
+	}
+	sess.CreatedAt, sess.LastSeenAt, sess.ExpiresAt = now, now, timestamp(expiresAt)
+	return s.queryRow(ctx, `
+		INSERT INTO sessions (token_hash, username, issuer, subject, role, created_at, last_seen_at, expires_at, user_agent, ip, refresh_token, id_token, token_expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id
+	`, sess.TokenHash, sess.Username, sess.Issuer, sess.Subject, sess.Role, sess.CreatedAt, sess.LastSeenAt, sess.ExpiresAt, sess.UserAgent, sess.IP,
+		sess.RefreshToken, sess.IDToken, sess.TokenExpiresAt).Scan(&sess.ID)
+}
+
+// GetSession returns the unexpired session with the given token hash, or
+// sql.ErrNoRows.
+func (s *SQLStore) GetSession(ctx context.Context, tokenHash string) (*Session, error) {
+	return scanSession(s.queryRow(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE token_hash = ? AND expires_at > ?",
+		tokenHash, timestamp(time.Now())))
+}
+
+// GetSessions returns every unexpired session, most recently seen first.
+func (s *SQLStore) GetSessions(ctx context.Context) ([]Session, error) {
+	rows, err := s.query(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE expires_at > ? ORDER BY last_seen_at DESC, id DESC",
+		timestamp(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *sess)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchSession records that the session was just used.
+func (s *SQLStore) TouchSession(ctx context.Context, id int) error {
+	_, err := s.exec(ctx, "UPDATE sessions SET last_seen_at = ? WHERE id = ?", timestamp(time.Now()), id)
+	return err
+}
+
+// RenewSession stores refreshed tokens and pushes the session's expiry out
+// to expiresAt.
+func (s *SQLStore) RenewSession(ctx context.Context, id int, tokens SessionTokens, expiresAt time.Time) error {
+	res, err := s.exec(ctx, "UPDATE sessions SET refresh_token = ?, id_token = ?, token_expires_at = ?, expires_at = ? WHERE id = ?",
+		tokens.RefreshToken, tokens.IDToken, tokens.TokenExpiresAt, timestamp(expiresAt), id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+// DeleteSession revokes one session.
+func (s *SQLStore) DeleteSession(ctx context.Context, id int) error {
+	res, err := s.exec(ctx, "DELETE FROM sessions WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+// DeleteUserSessions revokes every session of a login and returns how many
+// there were.
+func (s *SQLStore) DeleteUserSessions(ctx context.Context, username string) (int, error) {
+	res, err := s.exec(ctx, "DELETE FROM sessions WHERE username = ?", username)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	alice := &Session{TokenHash: "h1", Username: "alice", Role: "admin", UserAgent: "test"}
+	if err := store.CreateSession(ctx, alice, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	store.CreateSession(ctx, &Session{TokenHash: "h2", Username: "alice"}, time.Now().Add(time.Hour))
+	store.CreateSession(ctx, &Session{TokenHash: "h3", Username: "bob"}, time.Now().Add(time.Hour))
+	store.CreateSession(ctx, &Session{TokenHash: "old", Username: "bob"}, time.Now().Add(-time.Minute))
+
+	got, err := store.GetSession(ctx, "h1")
+	if err != nil || got.ID != alice.ID || got.Role != "admin" || got.UserAgent != "test" {
+		t.Errorf("GetSession(h1) = %+v, %v", got, err)
+	}
+	if _, err := store.GetSession(ctx, "old"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSession(expired) = %v, want sql.ErrNoRows", err)
+	}
+	if sessions, _ := store.GetSessions(ctx); len(sessions) != 3 {
+		t.Errorf("GetSessions = %+v, want the 3 live ones", sessions)
+	}
+
+	if n, err := store.DeleteUserSessions(ctx, "alice"); err != nil || n != 2 {
+		t.Errorf("DeleteUserSessions = %d, %v", n, err)
+	}
+	if _, err := store.GetSession(ctx, "h1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("revoked session still found: %v", err)
+	}
+
+	bob, _ := store.GetSession(ctx, "h3")
+	if err := store.TouchSession(ctx, bob.ID); err != nil {
+		t.Errorf("TouchSession: %v", err)
+	}
+	if err := store.DeleteSession(ctx, bob.ID); err != nil {
+		t.Errorf("DeleteSession: %v", err)
+	}
+	if err := store.DeleteSession(ctx, bob.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteSession twice = %v, want sql.ErrNoRows", err)
+	}
+}
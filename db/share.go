@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// ShareLink shows one user's ledger to anyone holding its token, until it
+// expires or is revoked. Only TokenHash is stored.
+type ShareLink struct {
+	ID        int             `json:"id"`
+	TokenHash string          `json:"-"`
+	UserID    int             `json:"user_id"`
+	Label     string          `json:"label"` // who it was made for
+	CreatedBy string          `json:"created_by"`
+	CreatedAt string          `json:"created_at"`
+	ExpiresAt string          `json:"expires_at"`
+	RevokedAt string          `json:"revoked_at"` // "" while not revoked
+	Views     []ShareLinkView `json:"views"`      // newest first; only from GetShareLinks
+}
+
+// ShareLinkView records one opening of a share link.
+type ShareLinkView struct {
+	ID        int    `json:"id"`
+	LinkID    int    `json:"link_id"`
+	ViewedAt  string `json:"viewed_at"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+}
+
+// Expired reports whether the link's expiry has passed.
+func (l ShareLink) Expired() bool {
+	return l.ExpiresAt <= timestamp(time.Now())
+}
+
+const shareLinkColumns = "id, token_hash, user_id, label, created_by, created_at, expires_at, revoked_at"
+
+func scanShareLink(row rowScanner) (*ShareLink, error) {
+	var l ShareLink
+	if err := row.Scan(&l.ID, &l.TokenHash, &l.UserID, &l.Label, &l.CreatedBy, &l.CreatedAt, &l.ExpiresAt, &l.RevokedAt); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// CreateShareLink stores a link that works until expiresAt and sets its ID.
+func (s *SQLStore) CreateShareLink(ctx context.Context, l *ShareLink, expiresAt time.Time) error {
+	l.CreatedAt, l.ExpiresAt = timestamp(time.Now()), timestamp(expiresAt)
+	return s.queryRow(ctx, `
+		INSERT INTO share_links (token_hash, user_id, label, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id
+	`, l.TokenHash, l.UserID, l.Label, l.CreatedBy, l.CreatedAt, l.ExpiresAt).Scan(&l.ID)
+}
+
+// GetShareLink returns the unexpired, unrevoked link with the given token
+// hash, or sql.ErrNoRows.
+func (s *SQLStore) GetShareLink(ctx context.Context, tokenHash string) (*ShareLink, error) {
+	return scanShareLink(s.queryRow(ctx, "SELECT "+shareLinkColumns+" FROM share_links WHERE token_hash = ? AND revoked_at = '' AND expires_at > ?",
+		tokenHash, timestamp(time.Now())))
+}
+
+// GetShareLinks returns every link to a user's ledger, revoked and expired
+// ones included, newest first, with their views.
+func (s *SQLStore) GetShareLinks(ctx context.Context, userID int) ([]ShareLink, error) {
+	rows, err := s.query(ctx, "SELECT "+shareLinkColumns+" FROM share_links WHERE user_id = ? ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	var links []ShareLink
+	byID := map[int]int{}
+	for rows.Next() {
+		l, err := scanShareLink(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		byID[l.ID] = len(links)
+		links = append(links, *l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.query(ctx, `
+		SELECT v.id, v.link_id, v.viewed_at, v.ip, v.user_agent
+		FROM share_link_views v JOIN share_links l ON l.id = v.link_id
+		WHERE l.user_id = ? ORDER BY v.id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v ShareLinkView
+		if err := rows.Scan(&v.ID, &v.LinkID, &v.ViewedAt, &v.IP, &v.UserAgent); err != nil {
+			return nil, err
+		}
+		l := &links[byID[v.LinkID]]
+		l.Views = append(l.Views, v)
+	}
+	return links, rows.Err()
+}
+
+// RecordShareLinkView logs an opening of a link.
+func (s *SQLStore) RecordShareLinkView(ctx context.Context, linkID int, ip, userAgent string) error {
+	_, err := s.exec(ctx, "INSERT INTO share_link_views (link_id, viewed_at, ip, user_agent) VALUES (?, ?, ?, ?)",
+		linkID, timestamp(time.Now()), ip, userAgent)
+	return err
+}
+
+// RevokeShareLink stops a link from working. Revoking it twice returns
+// sql.ErrNoRows.
+func (s *SQLStore) RevokeShareLink(ctx context.Context, id int) error {
+	res, err := s.exec(ctx, "UPDATE share_links SET revoked_at = ? WHERE id = ? AND revoked_at = ''", timestamp(time.Now()), id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
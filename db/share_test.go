@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShareLinks(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "")
+
+	link := &ShareLink{TokenHash: "h1", UserID: alice.ID, Label: "Mom", CreatedBy: "alice"}
+	if err := store.CreateShareLink(ctx, link, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("CreateShareLink: %v", err)
+	}
+	store.CreateShareLink(ctx, &ShareLink{TokenHash: "old", UserID: alice.ID, CreatedBy: "admin"}, time.Now().Add(-time.Minute))
+
+	got, err := store.GetShareLink(ctx, "h1")
+	if err != nil || got.ID != link.ID || got.Label != "Mom" || got.Expired() {
+		t.Fatalf("GetShareLink(h1) = %+v, %v", got, err)
+	}
+	if _, err := store.GetShareLink(ctx, "old"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetShareLink(expired) = %v, want sql.ErrNoRows", err)
+	}
+
+	store.RecordShareLinkView(ctx, link.ID, "10.0.0.1", "phone")
+	store.RecordShareLinkView(ctx, link.ID, "10.0.0.2", "laptop")
+	if err := store.RevokeShareLink(ctx, link.ID); err != nil {
+		t.Fatalf("RevokeShareLink: %v", err)
+	}
+	if _, err := store.GetShareLink(ctx, "h1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("revoked link still works: %v", err)
+	}
+	if err := store.RevokeShareLink(ctx, link.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RevokeShareLink twice = %v, want sql.ErrNoRows", err)
+	}
+
+	// Revoked and expired links stay listed with their views.
+	links, err := store.GetShareLinks(ctx, alice.ID)
+	if err != nil || len(links) != 2 || links[1].ID != link.ID || links[1].RevokedAt == "" || !links[0].Expired() {
+		t.Fatalf("GetShareLinks = %+v, %v", links, err)
+	}
+	if views := links[1].Views; len(views) != 2 || views[0].UserAgent != "laptop" {
+		t.Errorf("views = %+v", views)
+	}
+
+	if _, err := store.DeleteUser(ctx, alice.ID, SplitDisposition{}); err != nil {
+		t.Errorf("DeleteUser with share links: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// OpenSQLite opens the SQLite file at path without touching its schema. It
+// fails rather than create the file when it does not exist.
+func OpenSQLite(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return openConn("sqlite3", path)
+}
+
+func NewSQLiteStore(conn *sql.DB) *SQLStore {
+	return &SQLStore{db: conn, dialect: dialectSQLite}
+}
+
+var memoryStoreSeq atomic.Int64
+
+// NewMemoryStore opens a fresh, fully migrated in-memory database. Every call
+// gets its own database, so tests do not share state.
+func NewMemoryStore() (*SQLStore, error) {
+	name := fmt.Sprintf("file:memdb%d?mode=memory&cache=shared", memoryStoreSeq.Add(1))
+	conn, err := sql.Open("sqlite3", name)
+	if err != nil {
+		return nil, err
+	}
+	// The database lives as long as one connection to it stays open.
+	conn.SetMaxIdleConns(1)
+	conn.SetConnMaxLifetime(0)
+	if _, err := MigrateUp(conn, 0); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return NewSQLiteStore(conn), nil
+}
@@ -0,0 +1,711 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUserHasSplits is returned by DeleteUser while the user still has splits.
+	ErrUserHasSplits = errors.New("user still has splits")
+	// ErrPayeeTaken is returned when linking a payee another user already has.
+	ErrPayeeTaken = errors.New("payee is already linked to another user")
+	// ErrArchivedSplitClash is returned when reassigning splits would combine
+	// an archived split with an active one on the same transaction.
+	ErrArchivedSplitClash = errors.New("an archived and an active split share a transaction")
+)
+
+// SQLStore implements Store on top of database/sql. It serves both SQLite and
+// Postgres: queries use "?" placeholders and are rebound for the connection's
+// dialect.
+type SQLStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+var _ Store = (*SQLStore)(nil)
+
+// NewStore wraps an open, migrated connection, detecting its dialect from the
+// driver.
+func NewStore(conn *sql.DB) *SQLStore {
+	return &SQLStore{db: conn, dialect: dialectOf(conn)}
+}
+
+func (s *SQLStore) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return s.db.ExecContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return s.db.QueryRowContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// --- User Queries ---
+
+// CreateUser adds a user; a non-empty payee becomes their primary payee.
+func (s *SQLStore) CreateUser(ctx context.Context, name, oidcSub, aidClass, actualPayeeID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := s.createUser(ctx, tx, name, oidcSub, aidClass, actualPayeeID, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// createUser inserts a user inside tx and drops any pending registration for
+// the same login, which the new user settles.
+func (s *SQLStore) createUser(ctx context.Context, tx *sql.Tx, name, oidcSub, aidClass, actualPayeeID, email string) (int, error) {
+	var id int
+	err := tx.QueryRowContext(ctx, s.dialect.rebind(`
+		INSERT INTO users (name, oidc_sub, aid_class, actual_payee_id, email) 
+		VALUES (?, ?, ?, ?, ?) RETURNING id
+	`), name, oidcSub, aidClass, actualPayeeID, email).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.addUserPayee(ctx, tx, id, actualPayeeID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind("DELETE FROM registrations WHERE oidc_sub = ?"), oidcSub); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// UpdateUser sets a user's fields. Changing the primary payee replaces the
+// old one in the user's payees; their other payees are kept.
+func (s *SQLStore) UpdateUser(ctx context.Context, id int, name, oidcSub, aidClass, actualPayeeID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldPayeeID string
+	if err := tx.QueryRowContext(ctx, s.dialect.rebind("SELECT actual_payee_id FROM users WHERE id = ?"), id).Scan(&oldPayeeID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, s.dialect.rebind(`
+		UPDATE users 
+		SET name = ?, oidc_sub = ?, aid_class = ?, actual_payee_id = ?
+		WHERE id = ?
+	`), name, oidcSub, aidClass, actualPayeeID, id)
+	if err != nil {
+		return err
+	}
+	if oldPayeeID != actualPayeeID {
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind("DELETE FROM user_payees WHERE user_id = ? AND actual_payee_id = ?"), id, oldPayeeID); err != nil {
+			return err
+		}
+		if err := s.addUserPayee(ctx, tx, id, actualPayeeID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// addUserPayee links payeeID to the user inside tx. It is a no-op for an
+// empty payee or one the user already has, and fails when another user has it.
+func (s *SQLStore) addUserPayee(ctx context.Context, tx *sql.Tx, userID int, payeeID string) error {
+	if payeeID == "" {
+		return nil
+	}
+	var owner int
+	err := tx.QueryRowContext(ctx, s.dialect.rebind("SELECT user_id FROM user_payees WHERE actual_payee_id = ?"), payeeID).Scan(&owner)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.ExecContext(ctx, s.dialect.rebind("INSERT INTO user_payees (user_id, actual_payee_id) VALUES (?, ?)"), userID, payeeID)
+		return err
+	case err != nil:
+		return err
+	case owner != userID:
+		return ErrPayeeTaken
+	}
+	return nil
+}
+
+// AddUserPayee gives a user another payee. A user without a primary payee
+// gets it as their primary.
+func (s *SQLStore) AddUserPayee(ctx context.Context, userID int, payeeID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.addUserPayee(ctx, tx, userID, payeeID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind("UPDATE users SET actual_payee_id = ? WHERE id = ? AND actual_payee_id = ''"), payeeID, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetUserPayees replaces a user's payees with the primary payee plus others.
+func (s *SQLStore) SetUserPayees(ctx context.Context, userID int, others []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var primary string
+	if err := tx.QueryRowContext(ctx, s.dialect.rebind("SELECT actual_payee_id FROM users WHERE id = ?"), userID).Scan(&primary); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind("DELETE FROM user_payees WHERE user_id = ?"), userID); err != nil {
+		return err
+	}
+	for _, payeeID := range append([]string{primary}, others...) {
+		if err := s.addUserPayee(ctx, tx, userID, payeeID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetPayeeOwners maps every linked payee ID to the ID of the user who owns it.
+func (s *SQLStore) GetPayeeOwners(ctx context.Context) (map[string]int, error) {
+	rows, err := s.query(ctx, "SELECT actual_payee_id, user_id FROM user_payees")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	owners := map[string]int{}
+	for rows.Next() {
+		var payeeID string
+		var userID int
+		if err := rows.Scan(&payeeID, &userID); err != nil {
+			return nil, err
+		}
+		owners[payeeID] = userID
+	}
+	return owners, rows.Err()
+}
+
+// loadPayeeIDs fills in PayeeIDs, primary payee first, for the given users.
+func (s *SQLStore) loadPayeeIDs(ctx context.Context, users []*User) error {
+	query := "SELECT user_id, actual_payee_id FROM user_payees ORDER BY id"
+	var args []any
+	if len(users) == 1 {
+		query = "SELECT user_id, actual_payee_id FROM user_payees WHERE user_id = ? ORDER BY id"
+		args = append(args, users[0].ID)
+	}
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byUser := map[int]*User{}
+	for _, u := range users {
+		u.PayeeIDs = []string{}
+		if u.ActualPayeeID != "" {
+			u.PayeeIDs = append(u.PayeeIDs, u.ActualPayeeID)
+		}
+		byUser[u.ID] = u
+	}
+	for rows.Next() {
+		var userID int
+		var payeeID string
+		if err := rows.Scan(&userID, &payeeID); err != nil {
+			return err
+		}
+		if u := byUser[userID]; u != nil && payeeID != u.ActualPayeeID {
+			u.PayeeIDs = append(u.PayeeIDs, payeeID)
+		}
+	}
+	return rows.Err()
+}
+
+const userColumns = "id, name, oidc_sub, aid_class, actual_payee_id, active, archived_at, email, phone, venmo, paypal"
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	var u User
+	var active int
+	if err := row.Scan(&u.ID, &u.Name, &u.OIDCSub, &u.AidClass, &u.ActualPayeeID, &active, &u.ArchivedAt,
+		&u.Email, &u.Phone, &u.Venmo, &u.PayPal); err != nil {
+		return nil, err
+	}
+	u.Active = active == 1
+	return &u, nil
+}
+
+func (s *SQLStore) GetUserBySub(ctx context.Context, sub string) (*User, error) {
+	return s.getUser(ctx, "oidc_sub", sub)
+}
+
+func (s *SQLStore) GetUserByID(ctx context.Context, id int) (*User, error) {
+	return s.getUser(ctx, "id", id)
+}
+
+// GetUserByEmail returns the user whose profile email matches, ignoring
+// case, or sql.ErrNoRows.
+func (s *SQLStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil, sql.ErrNoRows
+	}
+	return s.getUser(ctx, "LOWER(email)", email)
+}
+
+func (s *SQLStore) getUser(ctx context.Context, column string, value any) (*User, error) {
+	u, err := scanUser(s.queryRow(ctx, "SELECT "+userColumns+" FROM users WHERE "+column+" = ?", value))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadPayeeIDs(ctx, []*User{u}); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// GetAllUsers returns every user, inactive ones included.
+func (s *SQLStore) GetAllUsers(ctx context.Context) ([]User, error) {
+	rows, err := s.query(ctx, "SELECT "+userColumns+" FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ptrs []*User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		ptrs = append(ptrs, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := s.loadPayeeIDs(ctx, ptrs); err != nil {
+		return nil, err
+	}
+
+	users := make([]User, len(ptrs))
+	for i, u := range ptrs {
+		users[i] = *u
+	}
+	return users, nil
+}
+
+// SetUserActive deactivates a user, stamping archived_at, or reactivates one.
+// Inactive users keep their splits but are left out of pickers and auto-split.
+func (s *SQLStore) SetUserActive(ctx context.Context, id int, active bool) error {
+	archivedAt := ""
+	if !active {
+		archivedAt = timestamp(time.Now())
+	}
+	res, err := s.exec(ctx, "UPDATE users SET active = ?, archived_at = ? WHERE id = ?", boolInt(active), archivedAt, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+// SplitDisposition says what DeleteUser does with a user's splits, archived
+// ones included. The zero value keeps them, so the user is only deleted once
+// they have none.
+type SplitDisposition struct {
+	ReassignTo int  // move them to this user, folding shared transactions
+	WriteOff   bool // delete them
+}
+
+// DeleteUser disposes of a user's splits as told and removes the user with
+// their payees, identities, share links, sessions, API tokens and queued
+// registration, all in one transaction. It returns how many splits were
+// moved or written off. Splits left over give ErrUserHasSplits and change
+// nothing. Written-off splits leave the other participants' shares alone,
+// so those transactions show up as mismatched on the reconcile page.
+func (s *SQLStore) DeleteUser(ctx context.Context, id int, splits SplitDisposition) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var username string
+	if err := tx.QueryRowContext(ctx, s.dialect.rebind("SELECT oidc_sub FROM users WHERE id = ?"), id).Scan(&username); err != nil {
+		return 0, err
+	}
+
+	var disposed int
+	switch {
+	case splits.WriteOff:
+		res, err := tx.ExecContext(ctx, s.dialect.rebind("DELETE FROM expense_splits WHERE user_id = ?"), id)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		disposed = int(n)
+	case splits.ReassignTo != 0:
+		moved, combined, err := s.reassignSplits(ctx, tx, id, splits.ReassignTo)
+		if err != nil {
+			return 0, err
+		}
+		disposed = moved + combined
+	}
+
+	var left int
+	if err := tx.QueryRowContext(ctx, s.dialect.rebind("SELECT COUNT(*) FROM expense_splits WHERE user_id = ?"), id).Scan(&left); err != nil {
+		return 0, err
+	}
+	if left > 0 {
+		return 0, ErrUserHasSplits
+	}
+
+	// Logins are found through the identities, so they go first.
+	if err := s.deleteLogins(ctx, tx, id, username); err != nil {
+		return 0, err
+	}
+	for _, query := range []string{
+		"DELETE FROM user_payees WHERE user_id = ?",
+		"DELETE FROM user_identities WHERE user_id = ?",
+		"DELETE FROM share_link_views WHERE link_id IN (SELECT id FROM share_links WHERE user_id = ?)",
+		"DELETE FROM share_links WHERE user_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(query), id); err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.ExecContext(ctx, s.dialect.rebind("DELETE FROM users WHERE id = ?"), id)
+	if err != nil {
+		return 0, err
+	}
+	if err := requireRow(res); err != nil {
+		return 0, err
+	}
+	return disposed, tx.Commit()
+}
+
+// deleteLogins revokes the sessions and API tokens of a user's logins and
+// their emailed sign-in links, and drops their queued registration, inside tx. A login is the user's username
+// or one of their linked identities, so this must run before the identities
+// are deleted or moved.
+func (s *SQLStore) deleteLogins(ctx context.Context, tx *sql.Tx, userID int, username string) error {
+	for _, query := range []string{
+		`DELETE FROM sessions WHERE username = ? OR EXISTS (
+			SELECT 1 FROM user_identities i WHERE i.user_id = ? AND i.issuer = sessions.issuer AND i.subject = sessions.subject)`,
+		`DELETE FROM api_tokens WHERE username = ? OR EXISTS (
+			SELECT 1 FROM user_identities i WHERE i.user_id = ? AND i.issuer = api_tokens.issuer AND i.subject = api_tokens.subject)`,
+		`DELETE FROM registrations WHERE oidc_sub = ? OR EXISTS (
+			SELECT 1 FROM user_identities i WHERE i.user_id = ? AND i.issuer = registrations.issuer AND i.subject = registrations.subject)`,
+	} {
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(query), username, userID); err != nil {
+			return err
+		}
+	}
+	return s.deleteEmailLogins(ctx, tx, userID)
+}
+
+// requireRow turns an UPDATE or DELETE that matched nothing into
+// sql.ErrNoRows.
+func requireRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// --- Split Queries ---
+
+// SetAutoSplit and SetSplit upsert on UNIQUE(actual_transaction_id, user_id).
+// ON CONFLICT ... DO UPDATE with excluded.* is understood by SQLite and
+// Postgres alike, so both backends share these statements.
+func (s *SQLStore) SetAutoSplit(ctx context.Context, txID string, userID int, amount int, date string, note string) error {
+	_, err := s.exec(ctx, `
+		INSERT INTO expense_splits (actual_transaction_id, user_id, amount_owed, auto_created, expense_date, expense_note) 
+		VALUES (?, ?, ?, 1, ?, ?)
+		ON CONFLICT(actual_transaction_id, user_id) DO UPDATE SET 
+			amount_owed=excluded.amount_owed, auto_created=excluded.auto_created,
+			expense_date=excluded.expense_date, expense_note=excluded.expense_note, archived=0
+	`, txID, userID, amount, date, note)
+	return err
+}
+
+func (s *SQLStore) ClearSplitsForTx(ctx context.Context, txID string) error {
+	_, err := s.exec(ctx, "DELETE FROM expense_splits WHERE actual_transaction_id = ?", txID)
+	return err
+}
+
+func (s *SQLStore) SetSplit(ctx context.Context, txID string, userID int, amount int, date string, note string) error {
+	_, err := s.exec(ctx, `
+		INSERT INTO expense_splits (actual_transaction_id, user_id, amount_owed, auto_created, expense_date, expense_note) 
+		VALUES (?, ?, ?, 0, ?, ?)
+		ON CONFLICT(actual_transaction_id, user_id) DO UPDATE SET 
+			amount_owed=excluded.amount_owed, auto_created=0,
+			expense_date=excluded.expense_date, expense_note=excluded.expense_note, archived=0
+	`, txID, userID, amount, date, note)
+	return err
+}
+
+const splitColumns = "id, actual_transaction_id, user_id, amount_owed, auto_created, expense_date, expense_note, archived"
+
+func scanSplits(rows *sql.Rows) ([]ExpenseSplit, error) {
+	defer rows.Close()
+
+	var splits []ExpenseSplit
+	for rows.Next() {
+		var s ExpenseSplit
+		var autoCreated, archived int
+		if err := rows.Scan(&s.ID, &s.ActualTransactionID, &s.UserID, &s.AmountOwed, &autoCreated, &s.ExpenseDate, &s.ExpenseNote, &archived); err != nil {
+			return nil, err
+		}
+		s.AutoCreated = autoCreated == 1
+		s.Archived = archived == 1
+		splits = append(splits, s)
+	}
+	return splits, rows.Err()
+}
+
+// GetAllSplits returns every split that has not been archived.
+func (s *SQLStore) GetAllSplits(ctx context.Context) ([]ExpenseSplit, error) {
+	rows, err := s.query(ctx, "SELECT "+splitColumns+" FROM expense_splits WHERE archived = 0")
+	if err != nil {
+		return nil, err
+	}
+	return scanSplits(rows)
+}
+
+func (s *SQLStore) GetSplitsForUser(ctx context.Context, userID int) ([]ExpenseSplit, error) {
+	rows, err := s.query(ctx, "SELECT "+splitColumns+" FROM expense_splits WHERE user_id = ? AND archived = 0", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanSplits(rows)
+}
+
+func (s *SQLStore) GetSplitsForTx(ctx context.Context, txID string) ([]ExpenseSplit, error) {
+	rows, err := s.query(ctx, "SELECT "+splitColumns+" FROM expense_splits WHERE actual_transaction_id = ? AND archived = 0", txID)
+	if err != nil {
+		return nil, err
+	}
+	return scanSplits(rows)
+}
+
+// GetSplitTransactionIDs returns the IDs of every transaction that has splits,
+// archived ones included, so auto-split does not resurrect archived entries.
+func (s *SQLStore) GetSplitTransactionIDs(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.query(ctx, "SELECT DISTINCT actual_transaction_id FROM expense_splits")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// ArchiveSplitsForTx hides a transaction's splits from balances while keeping
+// the rows for reference.
+func (s *SQLStore) ArchiveSplitsForTx(ctx context.Context, txID string) error {
+	_, err := s.exec(ctx, "UPDATE expense_splits SET archived = 1 WHERE actual_transaction_id = ? AND archived = 0", txID)
+	return err
+}
+
+// SetSplitAmounts updates the amounts of existing splits, keyed by split ID,
+// in a single transaction.
+func (s *SQLStore) SetSplitAmounts(ctx context.Context, amounts map[int]int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for id, amount := range amounts {
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind("UPDATE expense_splits SET amount_owed = ? WHERE id = ?"), amount, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// reassignSplits moves every split of one user, archived ones included, to
+// another inside tx. Where both already share a transaction the amounts are
+// added into the target's split, as long as both are archived or both are
+// not; otherwise it returns ErrArchivedSplitClash. It returns the splits moved as they were and
+// those combined with one the target had.
+func (s *SQLStore) reassignSplits(ctx context.Context, tx *sql.Tx, fromID, toID int) (moved, combined int, err error) {
+	if fromID == toID {
+		return 0, 0, fmt.Errorf("cannot reassign splits to the same user")
+	}
+	var exists int
+	if err := tx.QueryRowContext(ctx, s.dialect.rebind("SELECT COUNT(*) FROM users WHERE id = ?"), toID).Scan(&exists); err != nil {
+		return 0, 0, err
+	}
+	if exists == 0 {
+		return 0, 0, sql.ErrNoRows
+	}
+
+	// A transaction holds one split per user, so shared transactions are
+	// combined. Combining an archived split with an active one would either
+	// drop an amount from the ledger or bring one back, so it is refused.
+	var clashes int
+	if err := tx.QueryRowContext(ctx, s.dialect.rebind(`
+		SELECT COUNT(*) FROM expense_splits src
+		JOIN expense_splits dst ON dst.actual_transaction_id = src.actual_transaction_id AND dst.user_id = ?
+		WHERE src.user_id = ? AND src.archived <> dst.archived
+	`), toID, fromID).Scan(&clashes); err != nil {
+		return 0, 0, err
+	}
+	if clashes > 0 {
+		return 0, 0, ErrArchivedSplitClash
+	}
+
+	// Fold amounts into splits the target already has on the same
+	// transaction, then drop the source's copies; move the rest.
+	folded, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		UPDATE expense_splits SET amount_owed = amount_owed + (
+			SELECT src.amount_owed FROM expense_splits src
+			WHERE src.user_id = ? AND src.actual_transaction_id = expense_splits.actual_transaction_id
+		)
+		WHERE user_id = ? AND actual_transaction_id IN (SELECT actual_transaction_id FROM expense_splits WHERE user_id = ?)
+	`), fromID, toID, fromID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		DELETE FROM expense_splits
+		WHERE user_id = ? AND actual_transaction_id IN (SELECT actual_transaction_id FROM expense_splits WHERE user_id = ?)
+	`), fromID, toID); err != nil {
+		return 0, 0, err
+	}
+	res, err := tx.ExecContext(ctx, s.dialect.rebind("UPDATE expense_splits SET user_id = ? WHERE user_id = ?"), toID, fromID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	nFolded, _ := folded.RowsAffected()
+	nMoved, _ := res.RowsAffected()
+	return int(nMoved), int(nFolded), nil
+}
+
+// MergeUsers folds a duplicate user into the one that survives: every split
+// moves over as in reassignSplits, the duplicate is deleted, and an audit
+// entry records who did it. The survivor keeps its own name, login and payee.
+func (s *SQLStore) MergeUsers(ctx context.Context, fromID, intoID int, actor string) (*MergeResult, error) {
+	from, err := s.GetUserByID(ctx, fromID)
+	if err != nil {
+		return nil, err
+	}
+	into, err := s.GetUserByID(ctx, intoID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &MergeResult{From: *from, Into: *into}
+	if result.Moved, result.Combined, err = s.reassignSplits(ctx, tx, fromID, intoID); err != nil {
+		return nil, err
+	}
+	// The duplicate's sessions and tokens were issued to a user that is
+	// going away; its logins reach the survivor once they sign in again.
+	if err := s.deleteLogins(ctx, tx, fromID, from.OIDCSub); err != nil {
+		return nil, err
+	}
+	// The survivor keeps its primary payee and takes the duplicate's as
+	// extra ones, so their deposits are still credited.
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind("UPDATE user_payees SET user_id = ? WHERE user_id = ?"), intoID, fromID); err != nil {
+		return nil, err
+	}
+	// Either login now reaches the survivor.
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind("UPDATE user_identities SET user_id = ? WHERE user_id = ?"), intoID, fromID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind("UPDATE share_links SET user_id = ? WHERE user_id = ?"), intoID, fromID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind("DELETE FROM users WHERE id = ?"), fromID); err != nil {
+		return nil, err
+	}
+	if err := s.addAuditEntry(ctx, tx, actor, AuditMergeUsers, result.String()); err != nil {
+		return nil, err
+	}
+	return result, tx.Commit()
+}
+
+// --- Writeback Queries ---
+
+// GetWriteback returns nil without an error when nothing has been written yet.
+func (s *SQLStore) GetWriteback(ctx context.Context, kind, ref string) (*Writeback, error) {
+	row := s.queryRow(ctx, "SELECT id, kind, ref, actual_transaction_id, amount, notes, updated_at FROM actual_writebacks WHERE kind = ? AND ref = ?", kind, ref)
+	var w Writeback
+	err := row.Scan(&w.ID, &w.Kind, &w.Ref, &w.ActualTransactionID, &w.Amount, &w.Notes, &w.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// GetWritebacks returns every recorded write-back of one kind.
+func (s *SQLStore) GetWritebacks(ctx context.Context, kind string) ([]Writeback, error) {
+	rows, err := s.query(ctx, "SELECT id, kind, ref, actual_transaction_id, amount, notes, updated_at FROM actual_writebacks WHERE kind = ? ORDER BY id", kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var writebacks []Writeback
+	for rows.Next() {
+		var w Writeback
+		if err := rows.Scan(&w.ID, &w.Kind, &w.Ref, &w.ActualTransactionID, &w.Amount, &w.Notes, &w.UpdatedAt); err != nil {
+			return nil, err
+		}
+		writebacks = append(writebacks, w)
+	}
+	return writebacks, rows.Err()
+}
+
+func (s *SQLStore) SetWriteback(ctx context.Context, kind, ref, actualTxID string, amount int, notes string) error {
+	_, err := s.exec(ctx, `
+		INSERT INTO actual_writebacks (kind, ref, actual_transaction_id, amount, notes, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(kind, ref) DO UPDATE SET
+			actual_transaction_id=excluded.actual_transaction_id, amount=excluded.amount,
+			notes=excluded.notes, updated_at=excluded.updated_at
+	`, kind, ref, actualTxID, amount, notes, timestamp(time.Now()))
+	return err
+}
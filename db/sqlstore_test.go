@@ -0,0 +1,540 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *SQLStore {
+	t.Helper()
+	store, err := NewMemoryStore()
+	if err != nil {
+		t.Fatalf("NewMemoryStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func createTestUser(t *testing.T, store Store, name, sub, payeeID string) User {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.CreateUser(ctx, name, sub, "regular", payeeID); err != nil {
+		t.Fatalf("CreateUser(%s): %v", name, err)
+	}
+	u, err := store.GetUserBySub(ctx, sub)
+	if err != nil {
+		t.Fatalf("GetUserBySub(%s): %v", sub, err)
+	}
+	return *u
+}
+
+func TestUserRoundTrip(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	if err := store.UpdateUser(ctx, alice.ID, "Alice Smith", "alice", "reduced", "p2"); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	got, err := store.GetUserByID(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	want := User{ID: alice.ID, Name: "Alice Smith", OIDCSub: "alice", AidClass: "reduced", ActualPayeeID: "p2", Active: true, PayeeIDs: []string{"p2"}}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("GetUserByID = %+v, want %+v", *got, want)
+	}
+
+	if _, err := store.GetUserBySub(ctx, "nobody"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserBySub(missing) error = %v, want sql.ErrNoRows", err)
+	}
+	if err := store.CreateUser(ctx, "Other", "other", "regular", "p2"); err == nil {
+		t.Error("CreateUser with a payee that is already linked succeeded")
+	}
+}
+
+func TestSetSplitUpserts(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+
+	if err := store.SetAutoSplit(ctx, "t1", alice.ID, 500, "2026-01-01", "dues"); err != nil {
+		t.Fatalf("SetAutoSplit: %v", err)
+	}
+	if err := store.SetSplit(ctx, "t1", alice.ID, 300, "2026-01-02", "dues (edited)"); err != nil {
+		t.Fatalf("SetSplit: %v", err)
+	}
+
+	splits, err := store.GetSplitsForTx(ctx, "t1")
+	if err != nil {
+		t.Fatalf("GetSplitsForTx: %v", err)
+	}
+	if len(splits) != 1 {
+		t.Fatalf("got %d splits, want the upsert to keep 1", len(splits))
+	}
+	s := splits[0]
+	if s.AmountOwed != 300 || s.AutoCreated || s.ExpenseDate != "2026-01-02" || s.ExpenseNote != "dues (edited)" {
+		t.Errorf("split after manual upsert = %+v", s)
+	}
+
+	if err := store.SetAutoSplit(ctx, "t1", alice.ID, 700, "2026-01-03", "dues"); err != nil {
+		t.Fatalf("SetAutoSplit: %v", err)
+	}
+	splits, _ = store.GetSplitsForTx(ctx, "t1")
+	if len(splits) != 1 || splits[0].AmountOwed != 700 || !splits[0].AutoCreated {
+		t.Errorf("split after auto upsert = %+v", splits)
+	}
+}
+
+func TestArchivedSplitsAreHidden(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	bob := createTestUser(t, store, "Bob", "bob", "p2")
+
+	for _, s := range []struct {
+		tx     string
+		userID int
+	}{{"t1", alice.ID}, {"t1", bob.ID}, {"t2", alice.ID}} {
+		if err := store.SetSplit(ctx, s.tx, s.userID, -1000, "", ""); err != nil {
+			t.Fatalf("SetSplit: %v", err)
+		}
+	}
+	if err := store.ArchiveSplitsForTx(ctx, "t1"); err != nil {
+		t.Fatalf("ArchiveSplitsForTx: %v", err)
+	}
+
+	all, _ := store.GetAllSplits(ctx)
+	if len(all) != 1 || all[0].ActualTransactionID != "t2" {
+		t.Errorf("GetAllSplits = %+v, want only t2", all)
+	}
+	forBob, _ := store.GetSplitsForUser(ctx, bob.ID)
+	if len(forBob) != 0 {
+		t.Errorf("GetSplitsForUser(bob) = %+v, want none", forBob)
+	}
+	forTx, _ := store.GetSplitsForTx(ctx, "t1")
+	if len(forTx) != 0 {
+		t.Errorf("GetSplitsForTx(t1) = %+v, want none", forTx)
+	}
+
+	// Archived transactions still count as split so auto-split skips them.
+	ids, err := store.GetSplitTransactionIDs(ctx)
+	if err != nil {
+		t.Fatalf("GetSplitTransactionIDs: %v", err)
+	}
+	if !ids["t1"] || !ids["t2"] {
+		t.Errorf("GetSplitTransactionIDs = %v, want t1 and t2", ids)
+	}
+
+	// Saving a split again brings it back.
+	if err := store.SetSplit(ctx, "t1", bob.ID, -400, "", ""); err != nil {
+		t.Fatalf("SetSplit: %v", err)
+	}
+	forBob, _ = store.GetSplitsForUser(ctx, bob.ID)
+	if len(forBob) != 1 || forBob[0].AmountOwed != -400 || forBob[0].Archived {
+		t.Errorf("GetSplitsForUser(bob) after re-save = %+v", forBob)
+	}
+}
+
+func TestSetSplitAmounts(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	bob := createTestUser(t, store, "Bob", "bob", "p2")
+	store.SetSplit(ctx, "t1", alice.ID, -500, "", "")
+	store.SetSplit(ctx, "t1", bob.ID, -500, "", "")
+
+	splits, _ := store.GetSplitsForTx(ctx, "t1")
+	amounts := map[int]int{}
+	for _, s := range splits {
+		amounts[s.ID] = -600
+		if s.UserID == bob.ID {
+			amounts[s.ID] = -400
+		}
+	}
+	if err := store.SetSplitAmounts(ctx, amounts); err != nil {
+		t.Fatalf("SetSplitAmounts: %v", err)
+	}
+
+	splits, _ = store.GetSplitsForTx(ctx, "t1")
+	for _, s := range splits {
+		if s.AmountOwed != amounts[s.ID] {
+			t.Errorf("split %d amount = %d, want %d", s.ID, s.AmountOwed, amounts[s.ID])
+		}
+	}
+}
+
+func TestWritebackUpsert(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	w, err := store.GetWriteback(ctx, "receivable", "1")
+	if w != nil || err != nil {
+		t.Fatalf("GetWriteback(missing) = %+v, %v; want nil, nil", w, err)
+	}
+
+	if err := store.SetWriteback(ctx, "receivable", "1", "tx1", -500, "first"); err != nil {
+		t.Fatalf("SetWriteback: %v", err)
+	}
+	if err := store.SetWriteback(ctx, "receivable", "1", "tx1", -700, "second"); err != nil {
+		t.Fatalf("SetWriteback: %v", err)
+	}
+
+	w, err = store.GetWriteback(ctx, "receivable", "1")
+	if err != nil {
+		t.Fatalf("GetWriteback: %v", err)
+	}
+	if w.ActualTransactionID != "tx1" || w.Amount != -700 || w.Notes != "second" || w.UpdatedAt == "" {
+		t.Errorf("GetWriteback = %+v", w)
+	}
+}
+
+func TestBaselineCannotBeReverted(t *testing.T) {
+	store := newTestStore(t)
+	createTestUser(t, store, "Alice", "alice", "p1")
+
+	// Everything after the baseline reverts; the baseline itself is refused.
+	latest := latestSchemaVersion(dialectSQLite)
+	if n, err := MigrateDown(store.db, latest); err == nil || n != latest-1 {
+		t.Fatalf("MigrateDown = %d, %v; want %d reverted and then an error", n, err, latest-1)
+	}
+	if version, _ := SchemaVersion(store.db); version != 1 {
+		t.Errorf("schema version after MigrateDown = %d, want 1", version)
+	}
+	var users int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&users); err != nil || users != 1 {
+		t.Errorf("users after refused MigrateDown = %d, %v", users, err)
+	}
+
+	if _, err := MigrateUp(store.db, 0); err != nil {
+		t.Fatalf("MigrateUp after MigrateDown: %v", err)
+	}
+	if _, err := store.GetAllUsers(context.Background()); err != nil {
+		t.Errorf("users table unusable after migrating back up: %v", err)
+	}
+}
+
+func TestUpgradeLegacySchemaReportsDuplicatePayees(t *testing.T) {
+	conn, err := sql.Open("sqlite3", "file:legacy?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetMaxIdleConns(1)
+	defer conn.Close()
+
+	_, err = conn.Exec(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, oidc_sub TEXT UNIQUE NOT NULL, aid_class TEXT NOT NULL, actual_payee_id TEXT NOT NULL);
+		CREATE TABLE expense_splits (id INTEGER PRIMARY KEY AUTOINCREMENT, actual_transaction_id TEXT NOT NULL, user_id INTEGER NOT NULL, amount_owed INTEGER NOT NULL, UNIQUE(actual_transaction_id, user_id));
+		INSERT INTO users (name, oidc_sub, aid_class, actual_payee_id) VALUES ('A', 'a', 'regular', 'p1'), ('B', 'b', 'regular', 'p1');
+		INSERT INTO expense_splits (actual_transaction_id, user_id, amount_owed) VALUES ('t1', 1, 500);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	duplicates, err := UpgradeLegacySchema(conn)
+	if err != nil {
+		t.Fatalf("UpgradeLegacySchema: %v", err)
+	}
+	if len(duplicates) != 1 {
+		t.Errorf("duplicates = %v, want one entry for p1", duplicates)
+	}
+	if _, err := MigrateUp(conn, 0); err != nil {
+		t.Fatalf("MigrateUp after legacy upgrade: %v", err)
+	}
+
+	splits, err := NewSQLiteStore(conn).GetAllSplits(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllSplits: %v", err)
+	}
+	if len(splits) != 1 || splits[0].AmountOwed != 500 || splits[0].Archived {
+		t.Errorf("legacy split after upgrade = %+v", splits)
+	}
+}
+
+func TestDeactivateUser(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	store.SetSplit(ctx, "t1", alice.ID, -500, "", "")
+
+	if err := store.SetUserActive(ctx, alice.ID, false); err != nil {
+		t.Fatalf("SetUserActive(false): %v", err)
+	}
+	got, _ := store.GetUserByID(ctx, alice.ID)
+	if got.Active || got.ArchivedAt == "" {
+		t.Errorf("after deactivating = %+v, want inactive with archived_at", got)
+	}
+	if splits, _ := store.GetSplitsForUser(ctx, alice.ID); len(splits) != 1 {
+		t.Errorf("deactivating dropped splits: %+v", splits)
+	}
+
+	if err := store.SetUserActive(ctx, alice.ID, true); err != nil {
+		t.Fatalf("SetUserActive(true): %v", err)
+	}
+	got, _ = store.GetUserByID(ctx, alice.ID)
+	if !got.Active || got.ArchivedAt != "" {
+		t.Errorf("after reactivating = %+v", got)
+	}
+
+	if err := store.SetUserActive(ctx, 999, false); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("SetUserActive(missing) = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestDeleteUserRefusesWhileSplitsExist(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	store.SetSplit(ctx, "t1", alice.ID, -500, "", "")
+	store.ArchiveSplitsForTx(ctx, "t1")
+
+	// Archived splits are still part of the ledger.
+	if _, err := store.DeleteUser(ctx, alice.ID, SplitDisposition{}); !errors.Is(err, ErrUserHasSplits) {
+		t.Fatalf("DeleteUser with splits = %v, want ErrUserHasSplits", err)
+	}
+
+	n, err := store.DeleteUser(ctx, alice.ID, SplitDisposition{WriteOff: true})
+	if err != nil || n != 1 {
+		t.Fatalf("DeleteUser writing off = %d, %v; want 1 split", n, err)
+	}
+	if _, err := store.GetUserByID(ctx, alice.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("user still there after DeleteUser: %v", err)
+	}
+	if _, err := store.DeleteUser(ctx, alice.ID, SplitDisposition{}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteUser(missing) = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestDeleteUserReassignsSplits(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	bob := createTestUser(t, store, "Bob", "bob", "p2")
+	store.SetSplit(ctx, "t1", alice.ID, 300, "", "")
+	store.SetSplit(ctx, "t1", bob.ID, 200, "", "")
+	store.SetSplit(ctx, "t2", alice.ID, 700, "", "")
+
+	// A failed delete leaves the splits where they were.
+	if _, err := store.DeleteUser(ctx, alice.ID, SplitDisposition{ReassignTo: alice.ID}); err == nil {
+		t.Error("DeleteUser reassigning to the same user succeeded")
+	}
+	if _, err := store.DeleteUser(ctx, alice.ID, SplitDisposition{ReassignTo: 999}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteUser reassigning to a missing user = %v, want sql.ErrNoRows", err)
+	}
+	if splits, _ := store.GetSplitsForUser(ctx, alice.ID); len(splits) != 2 {
+		t.Fatalf("alice's splits after failed deletes = %+v", splits)
+	}
+
+	n, err := store.DeleteUser(ctx, alice.ID, SplitDisposition{ReassignTo: bob.ID})
+	if err != nil || n != 2 {
+		t.Fatalf("DeleteUser reassigning = %d, %v; want 2", n, err)
+	}
+	got := map[string]int{}
+	splits, _ := store.GetSplitsForUser(ctx, bob.ID)
+	for _, s := range splits {
+		got[s.ActualTransactionID] = s.AmountOwed
+	}
+	if len(got) != 2 || got["t1"] != 500 || got["t2"] != 700 {
+		t.Errorf("bob's splits = %v, want t1 summed to 500 and t2 moved", got)
+	}
+}
+
+func TestDeleteUserRevokesLogins(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	createTestUser(t, store, "Bob", "bob", "p2")
+	store.MatchLogin(ctx, Login{Issuer: "https://idp", Subject: "sub-a", Username: "alice"})
+	for _, sess := range []*Session{
+		{TokenHash: "s1", Username: "alice"},
+		{TokenHash: "s2", Username: "alice-renamed", Issuer: "https://idp", Subject: "sub-a"},
+		{TokenHash: "s3", Username: "bob"},
+	} {
+		store.CreateSession(ctx, sess, time.Now().Add(time.Hour))
+	}
+	store.CreateAPIToken(ctx, &APIToken{TokenHash: "t1", Name: "script", Username: "alice", Scopes: []string{"read"}}, time.Now().Add(time.Hour))
+	store.AddRegistration(ctx, Registration{OIDCSub: "alice"})
+
+	if _, err := store.DeleteUser(ctx, alice.ID, SplitDisposition{}); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	sessions, _ := store.GetSessions(ctx)
+	if len(sessions) != 1 || sessions[0].Username != "bob" {
+		t.Errorf("sessions after delete = %+v, want only bob's", sessions)
+	}
+	if tokens, _ := store.GetAPITokens(ctx, ""); len(tokens) != 0 {
+		t.Errorf("API tokens after delete = %+v", tokens)
+	}
+	if regs, _ := store.GetRegistrations(ctx); len(regs) != 0 {
+		t.Errorf("registrations after delete = %+v", regs)
+	}
+}
+
+func TestMergeUsersRefusesArchivedClash(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	dupe := createTestUser(t, store, "Alice S", "alice.s", "p9")
+	store.SetSplit(ctx, "t1", alice.ID, 300, "", "")
+	store.ArchiveSplitsForTx(ctx, "t1")
+	store.SetSplit(ctx, "t1", dupe.ID, 200, "", "")
+
+	if _, err := store.MergeUsers(ctx, dupe.ID, alice.ID, "admin"); !errors.Is(err, ErrArchivedSplitClash) {
+		t.Fatalf("MergeUsers = %v, want ErrArchivedSplitClash", err)
+	}
+	if splits, _ := store.GetSplitsForUser(ctx, dupe.ID); len(splits) != 1 || splits[0].AmountOwed != 200 {
+		t.Errorf("duplicate's active split = %+v, want it untouched", splits)
+	}
+
+	// Once both are archived they combine like any other pair.
+	store.ArchiveSplitsForTx(ctx, "t1")
+	result, err := store.MergeUsers(ctx, dupe.ID, alice.ID, "admin")
+	if err != nil || result.Combined != 1 {
+		t.Fatalf("MergeUsers with both archived = %+v, %v", result, err)
+	}
+	if splits, _ := store.GetSplitsForUser(ctx, alice.ID); len(splits) != 0 {
+		t.Errorf("combined split came back active: %+v", splits)
+	}
+}
+
+func TestMergeUsers(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	dupe := createTestUser(t, store, "Alice S", "alice.s", "p9")
+	store.SetSplit(ctx, "t1", alice.ID, 300, "", "")
+	store.SetSplit(ctx, "t1", dupe.ID, 200, "", "")
+	store.SetSplit(ctx, "t2", dupe.ID, 700, "", "")
+	store.CreateSession(ctx, &Session{TokenHash: "s1", Username: "alice.s"}, time.Now().Add(time.Hour))
+	store.CreateAPIToken(ctx, &APIToken{TokenHash: "t1", Name: "script", Username: "alice.s", Scopes: []string{"read"}}, time.Now().Add(time.Hour))
+
+	result, err := store.MergeUsers(ctx, dupe.ID, alice.ID, "admin")
+	if err != nil {
+		t.Fatalf("MergeUsers: %v", err)
+	}
+	if result.Moved != 1 || result.Combined != 1 {
+		t.Errorf("result = %+v, want 1 moved and 1 combined", result)
+	}
+
+	if _, err := store.GetUserByID(ctx, dupe.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("duplicate still exists: %v", err)
+	}
+	got, _ := store.GetUserByID(ctx, alice.ID)
+	if got.Name != "Alice" || got.OIDCSub != "alice" || got.ActualPayeeID != "p1" {
+		t.Errorf("survivor = %+v, want its own identity and payee", got)
+	}
+	total := 0
+	splits, _ := store.GetSplitsForUser(ctx, alice.ID)
+	for _, s := range splits {
+		total += s.AmountOwed
+	}
+	if len(splits) != 2 || total != 1200 {
+		t.Errorf("survivor splits = %+v, want 2 totalling 1200", splits)
+	}
+
+	if sessions, _ := store.GetSessions(ctx); len(sessions) != 0 {
+		t.Errorf("duplicate's sessions survived the merge: %+v", sessions)
+	}
+	if tokens, _ := store.GetAPITokens(ctx, ""); len(tokens) != 0 {
+		t.Errorf("duplicate's API tokens survived the merge: %+v", tokens)
+	}
+
+	entries, err := store.GetAuditLog(ctx, 10)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("GetAuditLog = %+v, %v", entries, err)
+	}
+	if e := entries[0]; e.Actor != "admin" || e.Action != AuditMergeUsers || e.Details != result.String() {
+		t.Errorf("audit entry = %+v", e)
+	}
+
+	if _, err := store.MergeUsers(ctx, alice.ID, alice.ID, "admin"); err == nil {
+		t.Error("merging a user into itself succeeded")
+	}
+	if entries, _ := store.GetAuditLog(ctx, 10); len(entries) != 1 {
+		t.Errorf("a failed merge was audited: %+v", entries)
+	}
+}
+
+func TestUserPayees(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := createTestUser(t, store, "Alice", "alice", "p1")
+	bob := createTestUser(t, store, "Bob", "bob", "")
+
+	if err := store.AddUserPayee(ctx, alice.ID, "p2"); err != nil {
+		t.Fatalf("AddUserPayee: %v", err)
+	}
+	if err := store.AddUserPayee(ctx, bob.ID, "p2"); !errors.Is(err, ErrPayeeTaken) {
+		t.Errorf("AddUserPayee of alice's payee to bob = %v, want ErrPayeeTaken", err)
+	}
+	// A user without a primary payee gets the first one added as primary.
+	if err := store.AddUserPayee(ctx, bob.ID, "p3"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := store.GetUserByID(ctx, bob.ID)
+	if got.ActualPayeeID != "p3" || !reflect.DeepEqual(got.PayeeIDs, []string{"p3"}) {
+		t.Errorf("bob = %+v, want p3 as primary", got)
+	}
+
+	if err := store.SetUserPayees(ctx, alice.ID, []string{"p4", "p5"}); err != nil {
+		t.Fatalf("SetUserPayees: %v", err)
+	}
+	got, _ = store.GetUserByID(ctx, alice.ID)
+	if !reflect.DeepEqual(got.PayeeIDs, []string{"p1", "p4", "p5"}) {
+		t.Errorf("alice's payees = %v, want primary first and p2 dropped", got.PayeeIDs)
+	}
+	if err := store.SetUserPayees(ctx, alice.ID, []string{"p3"}); !errors.Is(err, ErrPayeeTaken) {
+		t.Errorf("SetUserPayees with bob's payee = %v, want ErrPayeeTaken", err)
+	}
+
+	owners, err := store.GetPayeeOwners(ctx)
+	want := map[string]int{"p1": alice.ID, "p4": alice.ID, "p5": alice.ID, "p3": bob.ID}
+	if err != nil || !reflect.DeepEqual(owners, want) {
+		t.Errorf("GetPayeeOwners = %v, %v; want %v", owners, err, want)
+	}
+
+	if _, err := store.MergeUsers(ctx, bob.ID, alice.ID, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = store.GetUserByID(ctx, alice.ID)
+	if got.ActualPayeeID != "p1" || !reflect.DeepEqual(got.PayeeIDs, []string{"p1", "p3", "p4", "p5"}) {
+		t.Errorf("survivor = %+v, want bob's payee added as an extra", got)
+	}
+}
+
+func TestUserPayeesMigrationBackfills(t *testing.T) {
+	store := newTestStore(t)
+	createTestUser(t, store, "Alice", "alice", "p1")
+	createTestUser(t, store, "Bob", "bob", "")
+
+	// Revert to just before 0004_user_payees and apply it again.
+	latest := latestSchemaVersion(dialectSQLite)
+	payeeIndex := func() bool {
+		var n int
+		store.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_users_payee'").Scan(&n)
+		return n == 1
+	}
+	if _, err := MigrateDown(store.db, latest-3); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	if !payeeIndex() {
+		t.Error("reverting 0004 did not restore idx_users_payee")
+	}
+	if _, err := MigrateUp(store.db, 0); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	// user_payees owns payee uniqueness from 0004 on.
+	if payeeIndex() {
+		t.Error("idx_users_payee is still there after 0004")
+	}
+	owners, err := store.GetPayeeOwners(context.Background())
+	if err != nil || len(owners) != 1 || owners["p1"] == 0 {
+		t.Errorf("owners after backfill = %v, %v; want only alice's p1", owners, err)
+	}
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Store is the persistence layer used by the handlers. Lookups of a single
+// row return sql.ErrNoRows when nothing matches.
+type Store interface {
+	UserStore
+	SplitStore
+	WritebackStore
+	AuditStore
+	SessionStore
+	RegistrationStore
+	APITokenStore
+	ShareLinkStore
+	LoginLinkStore
+	// Backup writes a consistent snapshot to path. Only SQLite supports it;
+	// other backends return ErrBackupUnsupported.
+	Backup(ctx context.Context, path string) error
+	// Export and Import move users and splits between databases; see Archive.
+	Export(ctx context.Context) (*Archive, error)
+	Import(ctx context.Context, a *Archive, opts ImportOptions) (*ImportSummary, error)
+	Close() error
+}
+
+type UserStore interface {
+	CreateUser(ctx context.Context, name, oidcSub, aidClass, actualPayeeID string) error
+	UpdateUser(ctx context.Context, id int, name, oidcSub, aidClass, actualPayeeID string) error
+	GetUserBySub(ctx context.Context, sub string) (*User, error)
+	GetUserByID(ctx context.Context, id int) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetAllUsers(ctx context.Context) ([]User, error)
+	SetUserActive(ctx context.Context, id int, active bool) error
+	DeleteUser(ctx context.Context, id int, splits SplitDisposition) (int, error)
+	MergeUsers(ctx context.Context, fromID, intoID int, actor string) (*MergeResult, error)
+	AddUserPayee(ctx context.Context, userID int, payeeID string) error
+	SetUserPayees(ctx context.Context, userID int, others []string) error
+	GetPayeeOwners(ctx context.Context) (map[string]int, error)
+	UpdateUserProfile(ctx context.Context, id int, p Profile) error
+	MatchLogin(ctx context.Context, l Login) (*User, error)
+	GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error)
+	GetUserIdentities(ctx context.Context, userID int) ([]Identity, error)
+}
+
+type SplitStore interface {
+	SetAutoSplit(ctx context.Context, txID string, userID int, amount int, date string, note string) error
+	SetSplit(ctx context.Context, txID string, userID int, amount int, date string, note string) error
+	ClearSplitsForTx(ctx context.Context, txID string) error
+	GetAllSplits(ctx context.Context) ([]ExpenseSplit, error)
+	GetSplitsForUser(ctx context.Context, userID int) ([]ExpenseSplit, error)
+	GetSplitsForTx(ctx context.Context, txID string) ([]ExpenseSplit, error)
+	GetSplitTransactionIDs(ctx context.Context) (map[string]bool, error)
+	ArchiveSplitsForTx(ctx context.Context, txID string) error
+	SetSplitAmounts(ctx context.Context, amounts map[int]int) error
+}
+
+type WritebackStore interface {
+	GetWriteback(ctx context.Context, kind, ref string) (*Writeback, error)
+	SetWriteback(ctx context.Context, kind, ref, actualTxID string, amount int, notes string) error
+	GetWritebacks(ctx context.Context, kind string) ([]Writeback, error)
+}
+
+type AuditStore interface {
+	AddAuditEntry(ctx context.Context, actor, action, details string) error
+	GetAuditLog(ctx context.Context, limit int) ([]AuditEntry, error)
+}
+
+type SessionStore interface {
+	CreateSession(ctx context.Context, sess *Session, expiresAt time.Time) error
+	GetSession(ctx context.Context, tokenHash string) (*Session, error)
+	GetSessions(ctx context.Context) ([]Session, error)
+	TouchSession(ctx context.Context, id int) error
+	RenewSession(ctx context.Context, id int, tokens SessionTokens, expiresAt time.Time) error
+	DeleteSession(ctx context.Context, id int) error
+	DeleteUserSessions(ctx context.Context, username string) (int, error)
+}
+
+type RegistrationStore interface {
+	AddRegistration(ctx context.Context, reg Registration) error
+	GetRegistrations(ctx context.Context) ([]Registration, error)
+	ApproveRegistration(ctx context.Context, id int, name, aidClass, actualPayeeID string) (*User, error)
+	DeleteRegistration(ctx context.Context, id int) error
+}
+
+type APITokenStore interface {
+	CreateAPIToken(ctx context.Context, t *APIToken, expiresAt time.Time) error
+	GetAPIToken(ctx context.Context, tokenHash string) (*APIToken, error)
+	GetAPITokens(ctx context.Context, username string) ([]APIToken, error)
+	TouchAPIToken(ctx context.Context, id int) error
+	SetAPITokenOwnerRole(ctx context.Context, issuer, subject, username, role string) error
+	DeleteAPIToken(ctx context.Context, id int) error
+	DeleteUserAPITokens(ctx context.Context, username string) (int, error)
+}
+
+type ShareLinkStore interface {
+	CreateShareLink(ctx context.Context, l *ShareLink, expiresAt time.Time) error
+	GetShareLink(ctx context.Context, tokenHash string) (*ShareLink, error)
+	GetShareLinks(ctx context.Context, userID int) ([]ShareLink, error)
+	RecordShareLinkView(ctx context.Context, linkID int, ip, userAgent string) error
+	RevokeShareLink(ctx context.Context, id int) error
+}
+
+type LoginLinkStore interface {
+	CreateLoginLink(ctx context.Context, tokenHash string, userID int, email string, expiresAt time.Time) error
+	ConsumeLoginLink(ctx context.Context, tokenHash string) (int, string, error)
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// APIToken is a personal access token. Only TokenHash is stored; the token
+// itself is shown once, when it is created.
+type APIToken struct {
+	ID         int      `json:"id"`
+	TokenHash  string   `json:"-"`
+	Name       string   `json:"name"`
+	Username   string   `json:"username"`
+	Issuer     string   `json:"issuer"`
+	Subject    string   `json:"subject"`
+	Role       string   `json:"role"`       // the creator's auth.Role at the time
+	OwnerRole  string   `json:"owner_role"` // the creator's auth.Role at their latest login
+	Scopes     []string `json:"scopes"`     // auth.Scope names
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at"`
+	LastUsedAt string   `json:"last_used_at"` // "" if never used
+}
+
+const apiTokenColumns = "id, token_hash, name, username, issuer, subject, role, owner_role, scopes, created_at, expires_at, last_used_at"
+
+func scanAPIToken(row rowScanner) (*APIToken, error) {
+	var t APIToken
+	var scopes string
+	if err := row.Scan(&t.ID, &t.TokenHash, &t.Name, &t.Username, &t.Issuer, &t.Subject, &t.Role, &t.OwnerRole, &scopes,
+		&t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt); err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Split(scopes, ",")
+	return &t, nil
+}
+
+// CreateAPIToken stores a token that lasts until expiresAt and sets its ID.
+// Expired tokens are cleared out on the way.
+func (s *SQLStore) CreateAPIToken(ctx context.Context, t *APIToken, expiresAt time.Time) error {
+	now := timestamp(time.Now())
+	if _, err := s.exec(ctx, "DELETE FROM api_tokens WHERE expires_at <= ?", now); err != nil {
+		return err
+	}
+	t.OwnerRole, t.CreatedAt, t.ExpiresAt = t.Role, now, timestamp(expiresAt)
+	return s.queryRow(ctx, `
+		INSERT INTO api_tokens (token_hash, name, username, issuer, subject, role, owner_role, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id
+	`, t.TokenHash, t.Name, t.Username, t.Issuer, t.Subject, t.Role, t.OwnerRole, strings.Join(t.Scopes, ","), t.CreatedAt, t.ExpiresAt).Scan(&t.ID)
+}
+
+// GetAPIToken returns the unexpired token with the given hash, or
+// sql.ErrNoRows.
+func (s *SQLStore) GetAPIToken(ctx context.Context, tokenHash string) (*APIToken, error) {
+	return scanAPIToken(s.queryRow(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ? AND expires_at > ?",
+		tokenHash, timestamp(time.Now())))
+}
+
+// GetAPITokens returns the unexpired tokens of a login, or everyone's when
+// username is empty, newest first.
+func (s *SQLStore) GetAPITokens(ctx context.Context, username string) ([]APIToken, error) {
+	query := "SELECT " + apiTokenColumns + " FROM api_tokens WHERE expires_at > ?"
+	args := []any{timestamp(time.Now())}
+	if username != "" {
+		query += " AND username = ?"
+		args = append(args, username)
+	}
+	rows, err := s.query(ctx, query+" ORDER BY id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// TouchAPIToken records that the token was just used.
+func (s *SQLStore) TouchAPIToken(ctx context.Context, id int) error {
+	_, err := s.exec(ctx, "UPDATE api_tokens SET last_used_at = ? WHERE id = ?", timestamp(time.Now()), id)
+	return err
+}
+
+// SetAPITokenOwnerRole records the role a login was just given on its
+// tokens, which never act with more than that. Logins from before identities
+// were tracked have no subject and are matched by username.
+func (s *SQLStore) SetAPITokenOwnerRole(ctx context.Context, issuer, subject, username, role string) error {
+	if subject == "" {
+		_, err := s.exec(ctx, "UPDATE api_tokens SET owner_role = ? WHERE subject = '' AND username = ? AND owner_role <> ?",
+			role, username, role)
+		return err
+	}
+	_, err := s.exec(ctx, "UPDATE api_tokens SET owner_role = ? WHERE issuer = ? AND subject = ? AND owner_role <> ?",
+		role, issuer, subject, role)
+	return err
+}
+
+// DeleteAPIToken revokes a token.
+func (s *SQLStore) DeleteAPIToken(ctx context.Context, id int) error {
+	res, err := s.exec(ctx, "DELETE FROM api_tokens WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+// DeleteUserAPITokens revokes every token of a login and returns how many
+// there were.
+func (s *SQLStore) DeleteUserAPITokens(ctx context.Context, username string) (int, error) {
+	res, err := s.exec(ctx, "DELETE FROM api_tokens WHERE username = ?", username)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
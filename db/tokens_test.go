@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAPITokens(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	bot := &APIToken{TokenHash: "h1", Name: "discord bot", Username: "alice", Role: "treasurer", Scopes: []string{"read:all", "write:splits"}}
+	if err := store.CreateAPIToken(ctx, bot, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	store.CreateAPIToken(ctx, &APIToken{TokenHash: "h2", Name: "sheet", Username: "bob", Scopes: []string{"read:own"}}, time.Now().Add(time.Hour))
+	store.CreateAPIToken(ctx, &APIToken{TokenHash: "old", Name: "old", Username: "bob", Scopes: []string{"read:own"}}, time.Now().Add(-time.Minute))
+
+	got, err := store.GetAPIToken(ctx, "h1")
+	if err != nil || got.ID != bot.ID || got.Role != "treasurer" || !reflect.DeepEqual(got.Scopes, bot.Scopes) || got.LastUsedAt != "" {
+		t.Errorf("GetAPIToken(h1) = %+v, %v", got, err)
+	}
+	if _, err := store.GetAPIToken(ctx, "old"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetAPIToken(expired) = %v, want sql.ErrNoRows", err)
+	}
+	if tokens, _ := store.GetAPITokens(ctx, "bob"); len(tokens) != 1 || tokens[0].Name != "sheet" {
+		t.Errorf("GetAPITokens(bob) = %+v", tokens)
+	}
+	if tokens, _ := store.GetAPITokens(ctx, ""); len(tokens) != 2 {
+		t.Errorf("GetAPITokens() = %+v, want the 2 live ones", tokens)
+	}
+
+	if err := store.TouchAPIToken(ctx, bot.ID); err != nil {
+		t.Errorf("TouchAPIToken: %v", err)
+	}
+	if got, _ := store.GetAPIToken(ctx, "h1"); got.LastUsedAt == "" {
+		t.Error("TouchAPIToken did not record the use")
+	}
+	if err := store.SetAPITokenOwnerRole(ctx, "", "", "alice", "viewer"); err != nil {
+		t.Errorf("SetAPITokenOwnerRole: %v", err)
+	}
+	if got, _ := store.GetAPIToken(ctx, "h1"); got.Role != "treasurer" || got.OwnerRole != "viewer" {
+		t.Errorf("after demotion = %+v, want treasurer made by a viewer", got)
+	}
+	if err := store.DeleteAPIToken(ctx, bot.ID); err != nil {
+		t.Errorf("DeleteAPIToken: %v", err)
+	}
+	if _, err := store.GetAPIToken(ctx, "h1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("revoked token still found: %v", err)
+	}
+	if err := store.DeleteAPIToken(ctx, bot.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteAPIToken twice = %v, want sql.ErrNoRows", err)
+	}
+	if n, err := store.DeleteUserAPITokens(ctx, "bob"); err != nil || n != 2 {
+		t.Errorf("DeleteUserAPITokens(bob) = %d, %v, want 2", n, err)
+	}
+}
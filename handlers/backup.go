@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"who-owes-me/db"
+	"who-owes-me/internal/envutil"
+)
+
+// StartBackups snapshots the database into BACKUP_DIR every interval.
+func (h *Handler) StartBackups(ctx context.Context, interval time.Duration) error {
+	dir := envutil.Getenv("BACKUP_DIR")
+	if dir == "" {
+		return fmt.Errorf("BACKUP_INTERVAL needs BACKUP_DIR")
+	}
+	db.StartBackups(ctx, h.store, dir, interval, db.BackupKeep())
+	return nil
+}
+
+// handleBackup saves a snapshot into BACKUP_DIR when one is configured, and
+// otherwise sends it to the browser as a download.
+func (h *Handler) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if dir := envutil.Getenv("BACKUP_DIR"); dir != "" {
+		path, err := db.Snapshot(r.Context(), h.store, dir, db.BackupKeep())
+		if err != nil {
+			fmt.Printf("Error taking backup: %v\n", err)
+			http.Redirect(w, r, "/admin?error="+url.QueryEscape("Backup failed: "+err.Error()), http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/admin?message="+url.QueryEscape("Backup saved to "+path), http.StatusFound)
+		return
+	}
+
+	tmpDir, err := os.MkdirTemp("", "who-owes-me-backup")
+	if err != nil {
+		http.Redirect(w, r, "/admin?error="+url.QueryEscape("Backup failed: "+err.Error()), http.StatusFound)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	name := "who-owes-me-" + time.Now().UTC().Format("20060102-150405") + ".db"
+	path := filepath.Join(tmpDir, name)
+	if err := h.store.Backup(r.Context(), path); err != nil {
+		fmt.Printf("Error taking backup: %v\n", err)
+		http.Redirect(w, r, "/admin?error="+url.QueryEscape("Backup failed: "+err.Error()), http.StatusFound)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	io.Copy(w, f)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"who-owes-me/actual"
+)
+
+func (h *Handler) handleCachePage(w http.ResponseWriter, r *http.Request) {
+	cache := actual.GetCache()
+	if cache == nil {
+		renderError(w, http.StatusServiceUnavailable, "Cache is not initialized.")
+		return
+	}
+
+	data := struct {
+		Stats        actual.CacheStats
+		Entries      []actual.CacheEntryInfo
+		SelectedKey  string
+		SelectedInfo actual.CacheEntryInfo
+		SelectedData string
+		SplitTag     string
+		Error        string
+		Message      string
+	}{
+		Stats:       cache.Stats(),
+		Entries:     cache.Entries(),
+		SelectedKey: r.URL.Query().Get("key"),
+		SplitTag:    splitTag,
+		Error:       r.URL.Query().Get("error"),
+		Message:     r.URL.Query().Get("message"),
+	}
+
+	if data.SelectedKey != "" {
+		if entry, info, ok := cache.Peek(data.SelectedKey); ok {
+			pretty, _ := json.MarshalIndent(entry, "", "  ")
+			data.SelectedInfo = info
+			data.SelectedData = string(pretty)
+		}
+	}
+
+	renderTemplate(w, r, "cache.html", data)
+}
+
+// handleCacheRefresh re-fetches a single cache entry from Actual. The key can
+// be given directly, or built from a payee and/or tag.
+func (h *Handler) handleCacheRefresh(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimSpace(r.FormValue("key"))
+	if key == "" {
+		payeeID := strings.TrimSpace(r.FormValue("payee_id"))
+		tag := strings.TrimSpace(r.FormValue("tag"))
+		switch {
+		case payeeID != "" && tag != "":
+			key = actual.PayeeTagCacheKey(payeeID, tag)
+		case tag != "":
+			key = actual.TagCacheKey(tag)
+		default:
+			key = actual.PayeesCacheKey
+		}
+	}
+
+	if err := actual.NewClient().RefreshCacheKey(key); err != nil {
+		redirectCachePage(w, r, key, "error", "Failed to refresh "+key+": "+err.Error())
+		return
+	}
+	redirectCachePage(w, r, key, "message", "Refreshed "+key)
+}
+
+func (h *Handler) handleCacheInvalidate(w http.ResponseWriter, r *http.Request) {
+	if prefix := r.FormValue("prefix"); prefix != "" {
+		actual.InvalidateCachePrefix(prefix)
+		redirectCachePage(w, r, "", "message", "Invalidated entries under "+prefix)
+		return
+	}
+
+	key := r.FormValue("key")
+	if key == "" {
+		actual.ClearCache()
+		redirectCachePage(w, r, "", "message", "Cleared all entries")
+		return
+	}
+	actual.InvalidateCacheKey(key)
+	redirectCachePage(w, r, "", "message", "Invalidated "+key)
+}
+
+func redirectCachePage(w http.ResponseWriter, r *http.Request, key, param, msg string) {
+	q := url.Values{}
+	if key != "" {
+		q.Set("key", key)
+	}
+	q.Set(param, msg)
+	http.Redirect(w, r, "/admin/cache?"+q.Encode(), http.StatusFound)
+}
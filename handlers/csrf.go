@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"who-owes-me/auth"
+)
+
+const (
+	// csrfField is the hidden form field, and csrfHeader the header HTMX
+	// sends, that must carry the browser's CSRF token.
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+	// maxFormSize caps form bodies read for their CSRF token; the largest
+	// form is an import upload.
+	maxFormSize = maxImportSize
+)
+
+const csrfCtxKey = contextKey("csrf")
+
+// CSRFProtect gives every browser a signed CSRF token in a cookie and turns
+// away POSTs and other unsafe requests that do not echo it in the csrf_token
+// field or the X-CSRF-Token header. Requests with an API token carry no
+// cookies, so they are let through.
+func CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.CSRFToken(r)
+		if err != nil {
+			// A fresh token for the pages rendered from here on; a POST
+			// without the cookie fails below either way.
+			token = auth.NewToken()
+			auth.SetCSRFCookie(w, token)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), csrfCtxKey, token))
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if auth.BearerToken(r) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sent := r.Header.Get(csrfHeader)
+		if sent == "" {
+			r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+			var perr error
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+				perr = r.ParseMultipartForm(maxFormSize)
+			} else {
+				perr = r.ParseForm()
+			}
+			var tooLarge *http.MaxBytesError
+			if errors.As(perr, &tooLarge) {
+				renderError(w, http.StatusRequestEntityTooLarge, "That upload is too large.")
+				return
+			}
+			sent = r.PostFormValue(csrfField)
+		}
+		if err != nil || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			renderError(w, http.StatusForbidden, "This form has expired or did not come from this site. Go back, reload the page and try again.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfFuncs give templates the request's CSRF token: csrfField as a hidden
+// input for forms, csrfToken for the HTMX header.
+func csrfFuncs(r *http.Request) template.FuncMap {
+	token, _ := r.Context().Value(csrfCtxKey).(string)
+	return template.FuncMap{
+		"csrfToken": func() string { return token },
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + csrfField + `" value="` + template.HTMLEscapeString(token) + `">`)
+		},
+	}
+}
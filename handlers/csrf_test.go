@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"who-owes-me/auth"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	h, _ := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == auth.CSRFCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("GET /admin set no HttpOnly CSRF cookie: %v", rec.Result().Cookies())
+	}
+	body := rec.Body.String()
+	m := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindStringSubmatch(body)
+	if m == nil || !strings.Contains(body, `"X-CSRF-Token": "`+m[1]+`"`) {
+		t.Fatal("admin page forms or HTMX header lack the CSRF token")
+	}
+	if forms, fields := strings.Count(body, `method="POST"`), strings.Count(body, `name="csrf_token"`); forms != fields {
+		t.Errorf("%d POST forms but %d CSRF fields", forms, fields)
+	}
+	token := m[1]
+
+	post := func(withCookie bool, field, header string) int {
+		form := url.Values{}
+		if field != "" {
+			form.Set("csrf_token", field)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/admin/notifications/clear", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			req.Header.Set("X-CSRF-Token", header)
+		}
+		if withCookie {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for _, tt := range []struct {
+		name          string
+		cookie        bool
+		field, header string
+		want          int
+	}{
+		{"form field", true, token, "", http.StatusFound},
+		{"HTMX header", true, "", token, http.StatusFound},
+		{"no token", true, "", "", http.StatusForbidden},
+		{"wrong token", true, "forged", "", http.StatusForbidden},
+		{"no cookie", false, token, "", http.StatusForbidden},
+	} {
+		if got := post(tt.cookie, tt.field, tt.header); got != tt.want {
+			t.Errorf("%s: POST = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
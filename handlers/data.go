@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"who-owes-me/db"
+)
+
+// maxImportSize caps uploaded import files.
+const maxImportSize = 32 << 20
+
+func (h *Handler) handleDataPage(w http.ResponseWriter, r *http.Request) {
+	renderDataPage(w, r, nil, "")
+}
+
+func renderDataPage(w http.ResponseWriter, r *http.Request, summary *db.ImportSummary, errMsg string) {
+	if errMsg == "" {
+		errMsg = r.URL.Query().Get("error")
+	}
+	renderTemplate(w, r, "data.html", struct {
+		Summary *db.ImportSummary
+		Error   string
+	}{
+		Summary: summary,
+		Error:   errMsg,
+	})
+}
+
+// handleExport downloads every user and split as a zip of JSON and CSV.
+func (h *Handler) handleExport(w http.ResponseWriter, r *http.Request) {
+	archive, err := h.store.Export(r.Context())
+	if err != nil {
+		fmt.Printf("Error exporting data: %v\n", err)
+		renderDataPage(w, r, nil, "Export failed: "+err.Error())
+		return
+	}
+
+	name := "who-owes-me-export-" + time.Now().UTC().Format("20060102-150405") + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if err := db.WriteArchive(w, archive); err != nil {
+		fmt.Printf("Error writing export: %v\n", err)
+	}
+}
+
+// handleImport merges an uploaded export into the database and shows what
+// changed. With dry_run set nothing is saved.
+func (h *Handler) handleImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		renderDataPage(w, r, nil, "Choose an export file to import")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		renderDataPage(w, r, nil, "Could not read the upload: "+err.Error())
+		return
+	}
+	archive, err := db.ReadArchive(data)
+	if err != nil {
+		renderDataPage(w, r, nil, err.Error())
+		return
+	}
+
+	summary, err := h.store.Import(r.Context(), archive, db.ImportOptions{
+		OnConflict: r.FormValue("on_conflict"),
+		DryRun:     r.FormValue("dry_run") != "",
+	})
+	if err != nil {
+		renderDataPage(w, r, nil, "Import failed, nothing was saved: "+err.Error())
+		return
+	}
+	if !summary.DryRun && summary.SplitsCreated+summary.SplitsUpdated > 0 {
+		h.scheduleWriteback()
+	}
+	renderDataPage(w, r, summary, "")
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"who-owes-me/auth"
+	"who-owes-me/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type loginPageData struct {
+	SSO     bool   // offer the OIDC provider too
+	Token   string // a signed emailed-link token waiting to be confirmed
+	Message string
+	Error   string
+}
+
+func renderLoginPage(w http.ResponseWriter, r *http.Request, data loginPageData) {
+	data.SSO = auth.Provider != nil
+	funcs := csrfFuncs(r)
+	funcs["signedIn"] = func() bool { return false }
+	renderWithFuncs(w, "login.html", data, funcs)
+}
+
+// handleSendLoginLink emails a sign-in link to a registered player. The
+// reply is the same whether or not the address is on file.
+func (h *Handler) handleSendLoginLink(w http.ResponseWriter, r *http.Request) {
+	if auth.Mail == nil {
+		http.NotFound(w, r)
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email == "" {
+		http.Redirect(w, r, "/login?error="+url.QueryEscape("Enter your email address"), http.StatusFound)
+		return
+	}
+
+	user, err := h.store.GetUserByEmail(r.Context(), email)
+	if err == nil {
+		token := auth.NewToken()
+		err = h.store.CreateLoginLink(r.Context(), auth.HashToken(token), user.ID, user.Email, time.Now().Add(auth.LoginLinkTTL))
+		if err == nil {
+			// Sent in the background so the reply takes as long for unknown
+			// addresses.
+			go func() {
+				if err := h.sendLoginLink(user.Email, token); err != nil {
+					fmt.Printf("Error emailing sign-in link to %s: %v\n", user.Name, err)
+				}
+			}()
+		}
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, db.ErrLoginLinkTooSoon) {
+		fmt.Printf("Error creating sign-in link: %v\n", err)
+	}
+
+	msg := fmt.Sprintf("If %s belongs to a registered player, a sign-in link is on its way. It works once, within %d minutes.",
+		email, int(auth.LoginLinkTTL.Minutes()))
+	http.Redirect(w, r, "/login?message="+url.QueryEscape(msg), http.StatusFound)
+}
+
+// handleLoginLinkPage asks the player to confirm before the link is used, so
+// mail scanners that open links do not burn it.
+func (h *Handler) handleLoginLinkPage(w http.ResponseWriter, r *http.Request) {
+	if auth.Mail == nil {
+		http.NotFound(w, r)
+		return
+	}
+	renderLoginPage(w, r, loginPageData{Token: chi.URLParam(r, "token")})
+}
+
+// handleLoginLink uses up an emailed link and signs the player in as a
+// viewer of their own ledger.
+func (h *Handler) handleLoginLink(w http.ResponseWriter, r *http.Request) {
+	if auth.Mail == nil {
+		http.NotFound(w, r)
+		return
+	}
+	fail := func(msg string) {
+		http.Redirect(w, r, "/login?error="+url.QueryEscape(msg), http.StatusFound)
+	}
+
+	token, err := auth.Verify(chi.URLParam(r, "token"))
+	if err != nil {
+		fail("This sign-in link is invalid. Request a new one below.")
+		return
+	}
+	userID, email, err := h.store.ConsumeLoginLink(r.Context(), auth.HashToken(token))
+	if err != nil {
+		fail("This sign-in link has expired or was already used. Request a new one below.")
+		return
+	}
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil || !strings.EqualFold(user.Email, email) {
+		fail("No player has this email address any more.")
+		return
+	}
+
+	if err := h.startSession(w, r, &db.Session{
+		Username: user.OIDCSub,
+		Issuer:   auth.EmailIssuer,
+		Subject:  strconv.Itoa(user.ID),
+		Role:     string(auth.RoleViewer),
+	}); err != nil {
+		http.Error(w, "Failed to start session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"who-owes-me/auth"
+	"who-owes-me/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestEmailLogin(t *testing.T) {
+	_, store := newTestServer(t)
+	ctx := context.Background()
+	store.CreateUser(ctx, "Alice", "alice", "regular", "p1")
+	alice, _ := store.GetUserBySub(ctx, "alice")
+	store.UpdateUserProfile(ctx, alice.ID, db.Profile{Email: "Alice@example.com"})
+
+	auth.Mail = &auth.MailConfig{From: "team@example.com", BaseURL: "http://app.test"}
+	t.Cleanup(func() { auth.Mail = nil })
+	type sent struct{ to, token string }
+	outbox := make(chan sent, 4)
+	hd := New(store)
+	hd.sendLoginLink = func(to, token string) error {
+		outbox <- sent{to, token}
+		return nil
+	}
+	r := chi.NewRouter()
+	hd.RegisterRoutes(r)
+
+	do := func(method, path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if method == http.MethodPost {
+			req.Header.Set(csrfHeader, testCSRFToken)
+			addCSRF(req)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/", nil); rec.Header().Get("Location") != "/login" {
+		t.Fatalf("GET / without a session = %d %q, want a redirect to /login", rec.Code, rec.Header().Get("Location"))
+	}
+	if body := do(http.MethodGet, "/login", nil).Body.String(); !strings.Contains(body, `action="/login/email"`) || strings.Contains(body, "/login/sso") {
+		t.Error("login page does not offer just the email form")
+	}
+
+	postForm(r, "/login/email", url.Values{"email": {"nobody@example.com"}})
+	rec := postForm(r, "/login/email", url.Values{"email": {"alice@EXAMPLE.com"}})
+	if !strings.Contains(rec.Header().Get("Location"), "message=") {
+		t.Fatalf("POST /login/email = %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	var mail sent
+	select {
+	case mail = <-outbox:
+	case <-time.After(time.Second):
+		t.Fatal("no sign-in link was sent")
+	}
+	if mail.to != "Alice@example.com" {
+		t.Errorf("link sent to %q", mail.to)
+	}
+	postForm(r, "/login/email", url.Values{"email": {"alice@example.com"}})
+	select {
+	case extra := <-outbox:
+		t.Errorf("unexpected second link to %q", extra.to)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	link := "/login/email/" + url.PathEscape(auth.Sign(mail.token))
+	if body := do(http.MethodGet, link, nil).Body.String(); !strings.Contains(body, `method="POST"`) || !strings.Contains(body, "Continue") {
+		t.Error("link page does not ask to confirm")
+	}
+	rec = do(http.MethodPost, link, nil)
+	cookies := rec.Result().Cookies()
+	if rec.Header().Get("Location") != "/" || len(cookies) == 0 {
+		t.Fatalf("POST link = %d %q, no session", rec.Code, rec.Header().Get("Location"))
+	}
+	if sessions, _ := store.GetSessions(ctx); len(sessions) != 1 || sessions[0].Subject != strconv.Itoa(alice.ID) {
+		t.Errorf("sessions = %+v, want one naming alice's ID", sessions)
+	}
+	if rec := do(http.MethodPost, link, nil); !strings.Contains(rec.Header().Get("Location"), "error=") {
+		t.Errorf("reused link = %q, want an error", rec.Header().Get("Location"))
+	}
+
+	if got := do(http.MethodGet, "/users/alice", cookies[0]).Code; got != http.StatusOK {
+		t.Errorf("own ledger = %d, want 200", got)
+	}
+	if got := do(http.MethodGet, "/admin", cookies[0]).Code; got != http.StatusForbidden {
+		t.Errorf("admin with an email login = %d, want 403", got)
+	}
+	if rec := do(http.MethodGet, "/logout", cookies[0]); rec.Header().Get("Location") != "/login" {
+		t.Errorf("logout = %q, want /login", rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"who-owes-me/auth"
+	"who-owes-me/db"
+)
+
+// serveForwardAuth runs the request as the login a trusted proxy vouched
+// for. It is matched to a user and given a role from ROLE_MAPPING the same
+// way as at the OIDC callback, on every request; there is no session.
+func (h *Handler) serveForwardAuth(w http.ResponseWriter, r *http.Request, next http.Handler, login *auth.ForwardLogin) {
+	role := auth.ResolveRole(auth.RoleMapping, login.Claims())
+
+	user, err := h.store.GetUserByIdentity(r.Context(), auth.ForwardAuthIssuer, login.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The proxy's email comes from its own user database, so it is
+		// treated like a verified email claim.
+		user, err = h.store.MatchLogin(r.Context(), db.Login{
+			Issuer:        auth.ForwardAuthIssuer,
+			Subject:       login.Username,
+			Username:      login.Username,
+			Email:         login.Email,
+			EmailVerified: login.Email != "",
+		})
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		fmt.Printf("Error looking up forward-auth user %s: %v\n", login.Username, err)
+		renderError(w, http.StatusInternalServerError, "Failed to look up your user.")
+		return
+	}
+	if err != nil && !role.Can(auth.PermViewLedgers) {
+		h.queueRegistration(r.Context(), db.Registration{
+			OIDCSub: login.Username, Name: login.Name, Email: login.Email, Issuer: auth.ForwardAuthIssuer, Subject: login.Username,
+		})
+		w.WriteHeader(http.StatusForbidden)
+		renderTemplate(w, r, "unregistered.html", nil)
+		return
+	}
+
+	// The login stands in for a session, e.g. for the identity of new API
+	// tokens, but is not stored. Each request counts as a login for the
+	// role its tokens may use.
+	sess := &db.Session{Username: login.Username, Issuer: auth.ForwardAuthIssuer, Subject: login.Username, Role: string(role)}
+	h.updateTokenRoles(r.Context(), sess)
+	ctx := context.WithValue(r.Context(), sessionCtxKey, sess)
+	if user != nil {
+		ctx = context.WithValue(ctx, userCtxKey, user)
+	}
+	ctx = context.WithValue(ctx, roleCtxKey, role)
+	ctx = context.WithValue(ctx, usernameCtxKey, login.Username)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"who-owes-me/auth"
+)
+
+func TestForwardAuth(t *testing.T) {
+	h, store := newTestServer(t)
+	ctx := context.Background()
+	store.CreateUser(ctx, "Alice", "alice", "regular", "p1")
+
+	trusted, _ := auth.ParseTrustedProxies("192.0.2.0/24")
+	auth.ForwardAuth = &auth.ForwardAuthConfig{Trusted: trusted, UserHeader: "Remote-User", GroupsHeader: "Remote-Groups", EmailHeader: "Remote-Email", NameHeader: "Remote-Name"}
+	rules, _ := auth.ParseRoleMapping("groups=whoowesme_admin:admin")
+	oldRules := auth.RoleMapping
+	auth.RoleMapping = rules
+	t.Cleanup(func() { auth.ForwardAuth, auth.RoleMapping = nil, oldRules })
+
+	get := func(path, remote, user, groups string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remote
+		req.Header.Set("Remote-User", user)
+		req.Header.Set("Remote-Groups", groups)
+		req.Header.Set("Remote-Name", "New Player")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	const proxy, elsewhere = "192.0.2.1:5000", "203.0.113.9:5000"
+	for _, tt := range []struct {
+		path, remote, user, groups string
+		want                       int
+	}{
+		{"/users/alice", proxy, "alice", "", http.StatusOK},
+		{"/admin", proxy, "alice", "", http.StatusForbidden},
+		{"/admin", proxy, "boss", "staff,whoowesme_admin", http.StatusOK},
+		{"/admin", elsewhere, "boss", "whoowesme_admin", http.StatusFound},
+		{"/", proxy, "newbie", "", http.StatusForbidden},
+	} {
+		if got := get(tt.path, tt.remote, tt.user, tt.groups).Code; got != tt.want {
+			t.Errorf("GET %s from %s as %s = %d, want %d", tt.path, tt.remote, tt.user, got, tt.want)
+		}
+	}
+
+	alice, _ := store.GetUserBySub(ctx, "alice")
+	if ids, _ := store.GetUserIdentities(ctx, alice.ID); len(ids) != 1 || ids[0].Issuer != auth.ForwardAuthIssuer {
+		t.Errorf("alice's identities = %+v, want the forward-auth login linked", ids)
+	}
+	if regs, _ := store.GetRegistrations(ctx); len(regs) != 1 || regs[0].OIDCSub != "newbie" || regs[0].Name != "New Player" {
+		t.Errorf("registrations = %+v, want newbie queued", regs)
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"who-owes-me/auth"
+	"who-owes-me/db"
+	"who-owes-me/internal/envutil"
+)
+
+const (
+	impersonateCookie = "impersonate"
+	// impersonationTTL is how long "View as" lasts before it ends by itself.
+	impersonationTTL = time.Hour
+)
+
+const impersonationCtxKey = contextKey("impersonation")
+
+// impersonation is an admin viewing the app as another user, kept in a
+// signed cookie.
+type impersonation struct {
+	UserID int
+	By     string // the admin's login
+	Since  time.Time
+	Writes bool     // whether the admin allowed changes
+	User   *db.User // loaded for each request
+}
+
+// impersonationOf returns the request's impersonation, or nil.
+func impersonationOf(r *http.Request) *impersonation {
+	imp, _ := r.Context().Value(impersonationCtxKey).(*impersonation)
+	return imp
+}
+
+func setImpersonationCookie(w http.ResponseWriter, imp *impersonation) {
+	value := fmt.Sprintf("%d|%s|%d|%t", imp.UserID, imp.By, imp.Since.Unix(), imp.Writes)
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonateCookie,
+		Value:    auth.Sign(value),
+		Path:     "/",
+		Expires:  imp.Since.Add(impersonationTTL),
+		HttpOnly: true,
+		Secure:   envutil.Getenv("APP_ENV") == "production",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// readImpersonation returns the impersonation in the request's cookie,
+// without its user.
+func readImpersonation(r *http.Request) (*impersonation, error) {
+	signed, err := auth.GetCookie(r, impersonateCookie)
+	if err != nil {
+		return nil, err
+	}
+	value, err := auth.Verify(signed)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(value, "|")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("malformed impersonation cookie")
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	since, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &impersonation{UserID: id, By: parts[1], Since: time.Unix(since, 0), Writes: parts[3] == "true"}, nil
+}
+
+// endImpersonation clears the cookie and logs the end under the admin who
+// started it.
+func (h *Handler) endImpersonation(w http.ResponseWriter, r *http.Request, imp *impersonation, why string) {
+	auth.ClearCookie(w, impersonateCookie)
+	who := fmt.Sprintf("#%d", imp.UserID)
+	if user, err := h.store.GetUserByID(r.Context(), imp.UserID); err == nil {
+		who = fmt.Sprintf("%s (#%d)", user.Name, user.ID)
+	}
+	details := fmt.Sprintf("stopped viewing as %s after %s: %s", who, time.Since(imp.Since).Round(time.Second), why)
+	if err := h.store.AddAuditEntry(r.Context(), imp.By, db.AuditEndImpersonation, details); err != nil {
+		fmt.Printf("Error writing audit entry: %v\n", err)
+	}
+}
+
+// Impersonation runs an admin's requests as the user they chose to view as,
+// with a viewer's role, so they see what that user sees. Changes are refused
+// unless the admin allowed them when starting. It must run after
+// AuthMiddleware, which establishes who the admin is.
+func (h *Handler) Impersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		imp, err := readImpersonation(r)
+		if err != nil || viaToken(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if imp.By != actor(r) {
+			h.endImpersonation(w, r, imp, "another login used the browser")
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !can(r, auth.PermManage) {
+			h.endImpersonation(w, r, imp, "no longer an admin")
+			next.ServeHTTP(w, r)
+			return
+		}
+		if time.Since(imp.Since) > impersonationTTL {
+			h.endImpersonation(w, r, imp, "expired")
+			next.ServeHTTP(w, r)
+			return
+		}
+		if imp.User, err = h.store.GetUserByID(r.Context(), imp.UserID); err != nil {
+			h.endImpersonation(w, r, imp, "user no longer exists")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), impersonationCtxKey, imp)
+		ctx = context.WithValue(ctx, userCtxKey, imp.User)
+		ctx = context.WithValue(ctx, roleCtxKey, auth.RoleViewer)
+		ctx = context.WithValue(ctx, usernameCtxKey, imp.User.OIDCSub)
+		r = r.WithContext(ctx)
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !imp.Writes && r.URL.Path != "/impersonate/end" {
+				w.WriteHeader(http.StatusForbidden)
+				renderWithFuncs(w, "error.html", struct {
+					Code    int
+					Message string
+				}{http.StatusForbidden, "You are viewing as " + imp.User.Name + ", so changes are blocked. End the view to make changes."}, pageFuncs(r))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleStartImpersonation lets an admin view the app as the user "sub",
+// starting from the dashboard redirect that user would get.
+func (h *Handler) handleStartImpersonation(w http.ResponseWriter, r *http.Request) {
+	user, err := h.store.GetUserBySub(r.Context(), r.FormValue("sub"))
+	if err != nil {
+		redirectAdmin(w, r, "error", "User not found")
+		return
+	}
+	imp := &impersonation{UserID: user.ID, By: actor(r), Since: time.Now(), Writes: r.FormValue("allow_writes") == "1"}
+	setImpersonationCookie(w, imp)
+
+	mode := "changes blocked"
+	if imp.Writes {
+		mode = "changes allowed"
+	}
+	details := fmt.Sprintf("started viewing as %s (#%d), %s", user.Name, user.ID, mode)
+	if err := h.store.AddAuditEntry(r.Context(), imp.By, db.AuditStartImpersonation, details); err != nil {
+		fmt.Printf("Error writing audit entry: %v\n", err)
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// handleEndImpersonation returns the admin to their own view, on the page of
+// the user they were viewing as.
+func (h *Handler) handleEndImpersonation(w http.ResponseWriter, r *http.Request) {
+	imp := impersonationOf(r)
+	if imp == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	h.endImpersonation(w, r, imp, "ended by the admin")
+	http.Redirect(w, r, "/users/"+url.PathEscape(imp.User.OIDCSub), http.StatusFound)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"who-owes-me/auth"
+	"who-owes-me/db"
+)
+
+func TestImpersonation(t *testing.T) {
+	h, store := newTestServer(t)
+	ctx := context.Background()
+	store.CreateUser(ctx, "Alice", "alice", "regular", "p1")
+
+	start := func(form url.Values) *http.Cookie {
+		t.Helper()
+		rec := postForm(h, "/admin/impersonate", form)
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+			t.Fatalf("start = %d %q, want a redirect to /", rec.Code, rec.Header().Get("Location"))
+		}
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == impersonateCookie {
+				return c
+			}
+		}
+		t.Fatal("start did not set the impersonation cookie")
+		return nil
+	}
+	do := func(method, path string, cookie *http.Cookie, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(csrfHeader, testCSRFToken)
+		addCSRF(req)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	cookie := start(url.Values{"sub": {"alice"}})
+	if rec := do(http.MethodGet, "/", cookie, nil); rec.Header().Get("Location") != "/users/alice" {
+		t.Errorf("GET / as alice = %d %q, want a redirect to /users/alice", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := do(http.MethodGet, "/users/alice", cookie, nil); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Viewing as") {
+		t.Errorf("GET /users/alice as alice = %d, want 200 with the banner", rec.Code)
+	}
+	if got := do(http.MethodGet, "/admin", cookie, nil).Code; got != http.StatusForbidden {
+		t.Errorf("GET /admin as alice = %d, want 403", got)
+	}
+	rec := do(http.MethodPost, "/users/alice/profile", cookie, url.Values{"email": {"a@example.com"}})
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "changes are blocked") {
+		t.Errorf("profile update as alice = %d, want 403 with changes blocked", rec.Code)
+	}
+
+	rec = do(http.MethodPost, "/impersonate/end", cookie, nil)
+	if rec.Header().Get("Location") != "/users/alice" {
+		t.Errorf("end = %d %q, want a redirect to /users/alice", rec.Code, rec.Header().Get("Location"))
+	}
+	cleared := false
+	for _, c := range rec.Result().Cookies() {
+		cleared = cleared || c.Name == impersonateCookie && c.MaxAge < 0
+	}
+	if !cleared {
+		t.Error("end did not clear the impersonation cookie")
+	}
+
+	entries, _ := store.GetAuditLog(ctx, 10)
+	var actions []string
+	for _, e := range entries {
+		if e.Actor != "dev_user" {
+			t.Errorf("audit entry %q by %q, want dev_user", e.Action, e.Actor)
+		}
+		actions = append(actions, e.Action)
+	}
+	if got := strings.Join(actions, ","); got != db.AuditEndImpersonation+","+db.AuditStartImpersonation {
+		t.Errorf("audit log = %s", got)
+	}
+
+	cookie = start(url.Values{"sub": {"alice"}, "allow_writes": {"1"}})
+	if got := do(http.MethodPost, "/users/alice/profile", cookie, url.Values{"email": {"a@example.com"}}).Code; got == http.StatusForbidden {
+		t.Error("profile update with changes allowed = 403")
+	}
+
+	alice, _ := store.GetUserBySub(ctx, "alice")
+	forged := &http.Cookie{Name: impersonateCookie, Value: fmt.Sprintf("%d|dev_user|%d|true", alice.ID, time.Now().Unix())}
+	if got := do(http.MethodGet, "/admin", forged, nil).Code; got != http.StatusOK {
+		t.Errorf("GET /admin with a forged cookie = %d, want 200", got)
+	}
+	if got := do(http.MethodGet, "/admin", &http.Cookie{Name: impersonateCookie, Value: auth.Sign("x")}, nil).Code; got != http.StatusOK {
+		t.Errorf("GET /admin with a malformed cookie = %d, want 200", got)
+	}
+}
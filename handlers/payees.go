@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"who-owes-me/actual"
+)
+
+// newPayeePrefix marks a payee picker value that asks for a new payee to be
+// created in Actual, e.g. "new:Jane Doe". An empty name falls back to the
+// user's name.
+const newPayeePrefix = "new:"
+
+func resolvePayeeID(value, userName string) (string, error) {
+	if !strings.HasPrefix(value, newPayeePrefix) {
+		return value, nil
+	}
+	name := strings.TrimSpace(strings.TrimPrefix(value, newPayeePrefix))
+	if name == "" {
+		name = strings.TrimSpace(userName)
+	}
+	return actual.NewClient().CreatePayee(name)
+}
+
+// handleSuggestPayees returns fuzzy payee matches for every "name" query
+// parameter, keyed by name.
+func (h *Handler) handleSuggestPayees(w http.ResponseWriter, r *http.Request) {
+	payees, err := actual.NewClient().GetPayees()
+	if err != nil {
+		http.Error(w, "Error fetching payees", http.StatusInternalServerError)
+		return
+	}
+
+	// Payees already linked to a user cannot be linked again.
+	owners, err := h.store.GetPayeeOwners(r.Context())
+	if err != nil {
+		http.Error(w, "Error loading users", http.StatusInternalServerError)
+		return
+	}
+	var free []actual.Payee
+	for _, p := range payees {
+		if _, taken := owners[p.ID]; !taken {
+			free = append(free, p)
+		}
+	}
+	payees = free
+
+	limit := 5
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+
+	suggestions := map[string][]actual.PayeeMatch{}
+	for _, name := range r.URL.Query()["name"] {
+		matches := actual.SuggestPayees(name, payees, limit)
+		if matches == nil {
+			matches = []actual.PayeeMatch{}
+		}
+		suggestions[name] = matches
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestions)
+}
+
+// handleLinkPayees links several users to payees at once. Form values come in
+// user_id / actual_payee_id pairs; pairs with an empty payee are skipped.
+func (h *Handler) handleLinkPayees(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	userIDs := r.Form["user_id"]
+	payeeIDs := r.Form["actual_payee_id"]
+
+	linked := 0
+	var failed []string
+	for i := range userIDs {
+		if i >= len(payeeIDs) || payeeIDs[i] == "" {
+			continue
+		}
+		id, err := strconv.Atoi(userIDs[i])
+		if err != nil {
+			continue
+		}
+		user, err := h.store.GetUserByID(r.Context(), id)
+		if err != nil {
+			continue
+		}
+
+		payeeID, err := resolvePayeeID(payeeIDs[i], user.Name)
+		if err == nil {
+			err = h.store.UpdateUser(r.Context(), user.ID, user.Name, user.OIDCSub, user.AidClass, payeeID)
+		}
+		if err != nil {
+			fmt.Printf("Error linking payee for %s: %v\n", user.Name, err)
+			failed = append(failed, user.Name)
+			continue
+		}
+		linked++
+	}
+
+	if len(failed) > 0 {
+		http.Redirect(w, r, "/admin?error="+url.QueryEscape("Could not link payees for "+strings.Join(failed, ", ")), http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/admin?message="+url.QueryEscape(fmt.Sprintf("Linked %d user(s) to payees", linked)), http.StatusFound)
+}
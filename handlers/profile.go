@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"who-owes-me/auth"
+	"who-owes-me/db"
+	"who-owes-me/internal/envutil"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// PaymentInfo tells the viewer of a ledger how to settle its balance. When the
+// player owes the team, Payee is whoever collects (PAYMENT_CONTACT); when the
+// team owes the player, Payee is the player.
+type PaymentInfo struct {
+	Amount     int // cents, always positive
+	PlayerOwes bool
+	Payee      *db.User
+	VenmoURL   string
+	PayPalURL  string
+}
+
+// paymentInfo builds the instructions for a non-zero balance, or returns nil
+// when there is nothing to pay or nowhere to send it.
+func (h *Handler) paymentInfo(ctx context.Context, user *db.User, balance int) *PaymentInfo {
+	if balance == 0 {
+		return nil
+	}
+	info := &PaymentInfo{Amount: balance, Payee: user}
+	if balance < 0 {
+		info.Amount = -balance
+		info.PlayerOwes = true
+		contact := envutil.Getenv("PAYMENT_CONTACT")
+		if contact == "" {
+			return nil
+		}
+		collector, err := h.store.GetUserBySub(ctx, contact)
+		if err != nil {
+			fmt.Printf("PAYMENT_CONTACT %q is not a user: %v\n", contact, err)
+			return nil
+		}
+		info.Payee = collector
+	}
+
+	amount := fmt.Sprintf("%.2f", float64(info.Amount)/100)
+	note := "Who Owes Me: " + user.Name
+	if info.Payee.Venmo != "" {
+		info.VenmoURL = "https://venmo.com/" + url.PathEscape(info.Payee.Venmo) +
+			"?txn=pay&amount=" + amount + "&note=" + url.QueryEscape(note)
+	}
+	if info.Payee.PayPal != "" {
+		info.PayPalURL = "https://paypal.me/" + url.PathEscape(info.Payee.PayPal) + "/" + amount + "USD"
+	}
+	return info
+}
+
+// handleUpdateProfile saves the contact details and payment handles on a
+// player's page. Users who can edit the roster may edit anyone's; players
+// only their own.
+func (h *Handler) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	sub := chi.URLParam(r, "sub")
+	self, _ := r.Context().Value(userCtxKey).(*db.User)
+	if !can(r, auth.PermEditUsers) && (self == nil || self.OIDCSub != sub) {
+		renderError(w, http.StatusForbidden, "You don't have permission to edit this profile.")
+		return
+	}
+
+	user, err := h.store.GetUserBySub(r.Context(), sub)
+	if err != nil {
+		renderError(w, http.StatusNotFound, "User not found.")
+		return
+	}
+
+	page := "/users/" + url.PathEscape(sub)
+	profile := db.Profile{
+		Email:  r.FormValue("email"),
+		Phone:  r.FormValue("phone"),
+		Venmo:  r.FormValue("venmo"),
+		PayPal: r.FormValue("paypal"),
+	}
+	if err := h.store.UpdateUserProfile(r.Context(), user.ID, profile); err != nil {
+		if !errors.Is(err, db.ErrInvalidProfile) {
+			fmt.Printf("Error updating profile for %s: %v\n", user.Name, err)
+			err = errors.New("could not save the profile")
+		}
+		http.Redirect(w, r, page+"?error="+url.QueryEscape(err.Error()), http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, page+"?message="+url.QueryEscape("Profile saved"), http.StatusFound)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"who-owes-me/db"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	h, store := newTestServer(t)
+	ctx := context.Background()
+	store.CreateUser(ctx, "Alice", "alice", "regular", "p1")
+
+	rec := postForm(h, "/users/alice/profile", url.Values{"email": {"alice@example.com"}, "venmo": {"@alice"}})
+	if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, "/users/alice?message=") {
+		t.Fatalf("profile update redirected to %q", loc)
+	}
+	alice, _ := store.GetUserBySub(ctx, "alice")
+	if alice.Email != "alice@example.com" || alice.Venmo != "alice" {
+		t.Errorf("alice = %+v", alice)
+	}
+
+	rec = postForm(h, "/users/alice/profile", url.Values{"email": {"not an email"}})
+	if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, "/users/alice?error=") {
+		t.Errorf("invalid profile redirected to %q", loc)
+	}
+
+	store.CreateUser(ctx, "Bob", "bob", "regular", "p2")
+	rec = postForm(h, "/users/bob/profile", url.Values{"email": {"Alice@example.com"}})
+	if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, "/users/bob?error=") || !strings.Contains(loc, "another") {
+		t.Errorf("alice's email on bob redirected to %q", loc)
+	}
+}
+
+func TestPaymentInfo(t *testing.T) {
+	t.Setenv("PAYMENT_CONTACT", "treasurer")
+	_, store := newTestServer(t)
+	h := New(store)
+	ctx := context.Background()
+	store.CreateUser(ctx, "Alice", "alice", "regular", "p1")
+	store.CreateUser(ctx, "Tess", "treasurer", "regular", "")
+	alice, _ := store.GetUserBySub(ctx, "alice")
+	tess, _ := store.GetUserBySub(ctx, "treasurer")
+	store.UpdateUserProfile(ctx, alice.ID, db.Profile{PayPal: "AliceS"})
+	store.UpdateUserProfile(ctx, tess.ID, db.Profile{Venmo: "team-tess"})
+	alice, _ = store.GetUserBySub(ctx, "alice")
+
+	if info := h.paymentInfo(ctx, alice, 0); info != nil {
+		t.Errorf("settled balance = %+v, want no instructions", info)
+	}
+
+	owes := h.paymentInfo(ctx, alice, -1250)
+	if owes == nil || !owes.PlayerOwes || owes.Amount != 1250 || owes.Payee.OIDCSub != "treasurer" {
+		t.Fatalf("owing balance = %+v", owes)
+	}
+	if !strings.HasPrefix(owes.VenmoURL, "https://venmo.com/team-tess?txn=pay&amount=12.50") || owes.PayPalURL != "" {
+		t.Errorf("owing links = %q, %q", owes.VenmoURL, owes.PayPalURL)
+	}
+
+	owed := h.paymentInfo(ctx, alice, 500)
+	if owed == nil || owed.PlayerOwes || owed.Payee.ID != alice.ID || owed.PayPalURL != "https://paypal.me/AliceS/5.00USD" {
+		t.Errorf("credit balance = %+v", owed)
+	}
+
+	t.Setenv("PAYMENT_CONTACT", "")
+	if info := h.paymentInfo(ctx, alice, -100); info != nil {
+		t.Errorf("owing without PAYMENT_CONTACT = %+v, want nil", info)
+	}
+}
+
+func TestUserPageShowsPaymentInstructions(t *testing.T) {
+	t.Setenv("PAYMENT_CONTACT", "dev_user")
+	h, store := newTestServer(t)
+	ctx := context.Background()
+	store.CreateUser(ctx, "Alice", "alice", "regular", "p1")
+	alice, _ := store.GetUserBySub(ctx, "alice")
+	store.SetSplit(ctx, "t1", alice.ID, 2000, "2026-01-01", "dues")
+
+	// Creates dev_user, who then gets a Venmo handle to collect with.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/alice", nil))
+	dev, _ := store.GetUserBySub(ctx, "dev_user")
+	store.UpdateUserProfile(ctx, dev.ID, db.Profile{Venmo: "dev-collects"})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/alice", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "How to settle up") || !strings.Contains(body, "venmo.com/dev-collects") {
+		t.Errorf("GET /users/alice = %d without payment instructions", rec.Code)
+	}
+}
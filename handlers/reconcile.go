@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"who-owes-me/actual"
+	"who-owes-me/db"
+)
+
+// ReconcileIssue is a transaction whose splits no longer line up with Actual.
+type ReconcileIssue struct {
+	TransactionID string
+	Date          string
+	Notes         string
+	Payee         string
+	TxAmount      int // absolute amount in Actual, 0 for orphans
+	SplitTotal    int
+	Splits        []ReconcileSplit
+}
+
+type ReconcileSplit struct {
+	db.ExpenseSplit
+	UserName string
+}
+
+func (i ReconcileIssue) Difference() int {
+	return i.TxAmount - i.SplitTotal
+}
+
+type ReconcileReport struct {
+	Orphaned   []ReconcileIssue // splits whose transaction is gone from Actual
+	Mismatched []ReconcileIssue // splits that no longer sum to the transaction
+	Unsplit    []ReconcileIssue // tagged transactions without any splits
+}
+
+func (r ReconcileReport) Total() int {
+	return len(r.Orphaned) + len(r.Mismatched) + len(r.Unsplit)
+}
+
+func buildReconcileReport(txns []actual.Transaction, splits []db.ExpenseSplit, users []db.User, payees []actual.Payee) ReconcileReport {
+	userNames := map[int]string{}
+	for _, u := range users {
+		userNames[u.ID] = u.Name
+	}
+	payeeNames := map[string]string{}
+	for _, p := range payees {
+		payeeNames[p.ID] = p.Name
+	}
+
+	byTx := map[string][]ReconcileSplit{}
+	for _, s := range splits {
+		name := userNames[s.UserID]
+		if name == "" {
+			name = fmt.Sprintf("User #%d", s.UserID)
+		}
+		byTx[s.ActualTransactionID] = append(byTx[s.ActualTransactionID], ReconcileSplit{ExpenseSplit: s, UserName: name})
+	}
+
+	var report ReconcileReport
+	seen := map[string]bool{}
+	for _, tx := range txns {
+		seen[tx.ID] = true
+		txSplits := byTx[tx.ID]
+		issue := ReconcileIssue{
+			TransactionID: tx.ID,
+			Date:          tx.Date,
+			Notes:         tx.Notes,
+			Payee:         payeeNames[tx.Payee],
+			TxAmount:      abs(tx.Amount),
+			Splits:        txSplits,
+		}
+		for _, s := range txSplits {
+			issue.SplitTotal += s.AmountOwed
+		}
+
+		switch {
+		case len(txSplits) == 0:
+			report.Unsplit = append(report.Unsplit, issue)
+		case issue.SplitTotal != issue.TxAmount:
+			report.Mismatched = append(report.Mismatched, issue)
+		}
+	}
+
+	for txID, txSplits := range byTx {
+		if seen[txID] {
+			continue
+		}
+		issue := ReconcileIssue{
+			TransactionID: txID,
+			Date:          txSplits[0].ExpenseDate,
+			Notes:         txSplits[0].ExpenseNote,
+			Splits:        txSplits,
+		}
+		for _, s := range txSplits {
+			issue.SplitTotal += s.AmountOwed
+		}
+		report.Orphaned = append(report.Orphaned, issue)
+	}
+
+	for _, list := range [][]ReconcileIssue{report.Orphaned, report.Mismatched, report.Unsplit} {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Date > list[j].Date
+		})
+	}
+	return report
+}
+
+// prorate scales amounts so they sum to total, keeping their proportions.
+// Leftover cents go to the entries with the largest fractional remainders.
+func prorate(amounts []int, total int) ([]int, error) {
+	sum := 0
+	for _, a := range amounts {
+		sum += a
+	}
+	if sum == 0 {
+		return nil, fmt.Errorf("existing splits total $0.00, nothing to prorate")
+	}
+
+	result := make([]int, len(amounts))
+	remainders := make([]int, len(amounts))
+	allocated := 0
+	for i, a := range amounts {
+		result[i] = a * total / sum
+		remainders[i] = a * total % sum
+		allocated += result[i]
+	}
+
+	order := make([]int, len(amounts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]] > remainders[order[j]]
+	})
+	for k := 0; allocated < total; k++ {
+		result[order[k%len(order)]]++
+		allocated++
+	}
+	return result, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func (h *Handler) handleReconcilePage(w http.ResponseWriter, r *http.Request) {
+	actClient := actual.NewClient()
+	apiErrors := []string{}
+
+	txns, err := actClient.GetTransactionsByTag(splitTag)
+	if err != nil {
+		apiErrors = append(apiErrors, fmt.Sprintf("Failed to fetch transactions: %v", err))
+	}
+	payees, err := actClient.GetPayees()
+	if err != nil {
+		apiErrors = append(apiErrors, fmt.Sprintf("Failed to fetch payees: %v", err))
+	}
+	for i := range txns {
+		txns[i].Notes = cleanNote(txns[i].Notes)
+	}
+
+	splits, _ := h.store.GetAllSplits(r.Context())
+	users, _ := h.store.GetAllUsers(r.Context())
+
+	var report ReconcileReport
+	// Without the transaction list every split would look orphaned.
+	if len(apiErrors) == 0 {
+		report = buildReconcileReport(txns, splits, users, payees)
+	}
+
+	renderTemplate(w, r, "reconcile.html", struct {
+		Report    ReconcileReport
+		APIErrors []string
+		Error     string
+		Message   string
+		SplitTag  string
+	}{
+		Report:    report,
+		APIErrors: apiErrors,
+		Error:     r.URL.Query().Get("error"),
+		Message:   r.URL.Query().Get("message"),
+		SplitTag:  splitTag,
+	})
+}
+
+func (h *Handler) handleReconcileProrate(w http.ResponseWriter, r *http.Request) {
+	txID := r.FormValue("actual_transaction_id")
+
+	txns, err := actual.NewClient().GetTransactionsByTag(splitTag)
+	if err != nil {
+		redirectReconcile(w, r, "error", "Failed to fetch transactions: "+err.Error())
+		return
+	}
+	var target *actual.Transaction
+	for i := range txns {
+		if txns[i].ID == txID {
+			target = &txns[i]
+			break
+		}
+	}
+	if target == nil {
+		redirectReconcile(w, r, "error", "Transaction not found in Actual; archive its splits instead.")
+		return
+	}
+
+	splits, err := h.store.GetSplitsForTx(r.Context(), txID)
+	if err != nil || len(splits) == 0 {
+		redirectReconcile(w, r, "error", "No splits to prorate.")
+		return
+	}
+
+	amounts := make([]int, len(splits))
+	for i, s := range splits {
+		amounts[i] = s.AmountOwed
+	}
+	prorated, err := prorate(amounts, abs(target.Amount))
+	if err != nil {
+		redirectReconcile(w, r, "error", err.Error())
+		return
+	}
+
+	updates := map[int]int{}
+	for i, s := range splits {
+		updates[s.ID] = prorated[i]
+	}
+	if err := h.store.SetSplitAmounts(r.Context(), updates); err != nil {
+		redirectReconcile(w, r, "error", "Failed to update splits: "+err.Error())
+		return
+	}
+	h.scheduleWriteback()
+	redirectReconcile(w, r, "message", "Re-prorated splits to "+formatCents(abs(target.Amount)))
+}
+
+func (h *Handler) handleReconcileArchive(w http.ResponseWriter, r *http.Request) {
+	txID := r.FormValue("actual_transaction_id")
+	if txID == "" {
+		redirectReconcile(w, r, "error", "Transaction ID is required")
+		return
+	}
+	if err := h.store.ArchiveSplitsForTx(r.Context(), txID); err != nil {
+		redirectReconcile(w, r, "error", "Failed to archive splits: "+err.Error())
+		return
+	}
+	h.scheduleWriteback()
+	redirectReconcile(w, r, "message", "Archived splits")
+}
+
+func redirectReconcile(w http.ResponseWriter, r *http.Request, param, msg string) {
+	http.Redirect(w, r, "/admin/reconcile?"+url.Values{param: {msg}}.Encode(), http.StatusFound)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+
+	"who-owes-me/actual"
+	"who-owes-me/db"
+)
+
+func TestProrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		amounts []int
+		total   int
+		want    []int
+	}{
+		{"already matches", []int{500, 500}, 1000, []int{500, 500}},
+		{"scales proportionally", []int{300, 100}, 200, []int{150, 50}},
+		{"even thirds give the first entry the extra cent", []int{1, 1, 1}, 100, []int{34, 33, 33}},
+		{"remainder goes to the largest fraction", []int{100, 200, 300}, 1001, []int{167, 334, 500}},
+		{"zero amounts stay zero", []int{0, 100}, 50, []int{0, 50}},
+		{"scales down to zero", []int{100, 100}, 0, []int{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := prorate(tt.amounts, tt.total)
+			if err != nil {
+				t.Fatalf("prorate(%v, %d) error: %v", tt.amounts, tt.total, err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("prorate(%v, %d) = %v, want %v", tt.amounts, tt.total, got, tt.want)
+			}
+			sum := 0
+			for _, a := range got {
+				sum += a
+			}
+			if sum != tt.total {
+				t.Errorf("prorate(%v, %d) sums to %d", tt.amounts, tt.total, sum)
+			}
+		})
+	}
+}
+
+func TestProrateZeroSum(t *testing.T) {
+	for _, amounts := range [][]int{{}, {0, 0}, {500, -500}} {
+		if got, err := prorate(amounts, 1000); err == nil {
+			t.Errorf("prorate(%v, 1000) = %v, want an error", amounts, got)
+		}
+	}
+}
+
+func TestBuildReconcileReport(t *testing.T) {
+	users := []db.User{{ID: 1, Name: "Alice"}, {ID: 2, Name: "Bob"}}
+	txns := []actual.Transaction{
+		{ID: "balanced", Date: "2026-01-01", Amount: -1000},
+		{ID: "short", Date: "2026-01-02", Amount: -1000},
+		{ID: "unsplit", Date: "2026-01-03", Amount: 500},
+	}
+	splits := []db.ExpenseSplit{
+		{ID: 1, ActualTransactionID: "balanced", UserID: 1, AmountOwed: 600},
+		{ID: 2, ActualTransactionID: "balanced", UserID: 2, AmountOwed: 400},
+		{ID: 3, ActualTransactionID: "short", UserID: 1, AmountOwed: 700},
+		{ID: 4, ActualTransactionID: "gone", UserID: 3, AmountOwed: 250, ExpenseDate: "2025-12-01"},
+	}
+
+	report := buildReconcileReport(txns, splits, users, nil)
+	if report.Total() != 3 {
+		t.Fatalf("report has %d issues, want 3: %+v", report.Total(), report)
+	}
+
+	if len(report.Mismatched) != 1 || report.Mismatched[0].TransactionID != "short" {
+		t.Fatalf("Mismatched = %+v, want only short", report.Mismatched)
+	}
+	if d := report.Mismatched[0].Difference(); d != 300 {
+		t.Errorf("short difference = %d, want 300", d)
+	}
+
+	if len(report.Unsplit) != 1 || report.Unsplit[0].TransactionID != "unsplit" {
+		t.Errorf("Unsplit = %+v, want only unsplit", report.Unsplit)
+	}
+
+	if len(report.Orphaned) != 1 {
+		t.Fatalf("Orphaned = %+v, want one entry", report.Orphaned)
+	}
+	orphan := report.Orphaned[0]
+	if orphan.TransactionID != "gone" || orphan.TxAmount != 0 || orphan.SplitTotal != 250 || orphan.Date != "2025-12-01" {
+		t.Errorf("orphan = %+v", orphan)
+	}
+	if orphan.Splits[0].UserName != "User #3" {
+		t.Errorf("orphan split user name = %q, want a placeholder for the unknown user", orphan.Splits[0].UserName)
+	}
+}
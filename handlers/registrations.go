@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"who-owes-me/db"
+)
+
+// queueRegistration records a login that has no user yet, so an admin can
+// approve it from the dashboard instead of copying the username by hand.
+func (h *Handler) queueRegistration(ctx context.Context, reg db.Registration) {
+	if err := h.store.AddRegistration(ctx, reg); err != nil {
+		fmt.Printf("Error queueing registration for %s: %v\n", reg.OIDCSub, err)
+	}
+}
+
+// handleApproveRegistration creates the user for the queued login "id" with
+// the chosen name, aid class and payee.
+func (h *Handler) handleApproveRegistration(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		redirectAdmin(w, r, "error", "Invalid registration ID")
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	payeeID := r.FormValue("actual_payee_id")
+
+	if payeeID != "" && !strings.HasPrefix(payeeID, newPayeePrefix) {
+		owners, err := h.store.GetPayeeOwners(r.Context())
+		if err != nil {
+			redirectAdmin(w, r, "error", "Could not load users")
+			return
+		}
+		if _, taken := owners[payeeID]; taken {
+			redirectAdmin(w, r, "error", "That payee is already linked to another user")
+			return
+		}
+	}
+	payeeID, err = resolvePayeeID(payeeID, name)
+	if err != nil {
+		fmt.Printf("Error creating payee for %s: %v\n", name, err)
+		redirectAdmin(w, r, "error", "Could not create the payee in Actual")
+		return
+	}
+
+	user, err := h.store.ApproveRegistration(r.Context(), id, name, r.FormValue("aid_class"), payeeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		redirectAdmin(w, r, "error", "That registration was already handled")
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error approving registration %d: %v\n", id, err)
+		redirectAdmin(w, r, "error", "Failed to approve the registration")
+		return
+	}
+	details := fmt.Sprintf("approved %s (%s, #%d)", user.Name, user.OIDCSub, user.ID)
+	if err := h.store.AddAuditEntry(r.Context(), actor(r), db.AuditApproveRegistration, details); err != nil {
+		fmt.Printf("Error writing audit entry: %v\n", err)
+	}
+	redirectAdmin(w, r, "message", "Added "+user.Name)
+}
+
+// handleDismissRegistration drops the queued login "id" without creating a
+// user.
+func (h *Handler) handleDismissRegistration(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		redirectAdmin(w, r, "error", "Invalid registration ID")
+		return
+	}
+	regs, err := h.store.GetRegistrations(r.Context())
+	if err != nil {
+		redirectAdmin(w, r, "error", "Could not load registrations")
+		return
+	}
+	var sub string
+	for _, reg := range regs {
+		if reg.ID == id {
+			sub = reg.OIDCSub
+		}
+	}
+	if sub == "" {
+		redirectAdmin(w, r, "error", "That registration was already handled")
+		return
+	}
+	if err := h.store.DeleteRegistration(r.Context(), id); err != nil {
+		fmt.Printf("Error dismissing registration %d: %v\n", id, err)
+		redirectAdmin(w, r, "error", "Failed to dismiss the registration")
+		return
+	}
+	details := fmt.Sprintf("dismissed registration of %s (#%d)", sub, id)
+	if err := h.store.AddAuditEntry(r.Context(), actor(r), db.AuditDismissRegistration, details); err != nil {
+		fmt.Printf("Error writing audit entry: %v\n", err)
+	}
+	redirectAdmin(w, r, "message", "Dismissed "+sub)
+}
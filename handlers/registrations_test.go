@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"who-owes-me/db"
+)
+
+func TestRegistrationQueue(t *testing.T) {
+	h, store := newTestServer(t)
+	ctx := context.Background()
+
+	handler := &Handler{store: store}
+	handler.queueRegistration(ctx, db.Registration{
+		OIDCSub: "jane", Name: "Jane Doe", Email: "jane@example.com", Issuer: "https://auth.example", Subject: "sub-j",
+	})
+	handler.queueRegistration(ctx, db.Registration{OIDCSub: "spam"})
+
+	regs, _ := store.GetRegistrations(ctx)
+	if len(regs) != 2 {
+		t.Fatalf("queue = %+v, want jane and spam", regs)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "Pending Registrations") || !strings.Contains(body, "jane@example.com") {
+		t.Errorf("dashboard does not show the queue")
+	}
+
+	rec = postForm(h, "/admin/registrations/approve", url.Values{
+		"id": {strconv.Itoa(regs[0].ID)}, "name": {"Jane"}, "aid_class": {"will_help"}, "actual_payee_id": {""},
+	})
+	if loc := rec.Header().Get("Location"); !strings.Contains(loc, "message=") {
+		t.Fatalf("approve redirected to %q", loc)
+	}
+	jane, err := store.GetUserBySub(ctx, "jane")
+	if err != nil || jane.Name != "Jane" || jane.AidClass != "will_help" || jane.Email != "jane@example.com" {
+		t.Errorf("approved user = %+v, %v", jane, err)
+	}
+	// The waiting session is recognised without logging in again.
+	sess := &db.Session{Username: "jane", Issuer: "https://auth.example", Subject: "sub-j"}
+	if u, err := handler.sessionUser(ctx, sess); err != nil || u.ID != jane.ID {
+		t.Errorf("sessionUser after approval = %+v, %v", u, err)
+	}
+
+	rec = postForm(h, "/admin/registrations/dismiss", url.Values{"id": {strconv.Itoa(regs[1].ID)}})
+	if loc := rec.Header().Get("Location"); !strings.Contains(loc, "message=") {
+		t.Fatalf("dismiss redirected to %q", loc)
+	}
+	if regs, _ := store.GetRegistrations(ctx); len(regs) != 0 {
+		t.Errorf("queue after approve and dismiss = %+v", regs)
+	}
+
+	entries, _ := store.GetAuditLog(ctx, 10)
+	if len(entries) != 2 || entries[0].Action != db.AuditDismissRegistration || entries[1].Action != db.AuditApproveRegistration {
+		t.Errorf("audit log = %+v", entries)
+	}
+}
@@ -2,10 +2,14 @@ package handlers
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"sync"
 
+	"who-owes-me/actual"
 	"who-owes-me/auth"
 	"who-owes-me/db"
 
@@ -13,57 +17,138 @@ import (
 	"golang.org/x/oauth2"
 )
 
-func RegisterRoutes(r chi.Router) {
-	r.Get("/health", handleHealth)
-	r.Get("/login", handleLogin)
-	r.Get("/callback", handleCallback)
-	r.Get("/logout", handleLogout)
+// Handler serves the app's routes backed by a Store.
+type Handler struct {
+	store     db.Store
+	syncer    *actual.Syncer
+	refreshMu sync.Mutex // serializes OIDC token refreshes
+	// sendLoginLink emails a sign-in link; tests replace it.
+	sendLoginLink func(to, token string) error
+}
+
+func New(store db.Store) *Handler {
+	return &Handler{
+		store:         store,
+		sendLoginLink: func(to, token string) error { return auth.Mail.SendLoginLink(to, token) },
+	}
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Use(CSRFProtect)
+	r.Get("/health", h.handleHealth)
+	r.Get("/login", h.handleLogin)
+	r.Get("/login/sso", h.handleSSOLogin)
+	r.Post("/login/email", h.handleSendLoginLink)
+	r.Get("/login/email/{token}", h.handleLoginLinkPage)
+	r.Post("/login/email/{token}", h.handleLoginLink)
+	r.Get("/callback", h.handleCallback)
+	r.Get("/logout", h.handleLogout)
+	r.Get("/share/{token}", h.handleSharedLedger)
 
 	// Protected routes
 	r.Group(func(r chi.Router) {
-		r.Use(AuthMiddleware)
-
-		r.Get("/", handleDashboard)
-		r.Get("/users/{sub}", handleUserDashboardBySub)
-
-		// Admin routes
-			r.Group(func(r chi.Router) {
-				r.Use(AdminMiddleware)
-				r.Get("/admin", handleAdminDashboard)
-				r.Post("/admin/users", handleCreateUser)
-				r.Post("/admin/users/update", handleUpdateUser)
-				r.Post("/admin/splits", handleCreateSplits)
-				r.Get("/admin/payees", handleGetPayees) // HTMX endpoint
-				r.Post("/admin/refresh", handleRefreshCache)
-			})
+		r.Use(h.AuthMiddleware)
+		r.Use(h.Impersonation)
+
+		r.Get("/", h.handleDashboard)
+		r.Post("/impersonate/end", h.handleEndImpersonation)
+		r.Get("/users/{sub}", h.handleUserDashboardBySub)
+		r.Post("/users/{sub}/profile", h.handleUpdateProfile)
+
+		r.Group(func(r chi.Router) {
+			r.Use(RequireSession)
+			r.Post("/users/{sub}/share", h.handleCreateShareLink)
+			r.Post("/users/{sub}/share/revoke", h.handleRevokeShareLink)
+			r.Get("/tokens", h.handleTokensPage)
+			r.Post("/tokens", h.handleCreateToken)
+			r.Post("/tokens/revoke", h.handleRevokeToken)
+		})
+
+		// Admin routes, each group behind the permission it needs
+		r.Group(func(r chi.Router) {
+			r.Use(RequirePermission(auth.PermViewLedgers))
+			r.Get("/admin", h.handleAdminDashboard)
+			r.Get("/admin/payees", h.handleGetPayees) // HTMX endpoint
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(RequirePermission(auth.PermEditUsers))
+			r.Post("/admin/users", h.handleCreateUser)
+			r.Post("/admin/users/update", h.handleUpdateUser)
+			r.Post("/admin/users/active", h.handleSetUserActive)
+			r.Get("/admin/payees/suggest", h.handleSuggestPayees)
+			r.Post("/admin/users/link", h.handleLinkPayees)
+			r.Post("/admin/registrations/approve", h.handleApproveRegistration)
+			r.Post("/admin/registrations/dismiss", h.handleDismissRegistration)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(RequirePermission(auth.PermEditSplits))
+			r.Post("/admin/splits", h.handleCreateSplits)
+			r.Post("/admin/refresh", h.handleRefreshCache)
+			r.Get("/admin/cache", h.handleCachePage)
+			r.Post("/admin/cache/refresh", h.handleCacheRefresh)
+			r.Post("/admin/cache/invalidate", h.handleCacheInvalidate)
+			r.Post("/admin/sync", h.handleSyncNow)
+			r.Post("/admin/notifications/clear", h.handleClearNotifications)
+			r.Get("/admin/reconcile", h.handleReconcilePage)
+			r.Post("/admin/reconcile/prorate", h.handleReconcileProrate)
+			r.Post("/admin/reconcile/archive", h.handleReconcileArchive)
+			r.Post("/admin/writeback", h.handleWriteback)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(RequirePermission(auth.PermManage))
+			r.Post("/admin/users/delete", h.handleDeleteUser)
+			r.Post("/admin/users/merge", h.handleMergeUsers)
+			r.Post("/admin/impersonate", h.handleStartImpersonation)
+			r.Get("/admin/audit", h.handleAuditPage)
+			r.Get("/admin/sessions", h.handleSessionsPage)
+			r.Post("/admin/sessions/revoke", h.handleRevokeSessions)
+			r.Post("/admin/backup", h.handleBackup)
+			r.Get("/admin/data", h.handleDataPage)
+			r.Get("/admin/export", h.handleExport)
+			r.Post("/admin/import", h.handleImport)
+		})
 	})
 }
 
-func handleLogin(w http.ResponseWriter, r *http.Request) {
-	if auth.Provider == nil {
+// handleLogin goes straight to the OIDC provider, or shows the login page
+// when players may also sign in by email.
+func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if !auth.Enabled() {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
+	if auth.Mail == nil && auth.Provider != nil {
+		http.Redirect(w, r, auth.StartLogin(w), http.StatusFound)
+		return
+	}
+	if auth.Mail == nil {
+		// Only the forward-auth proxy can sign anyone in.
+		renderError(w, http.StatusUnauthorized, "Sign in through the login page in front of this app.")
+		return
+	}
+	renderLoginPage(w, r, loginPageData{
+		Message: r.URL.Query().Get("message"),
+		Error:   r.URL.Query().Get("error"),
+	})
+}
 
-	b := make([]byte, 16)
-	rand.Read(b)
-	state := base64.URLEncoding.EncodeToString(b)
-
-	auth.SetCookie(w, "oauth_state", state)
-
-	url := auth.OAuth2Config.AuthCodeURL(state)
-	http.Redirect(w, r, url, http.StatusFound)
+func (h *Handler) handleSSOLogin(w http.ResponseWriter, r *http.Request) {
+	if auth.Provider == nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, auth.StartLogin(w), http.StatusFound)
 }
 
-func handleCallback(w http.ResponseWriter, r *http.Request) {
-	state, err := auth.GetCookie(r, "oauth_state")
-	if err != nil || r.URL.Query().Get("state") != state {
+func (h *Handler) handleCallback(w http.ResponseWriter, r *http.Request) {
+	login, err := auth.FinishLogin(w, r)
+	if err != nil {
 		http.Error(w, "State invalid", http.StatusBadRequest)
 		return
 	}
 
 	ctx := auth.GetClientContext(r.Context())
-	oauth2Token, err := auth.OAuth2Config.Exchange(ctx, r.URL.Query().Get("code"))
+	oauth2Token, err := auth.OAuth2Config.Exchange(ctx, r.URL.Query().Get("code"), oauth2.VerifierOption(login.Verifier))
 	if err != nil {
 		http.Error(w, "Failed to exchange token: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -80,6 +165,10 @@ func handleCallback(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to verify ID Token: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if err := login.CheckNonce(idToken); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Fetch UserInfo to get groups since Authelia 4.39 removes groups from the ID Token by default
 	userInfo, err := auth.Provider.UserInfo(ctx, oauth2.StaticTokenSource(oauth2Token))
@@ -94,38 +183,78 @@ func handleCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	isAdmin := false
-	for _, group := range claims.Groups {
-		if group == "whoowesme_admin" {
-			isAdmin = true
-			break
-		}
+	// Roles may come from any claim, in the ID token or from UserInfo.
+	allClaims := map[string]any{}
+	if err := idToken.Claims(&allClaims); err != nil {
+		http.Error(w, "Failed to parse ID token claims: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+	if err := userInfo.Claims(&allClaims); err != nil {
+		http.Error(w, "Failed to parse user info claims: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	role := auth.ResolveRole(auth.RoleMapping, allClaims)
 
 	username := claims.PreferredUsername
 	if username == "" {
 		username = idToken.Subject
 	}
 
-	auth.SetSessionState(w, isAdmin, username)
-	auth.SetCookie(w, "auth_token", rawIDToken)
+	// Users are found by the stable issuer and subject; the first login
+	// after an upgrade or approval links them by username or verified email.
+	_, err = h.store.MatchLogin(r.Context(), db.Login{
+		Issuer:        idToken.Issuer,
+		Subject:       idToken.Subject,
+		Username:      username,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	})
+	if errors.Is(err, sql.ErrNoRows) && !role.Can(auth.PermViewLedgers) {
+		h.queueRegistration(r.Context(), db.Registration{
+			OIDCSub: username, Name: claims.Name, Email: claims.Email, Issuer: idToken.Issuer, Subject: idToken.Subject,
+		})
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Failed to look up user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.startSession(w, r, &db.Session{
+		Username:      username,
+		Issuer:        idToken.Issuer,
+		Subject:       idToken.Subject,
+		Role:          string(role),
+		SessionTokens: sessionTokens(oauth2Token, db.SessionTokens{}),
+	}); err != nil {
+		http.Error(w, "Failed to start session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Sessions from before tokens were kept server-side carried the ID token
+	// in this cookie.
+	auth.ClearCookie(w, "auth_token")
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-func handleLogout(w http.ResponseWriter, r *http.Request) {
-	idToken, _ := auth.GetCookie(r, "auth_token")
-
+func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if imp, err := readImpersonation(r); err == nil {
+		h.endImpersonation(w, r, imp, "signed out")
+	}
+	sess := h.endSession(r)
 	auth.ClearCookie(w, "auth_token")
-	auth.ClearCookie(w, "app_session")
+	auth.ClearCookie(w, auth.SessionCookie)
 
-	if auth.Provider != nil {
+	if sess == nil && auth.ForwardAuth != nil && auth.ForwardAuth.LogoutURL != "" {
+		http.Redirect(w, r, auth.ForwardAuth.LogoutURL, http.StatusFound)
+		return
+	}
+	// Emailed-link logins never went through the provider.
+	if auth.Provider != nil && (sess == nil || sess.Issuer != auth.EmailIssuer) {
 		var providerClaims struct {
 			EndSessionEndpoint string `json:"end_session_endpoint"`
 		}
 		if err := auth.Provider.Claims(&providerClaims); err == nil && providerClaims.EndSessionEndpoint != "" {
 			redirectURL := providerClaims.EndSessionEndpoint
-			if idToken != "" {
-				redirectURL += "?id_token_hint=" + idToken
+			if sess != nil && sess.IDToken != "" {
+				redirectURL += "?id_token_hint=" + url.QueryEscape(sess.IDToken)
 			}
 			http.Redirect(w, r, redirectURL, http.StatusFound)
 			return
@@ -140,71 +269,111 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 type contextKey string
 
 const userCtxKey = contextKey("user")
-const isAdminCtxKey = contextKey("isAdmin")
+const roleCtxKey = contextKey("role")
+const usernameCtxKey = contextKey("username")
+const sessionCtxKey = contextKey("session")
+const scopesCtxKey = contextKey("scopes")
+
+// role is the requester's role; requests without one are viewers.
+func role(r *http.Request) auth.Role {
+	if role, ok := r.Context().Value(roleCtxKey).(auth.Role); ok {
+		return role
+	}
+	return auth.RoleViewer
+}
 
-func AuthMiddleware(next http.Handler) http.Handler {
+// can reports whether the requester's role grants p and, for API tokens,
+// whether one of the token's scopes carries it.
+func can(r *http.Request, p auth.Permission) bool {
+	if scopes, ok := r.Context().Value(scopesCtxKey).([]auth.Scope); ok && !auth.ScopesAllow(scopes, p) {
+		return false
+	}
+	return role(r).Can(p)
+}
+
+// actor is the login of whoever made the request, for the audit log. An
+// admin viewing as someone is named with them, e.g. "admin (as alice)".
+func actor(r *http.Request) string {
+	username, _ := r.Context().Value(usernameCtxKey).(string)
+	if imp := impersonationOf(r); imp != nil {
+		return imp.By + " (as " + username + ")"
+	}
+	return username
+}
+
+func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// In development mode or when OIDC is not configured, bypass authentication
-		if auth.Provider == nil {
-			user, err := db.GetUserBySub("dev_user")
+		if bearer := auth.BearerToken(r); bearer != "" {
+			h.serveAPIToken(w, r, next, bearer)
+			return
+		}
+
+		// In development mode or when no login is configured, bypass authentication
+		if !auth.Enabled() {
+			user, err := h.store.GetUserBySub(r.Context(), "dev_user")
 			if err != nil {
-				db.CreateUser("Dev User", "dev_user", "regular", "dev_payee")
-				user, _ = db.GetUserBySub("dev_user")
+				h.store.CreateUser(r.Context(), "Dev User", "dev_user", "regular", "dev_payee")
+				user, _ = h.store.GetUserBySub(r.Context(), "dev_user")
 			}
 
 			ctx := context.WithValue(r.Context(), userCtxKey, user)
-			ctx = context.WithValue(ctx, isAdminCtxKey, true)
+			ctx = context.WithValue(ctx, roleCtxKey, auth.RoleAdmin)
+			ctx = context.WithValue(ctx, usernameCtxKey, "dev_user")
 			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
 
-		tokenStr, err := auth.GetCookie(r, "auth_token")
-		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusFound)
-			return
+		if auth.ForwardAuth != nil {
+			if login, ok := auth.ForwardAuth.Login(r); ok {
+				h.serveForwardAuth(w, r, next, login)
+				return
+			}
 		}
 
-		authCtx := auth.GetClientContext(r.Context())
-		_, err = auth.Verifier.Verify(authCtx, tokenStr)
+		sess, err := h.currentSession(r)
 		if err != nil {
+			// Missing, expired or revoked session, force re-login
 			http.Redirect(w, r, "/login", http.StatusFound)
 			return
 		}
-
-		isAdmin, username, err := auth.GetSessionState(r)
-		if err != nil {
-			// Invalid or missing cookie (e.g. app restarted), force re-login
+		if sess, err = h.renewSession(r, sess); err != nil {
+			fmt.Printf("Session renewal failed: %v\n", err)
 			http.Redirect(w, r, "/login", http.StatusFound)
 			return
 		}
+		role, username := auth.Role(sess.Role), sess.Username
+		ctx := context.WithValue(r.Context(), sessionCtxKey, sess)
 
-		// Lookup user in DB using the preferred username (or sub if missing)
-		user, err := db.GetUserBySub(username)
+		user, err := h.sessionUser(r.Context(), sess)
 		if err != nil {
-			if isAdmin {
-				// Admins are allowed to proceed even if not in DB, to bootstrap
-				ctx := context.WithValue(r.Context(), isAdminCtxKey, true)
+			if role.Can(auth.PermViewLedgers) {
+				// Admins and other staff may proceed even if not in DB, to bootstrap
+				ctx = context.WithValue(ctx, roleCtxKey, role)
+				ctx = context.WithValue(ctx, usernameCtxKey, username)
 				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
 			w.WriteHeader(http.StatusForbidden)
-			renderTemplate(w, "unregistered.html", nil)
+			renderTemplate(w, r, "unregistered.html", nil)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userCtxKey, user)
-		ctx = context.WithValue(ctx, isAdminCtxKey, isAdmin)
+		ctx = context.WithValue(ctx, userCtxKey, user)
+		ctx = context.WithValue(ctx, roleCtxKey, role)
+		ctx = context.WithValue(ctx, usernameCtxKey, username)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func AdminMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		isAdmin, ok := r.Context().Value(isAdminCtxKey).(bool)
-		if !ok || !isAdmin {
-			renderError(w, http.StatusForbidden, "Admin access required.")
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+// RequirePermission lets through only requests whose role grants p.
+func RequirePermission(p auth.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !can(r, p) {
+				renderError(w, http.StatusForbidden, "You don't have permission to do that.")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"who-owes-me/auth"
+	"who-owes-me/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// newTestServer wires a Handler to a fresh in-memory store. OIDC is not
+// configured in tests, so requests run as the dev admin. Templates are loaded
+// relative to the repository root, so the test runs from there.
+func newTestServer(t *testing.T) (http.Handler, db.Store) {
+	t.Helper()
+	if _, err := os.Stat("templates"); err != nil {
+		t.Chdir("..")
+	}
+	store, err := db.NewMemoryStore()
+	if err != nil {
+		t.Fatalf("NewMemoryStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	r := chi.NewRouter()
+	New(store).RegisterRoutes(r)
+	return r, store
+}
+
+// testCSRFToken is the CSRF token test requests carry, as a browser would
+// from the page they were sent from.
+const testCSRFToken = "test-csrf-token"
+
+// addCSRF gives req the CSRF cookie; the token still has to be sent in the
+// csrf_token field or the X-CSRF-Token header.
+func addCSRF(req *http.Request) {
+	req.AddCookie(&http.Cookie{Name: auth.CSRFCookie, Value: auth.Sign(testCSRFToken)})
+}
+
+func postForm(h http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(csrfHeader, testCSRFToken)
+	addCSRF(req)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHealth(t *testing.T) {
+	h, _ := newTestServer(t)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"ok"`) {
+		t.Errorf("GET /health = %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCreateUsers(t *testing.T) {
+	h, store := newTestServer(t)
+	ctx := context.Background()
+
+	rec := postForm(h, "/admin/users", url.Values{
+		"name":            {"Alice", "", "Bob"},
+		"oidc_sub":        {"alice", "", "bob"},
+		"aid_class":       {"regular", "regular", "reduced"},
+		"actual_payee_id": {"p1", "", ""},
+	})
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/admin" {
+		t.Fatalf("POST /admin/users = %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	alice, err := store.GetUserBySub(ctx, "alice")
+	if err != nil || alice.ActualPayeeID != "p1" {
+		t.Errorf("alice = %+v, %v", alice, err)
+	}
+	bob, err := store.GetUserBySub(ctx, "bob")
+	if err != nil || bob.AidClass != "reduced" || bob.ActualPayeeID != "" {
+		t.Errorf("bob = %+v, %v", bob, err)
+	}
+}
+
+func TestCreateUserRejectsTakenLoginAndPayee(t *testing.T) {
+	h, store := newTestServer(t)
+	ctx := context.Background()
+	if err := store.CreateUser(ctx, "Alice", "alice", "regular", "p1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A taken login must be rejected before a "new:" payee is created in
+	// Actual; no Actual server is configured, so reaching it would also fail.
+	rec := postForm(h, "/admin/users", url.Values{
+		"name":            {"Alice Again", "Carol"},
+		"oidc_sub":        {"alice", "carol"},
+		"aid_class":       {"regular", "regular"},
+		"actual_payee_id": {"new:Alice Again", "p1"},
+	})
+	loc := rec.Header().Get("Location")
+	if rec.Code != http.StatusFound || !strings.HasPrefix(loc, "/admin?error=") {
+		t.Fatalf("POST /admin/users = %d, Location %q; want an error redirect", rec.Code, loc)
+	}
+	msg, _ := url.QueryUnescape(strings.TrimPrefix(loc, "/admin?error="))
+	if !strings.Contains(msg, "already in use") || !strings.Contains(msg, "already linked") {
+		t.Errorf("error message = %q", msg)
+	}
+
+	if _, err := store.GetUserBySub(ctx, "carol"); err == nil {
+		t.Error("carol was created with a payee that is already linked")
+	}
+	alice, _ := store.GetUserBySub(ctx, "alice")
+	if alice == nil || alice.Name != "Alice" {
+		t.Errorf("alice = %+v, want the original row untouched", alice)
+	}
+}
+
+func TestBackupDownload(t *testing.T) {
+	t.Setenv("BACKUP_DIR", "")
+	h, _ := newTestServer(t)
+
+	rec := postForm(h, "/admin/backup", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /admin/backup = %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "who-owes-me-") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	if !strings.HasPrefix(rec.Body.String(), "SQLite format 3") {
+		t.Error("download is not a SQLite database")
+	}
+}
+
+func TestExportAndImport(t *testing.T) {
+	h, store := newTestServer(t)
+	ctx := context.Background()
+	if err := store.CreateUser(ctx, "Alice", "alice", "regular", "p1"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/export", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("GET /admin/export = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	export := rec.Body.Bytes()
+
+	h2, other := newTestServer(t)
+
+	upload := func(dryRun bool) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", "export.zip")
+		fw.Write(export)
+		mw.WriteField("on_conflict", "skip")
+		mw.WriteField(csrfField, testCSRFToken)
+		if dryRun {
+			mw.WriteField("dry_run", "1")
+		}
+		mw.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/admin/import", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		addCSRF(req)
+		rec := httptest.NewRecorder()
+		h2.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := upload(true); !strings.Contains(rec.Body.String(), "Dry run") {
+		t.Errorf("dry run response missing summary: %d", rec.Code)
+	}
+	if _, err := other.GetUserBySub(ctx, "alice"); err == nil {
+		t.Error("dry run imported alice")
+	}
+	if rec := upload(false); !strings.Contains(rec.Body.String(), "Import complete") {
+		t.Errorf("import response missing summary: %d", rec.Code)
+	}
+	if _, err := other.GetUserBySub(ctx, "alice"); err != nil {
+		t.Errorf("alice was not imported: %v", err)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := RequirePermission(auth.PermManage)(ok)
+	for role, want := range map[auth.Role]int{
+		auth.RoleViewer:    http.StatusForbidden,
+		auth.RoleCaptain:   http.StatusForbidden,
+		auth.RoleTreasurer: http.StatusForbidden,
+		auth.RoleAdmin:     http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/users/delete", nil)
+		req = req.WithContext(context.WithValue(req.Context(), roleCtxKey, role))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: status %d, want %d", role, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"who-owes-me/auth"
+	"who-owes-me/db"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// touchInterval limits how often a session's last-seen time is written.
+	touchInterval = time.Minute
+	// refreshMargin is how long before the access token expires the
+	// session is renewed.
+	refreshMargin = time.Minute
+)
+
+// startSession stores sess, which names the login, its role and its OIDC
+// tokens, as a new server-side session and hands its token to the browser.
+// The OIDC tokens stay on the server.
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, sess *db.Session) error {
+	token := auth.NewToken()
+	expires := time.Now().Add(auth.SessionTTL())
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	sess.TokenHash, sess.UserAgent, sess.IP = auth.HashToken(token), r.UserAgent(), ip
+	stored := *sess
+	if stored.SessionTokens, err = sealTokens(sess.SessionTokens); err != nil {
+		return err
+	}
+	if err := h.store.CreateSession(r.Context(), &stored, expires); err != nil {
+		return err
+	}
+	sess.ID, sess.CreatedAt, sess.LastSeenAt, sess.ExpiresAt = stored.ID, stored.CreatedAt, stored.LastSeenAt, stored.ExpiresAt
+	auth.SetSessionCookie(w, token, expires)
+	h.updateTokenRoles(r.Context(), sess)
+	return nil
+}
+
+// updateTokenRoles caps the login's API tokens at the role it was just given.
+func (h *Handler) updateTokenRoles(ctx context.Context, sess *db.Session) {
+	if err := h.store.SetAPITokenOwnerRole(ctx, sess.Issuer, sess.Subject, sess.Username, sess.Role); err != nil {
+		fmt.Printf("Error updating API token roles of %s: %v\n", sess.Username, err)
+	}
+}
+
+// sealTokens encrypts the OIDC tokens for storage: a refresh token can mint
+// new tokens at the provider, so it must not be readable from the database,
+// its backups or copies.
+func sealTokens(t db.SessionTokens) (db.SessionTokens, error) {
+	var err error
+	if t.RefreshToken, err = auth.Seal(t.RefreshToken); err != nil {
+		return t, err
+	}
+	t.IDToken, err = auth.Seal(t.IDToken)
+	return t, err
+}
+
+// getSession loads a live session by token hash with its OIDC tokens
+// decrypted.
+func (h *Handler) getSession(ctx context.Context, tokenHash string) (*db.Session, error) {
+	sess, err := h.store.GetSession(ctx, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if sess.RefreshToken, err = auth.Unseal(sess.RefreshToken); err != nil {
+		return nil, fmt.Errorf("session %d: refresh token: %w", sess.ID, err)
+	}
+	if sess.IDToken, err = auth.Unseal(sess.IDToken); err != nil {
+		return nil, fmt.Errorf("session %d: ID token: %w", sess.ID, err)
+	}
+	return sess, nil
+}
+
+// sessionUser returns the user a session belongs to, or sql.ErrNoRows.
+// Sessions from an emailed link name the user ID; sessions from before
+// identities were linked only know the username.
+func (h *Handler) sessionUser(ctx context.Context, sess *db.Session) (*db.User, error) {
+	if sess.Issuer == auth.EmailIssuer {
+		id, err := strconv.Atoi(sess.Subject)
+		if err != nil {
+			return nil, sql.ErrNoRows
+		}
+		return h.store.GetUserByID(ctx, id)
+	}
+	if sess.Subject == "" {
+		return h.store.GetUserBySub(ctx, sess.Username)
+	}
+	return h.store.GetUserByIdentity(ctx, sess.Issuer, sess.Subject)
+}
+
+// currentSession returns the live session named by the request's cookie and
+// records that it was seen.
+func (h *Handler) currentSession(r *http.Request) (*db.Session, error) {
+	token, err := auth.SessionToken(r)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := h.getSession(r.Context(), auth.HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if seen, err := time.Parse(db.TimeLayout, sess.LastSeenAt); err != nil || time.Since(seen) > touchInterval {
+		if err := h.store.TouchSession(r.Context(), sess.ID); err != nil {
+			fmt.Printf("Error updating session %d: %v\n", sess.ID, err)
+		}
+	}
+	return sess, nil
+}
+
+// endSession deletes the request's session and returns it, or nil if there
+// was none. Its ID token goes to the provider's logout as id_token_hint.
+func (h *Handler) endSession(r *http.Request) *db.Session {
+	sess, err := h.currentSession(r)
+	if err != nil {
+		return nil
+	}
+	h.store.DeleteSession(r.Context(), sess.ID)
+	return sess
+}
+
+// sessionTokens merges a token response into the tokens already held. A
+// refresh response may leave out the refresh or ID token.
+func sessionTokens(tok *oauth2.Token, held db.SessionTokens) db.SessionTokens {
+	if tok.RefreshToken != "" {
+		held.RefreshToken = tok.RefreshToken
+	}
+	if raw, ok := tok.Extra("id_token").(string); ok && raw != "" {
+		held.IDToken = raw
+	}
+	held.TokenExpiresAt = ""
+	if !tok.Expiry.IsZero() {
+		held.TokenExpiresAt = tok.Expiry.UTC().Format(db.TimeLayout)
+	}
+	return held
+}
+
+// renewDue reports whether the session's access token is about to expire.
+// Sessions without a known expiry are never renewed.
+func renewDue(sess *db.Session) bool {
+	if sess.TokenExpiresAt == "" {
+		return false
+	}
+	expires, err := time.Parse(db.TimeLayout, sess.TokenExpiresAt)
+	return err == nil && time.Until(expires) < refreshMargin
+}
+
+// renewSession refreshes the session's tokens shortly before they expire and
+// extends the session. A refresh the provider rejects, e.g. because the user
+// was disabled, ends the session. Without a refresh token the session simply
+// runs until it expires.
+func (h *Handler) renewSession(r *http.Request, sess *db.Session) (*db.Session, error) {
+	if !renewDue(sess) || sess.RefreshToken == "" {
+		return sess, nil
+	}
+
+	// Providers that rotate refresh tokens accept each one only once, so
+	// concurrent requests must not refresh in parallel.
+	h.refreshMu.Lock()
+	defer h.refreshMu.Unlock()
+	ctx := r.Context()
+	sess, err := h.getSession(ctx, sess.TokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if !renewDue(sess) {
+		return sess, nil
+	}
+
+	clientCtx := auth.GetClientContext(ctx)
+	tok, err := auth.Refresh(clientCtx, sess.RefreshToken)
+	if err == nil {
+		if raw, ok := tok.Extra("id_token").(string); ok && raw != "" {
+			_, err = auth.Verifier.Verify(clientCtx, raw)
+		}
+	}
+	if err != nil {
+		h.store.DeleteSession(ctx, sess.ID)
+		return nil, fmt.Errorf("renewing session of %s: %w", sess.Username, err)
+	}
+
+	tokens := sessionTokens(tok, sess.SessionTokens)
+	sealed, err := sealTokens(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.store.RenewSession(ctx, sess.ID, sealed, time.Now().Add(auth.SessionTTL())); err != nil {
+		return nil, err
+	}
+	sess.SessionTokens = tokens
+	return sess, nil
+}
+
+func (h *Handler) handleSessionsPage(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.store.GetSessions(r.Context())
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, "Could not load sessions.")
+		return
+	}
+	renderTemplate(w, r, "sessions.html", struct {
+		Sessions []db.Session
+		Message  string
+		Error    string
+	}{
+		Sessions: sessions,
+		Message:  r.URL.Query().Get("message"),
+		Error:    r.URL.Query().Get("error"),
+	})
+}
+
+// handleRevokeSessions signs out one session ("id") or every session and API
+// token of a login ("username").
+func (h *Handler) handleRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	redirect := func(key, msg string) {
+		http.Redirect(w, r, "/admin/sessions?"+key+"="+url.QueryEscape(msg), http.StatusFound)
+	}
+
+	var details string
+	if username := r.FormValue("username"); username != "" {
+		n, err := h.store.DeleteUserSessions(r.Context(), username)
+		if err != nil {
+			fmt.Printf("Error revoking sessions of %s: %v\n", username, err)
+			redirect("error", "Could not revoke sessions of "+username)
+			return
+		}
+		tokens, err := h.store.DeleteUserAPITokens(r.Context(), username)
+		if err != nil {
+			fmt.Printf("Error revoking API tokens of %s: %v\n", username, err)
+			redirect("error", "Could not revoke API tokens of "+username)
+			return
+		}
+		details = fmt.Sprintf("revoked %d session(s) and %d API token(s) of %s", n, tokens, username)
+	} else {
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			redirect("error", "Invalid session")
+			return
+		}
+		if err := h.store.DeleteSession(r.Context(), id); err != nil {
+			redirect("error", "Session not found; it may have expired")
+			return
+		}
+		details = fmt.Sprintf("revoked session #%d", id)
+	}
+
+	if err := h.store.AddAuditEntry(r.Context(), actor(r), db.AuditRevokeSessions, details); err != nil {
+		fmt.Printf("Error writing audit entry: %v\n", err)
+	}
+	redirect("message", "Done: "+details)
+}
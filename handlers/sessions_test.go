@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"who-owes-me/auth"
+	"who-owes-me/db"
+)
+
+func TestSessionCookieRoundTrip(t *testing.T) {
+	_, store := newTestServer(t)
+	h := New(store)
+
+	login := httptest.NewRequest(http.MethodGet, "/callback", nil)
+	rec := httptest.NewRecorder()
+	if err := h.startSession(rec, login, &db.Session{
+		Username: "alice", Role: string(auth.RoleAdmin), SessionTokens: db.SessionTokens{IDToken: "id-token"},
+	}); err != nil {
+		t.Fatalf("startSession: %v", err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != auth.SessionCookie || cookies[0].Expires.IsZero() {
+		t.Fatalf("cookies = %+v, want one expiring session cookie", cookies)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookies[0])
+	sess, err := h.currentSession(req)
+	if err != nil || sess.Username != "alice" || sess.Role != "admin" {
+		t.Fatalf("currentSession = %+v, %v", sess, err)
+	}
+	if stored, _ := store.GetSession(context.Background(), sess.TokenHash); stored.IDToken == "" || stored.IDToken == "id-token" {
+		t.Errorf("stored ID token = %q, want it sealed", stored.IDToken)
+	}
+
+	// A tampered cookie or a revoked session is rejected.
+	forged := httptest.NewRequest(http.MethodGet, "/", nil)
+	forged.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: cookies[0].Value + "x"})
+	if _, err := h.currentSession(forged); err == nil {
+		t.Error("tampered cookie was accepted")
+	}
+	if ended := h.endSession(req); ended == nil || ended.IDToken != "id-token" {
+		t.Errorf("endSession = %+v, want the session with the ID token for the logout hint", ended)
+	}
+	if _, err := h.currentSession(req); err == nil {
+		t.Error("session still valid after logout")
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	h, store := newTestServer(t)
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+	first := &db.Session{TokenHash: "a1", Username: "alice"}
+	store.CreateSession(ctx, first, expires)
+	store.CreateSession(ctx, &db.Session{TokenHash: "a2", Username: "alice"}, expires)
+	store.CreateSession(ctx, &db.Session{TokenHash: "b1", Username: "bob"}, expires)
+	store.CreateAPIToken(ctx, &db.APIToken{TokenHash: "bt", Name: "bot", Username: "bob", Role: "viewer", Scopes: []string{"read:own"}}, expires)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/sessions", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "bob") {
+		t.Fatalf("GET /admin/sessions = %d", rec.Code)
+	}
+
+	rec = postForm(h, "/admin/sessions/revoke", url.Values{"id": {strconv.Itoa(first.ID)}})
+	if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, "/admin/sessions?message=") {
+		t.Errorf("revoke one redirected to %q", loc)
+	}
+	postForm(h, "/admin/sessions/revoke", url.Values{"username": {"bob"}})
+
+	sessions, _ := store.GetSessions(ctx)
+	if len(sessions) != 1 || sessions[0].TokenHash != "a2" {
+		t.Errorf("sessions left = %+v, want alice's second one", sessions)
+	}
+	if tokens, _ := store.GetAPITokens(ctx, "bob"); len(tokens) != 0 {
+		t.Errorf("bob's tokens left = %+v", tokens)
+	}
+	if entries, _ := store.GetAuditLog(ctx, 10); len(entries) != 2 || entries[0].Action != db.AuditRevokeSessions {
+		t.Errorf("audit log = %+v", entries)
+	}
+}
+
+func TestRenewSession(t *testing.T) {
+	_, store := newTestServer(t)
+	h := New(store)
+	ctx := context.Background()
+
+	refreshes := 0
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != "rt-1" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		refreshes++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at-2", "token_type": "Bearer", "expires_in": 3600, "refresh_token": "rt-2",
+		})
+	}))
+	defer idp.Close()
+	saved := auth.OAuth2Config
+	auth.OAuth2Config.Endpoint.TokenURL = idp.URL
+	t.Cleanup(func() { auth.OAuth2Config = saved })
+
+	soon := time.Now().Add(10 * time.Second).UTC().Format(db.TimeLayout)
+	sealed, _ := sealTokens(db.SessionTokens{RefreshToken: "rt-1", IDToken: "id-1", TokenExpiresAt: soon})
+	sess := &db.Session{TokenHash: "h", Username: "alice", SessionTokens: sealed}
+	store.CreateSession(ctx, sess, time.Now().Add(time.Hour))
+	sess.SessionTokens = db.SessionTokens{RefreshToken: "rt-1", IDToken: "id-1", TokenExpiresAt: soon}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	renewed, err := h.renewSession(req, sess)
+	if err != nil {
+		t.Fatalf("renewSession: %v", err)
+	}
+	if renewed.RefreshToken != "rt-2" || renewed.IDToken != "id-1" || renewDue(renewed) {
+		t.Errorf("renewed tokens = %+v", renewed.SessionTokens)
+	}
+	// A stale copy does not refresh again with the rotated-out token.
+	if _, err := h.renewSession(req, sess); err != nil || refreshes != 1 {
+		t.Errorf("second renewSession = %v after %d refreshes, want 1", err, refreshes)
+	}
+	stored, _ := store.GetSession(ctx, "h")
+	if rt, _ := auth.Unseal(stored.RefreshToken); rt != "rt-2" || stored.RefreshToken == "rt-2" || stored.ExpiresAt <= sess.ExpiresAt {
+		t.Errorf("stored session = %+v, want new sealed tokens and a later expiry", stored)
+	}
+
+	// A rejected refresh ends the session.
+	sealed, _ = sealTokens(db.SessionTokens{RefreshToken: "revoked", TokenExpiresAt: soon})
+	store.RenewSession(ctx, stored.ID, sealed, time.Now().Add(time.Hour))
+	stored, _ = h.getSession(ctx, "h")
+	if _, err := h.renewSession(req, stored); err == nil {
+		t.Fatal("renewSession with a revoked refresh token succeeded")
+	}
+	if _, err := store.GetSession(ctx, "h"); err == nil {
+		t.Error("session survived a rejected refresh")
+	}
+}
+
+func TestSessionUser(t *testing.T) {
+	_, store := newTestServer(t)
+	h := New(store)
+	ctx := context.Background()
+
+	store.CreateUser(ctx, "Alice", "alice", "regular", "")
+	alice, err := store.MatchLogin(ctx, db.Login{Issuer: "https://auth.example", Subject: "sub-a", Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		sess *db.Session
+		want int
+	}{
+		{&db.Session{Username: "alice.renamed", Issuer: "https://auth.example", Subject: "sub-a"}, alice.ID},
+		{&db.Session{Username: "alice", Issuer: "https://auth.example", Subject: "sub-x"}, 0},
+		{&db.Session{Username: "alice"}, alice.ID}, // from before identities
+	} {
+		got := 0
+		if u, err := h.sessionUser(ctx, tt.sess); err == nil {
+			got = u.ID
+		}
+		if got != tt.want {
+			t.Errorf("sessionUser(%+v) = #%d, want #%d", tt.sess, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"who-owes-me/auth"
+	"who-owes-me/db"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// shareLifetimes are the expiries offered for share links, in days.
+var shareLifetimes = []int{1, 7, 30, 90}
+
+// canShare reports whether the requester may make and revoke share links to
+// user's ledger: whoever can edit the roster, or the player themselves, from
+// a browser.
+func canShare(r *http.Request, user *db.User) bool {
+	if viaToken(r) {
+		return false
+	}
+	self, _ := r.Context().Value(userCtxKey).(*db.User)
+	return can(r, auth.PermEditUsers) || (self != nil && self.ID == user.ID)
+}
+
+// shareTarget loads the user in the URL and checks the requester may share
+// their ledger.
+func (h *Handler) shareTarget(w http.ResponseWriter, r *http.Request) (*db.User, bool) {
+	user, err := h.store.GetUserBySub(r.Context(), chi.URLParam(r, "sub"))
+	if err != nil {
+		renderError(w, http.StatusNotFound, "User not found.")
+		return nil, false
+	}
+	if !canShare(r, user) {
+		renderError(w, http.StatusForbidden, "You don't have permission to share this ledger.")
+		return nil, false
+	}
+	return user, true
+}
+
+// handleCreateShareLink makes a link to the user's ledger and shows it once
+// on their page.
+func (h *Handler) handleCreateShareLink(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.shareTarget(w, r)
+	if !ok {
+		return
+	}
+	page := "/users/" + url.PathEscape(user.OIDCSub)
+
+	days, err := strconv.Atoi(r.FormValue("expires_days"))
+	if err != nil || !slices.Contains(shareLifetimes, days) {
+		http.Redirect(w, r, page+"?error="+url.QueryEscape("Choose when the link expires"), http.StatusFound)
+		return
+	}
+	label := strings.TrimSpace(r.FormValue("label"))
+	if len(label) > 100 {
+		label = label[:100]
+	}
+
+	token := auth.NewToken()
+	link := &db.ShareLink{TokenHash: auth.HashToken(token), UserID: user.ID, Label: label, CreatedBy: actor(r)}
+	if err := h.store.CreateShareLink(r.Context(), link, time.Now().AddDate(0, 0, days)); err != nil {
+		fmt.Printf("Error creating share link for %s: %v\n", user.Name, err)
+		http.Redirect(w, r, page+"?error="+url.QueryEscape("Could not create the link"), http.StatusFound)
+		return
+	}
+	details := fmt.Sprintf("shared the ledger of %s (#%d) as link #%d %q, expiring %s", user.Name, user.ID, link.ID, label, link.ExpiresAt)
+	if err := h.store.AddAuditEntry(r.Context(), actor(r), db.AuditCreateShareLink, details); err != nil {
+		fmt.Printf("Error writing audit entry: %v\n", err)
+	}
+	shareURL := "/share/" + auth.Sign(token)
+	http.Redirect(w, r, page+"?share_url="+url.QueryEscape(shareURL), http.StatusFound)
+}
+
+// handleRevokeShareLink stops one of the user's share links from working.
+func (h *Handler) handleRevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.shareTarget(w, r)
+	if !ok {
+		return
+	}
+	redirect := func(key, msg string) {
+		http.Redirect(w, r, "/users/"+url.PathEscape(user.OIDCSub)+"?"+key+"="+url.QueryEscape(msg), http.StatusFound)
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		redirect("error", "Invalid link ID")
+		return
+	}
+	links, err := h.store.GetShareLinks(r.Context(), user.ID)
+	if err != nil {
+		redirect("error", "Could not load share links")
+		return
+	}
+	if !slices.ContainsFunc(links, func(l db.ShareLink) bool { return l.ID == id }) {
+		redirect("error", "Link not found")
+		return
+	}
+	if err := h.store.RevokeShareLink(r.Context(), id); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		fmt.Printf("Error revoking share link %d: %v\n", id, err)
+		redirect("error", "Failed to revoke the link")
+		return
+	}
+	details := fmt.Sprintf("revoked share link #%d to the ledger of %s (#%d)", id, user.Name, user.ID)
+	if err := h.store.AddAuditEntry(r.Context(), actor(r), db.AuditRevokeShareLink, details); err != nil {
+		fmt.Printf("Error writing audit entry: %v\n", err)
+	}
+	redirect("message", "Link revoked")
+}
+
+// handleSharedLedger shows a ledger to anyone holding a live share link, and
+// logs the view.
+func (h *Handler) handleSharedLedger(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	const gone = "This link is invalid, has expired or was revoked."
+	token, err := auth.Verify(chi.URLParam(r, "token"))
+	if err != nil {
+		renderError(w, http.StatusNotFound, gone)
+		return
+	}
+	link, err := h.store.GetShareLink(r.Context(), auth.HashToken(token))
+	if err != nil {
+		renderError(w, http.StatusNotFound, gone)
+		return
+	}
+	user, err := h.store.GetUserByID(r.Context(), link.UserID)
+	if err != nil {
+		renderError(w, http.StatusNotFound, gone)
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if err := h.store.RecordShareLinkView(r.Context(), link.ID, ip, r.UserAgent()); err != nil {
+		fmt.Printf("Error logging view of share link %d: %v\n", link.ID, err)
+	}
+
+	data, _ := h.getUserDashboardData(r.Context(), user)
+	data.Shared = true
+	renderWithFuncs(w, "user.html", data, template.FuncMap{"signedIn": func() bool { return false }})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestShareLinks(t *testing.T) {
+	h, store := newTestServer(t)
+	ctx := context.Background()
+	store.CreateUser(ctx, "Alice", "alice", "regular", "p1")
+
+	rec := postForm(h, "/users/alice/share", url.Values{"label": {"Mom"}, "expires_days": {"7"}})
+	loc, _ := url.Parse(rec.Header().Get("Location"))
+	shareURL := loc.Query().Get("share_url")
+	if rec.Code != http.StatusFound || !strings.HasPrefix(shareURL, "/share/") {
+		t.Fatalf("POST /users/alice/share = %d, Location %q", rec.Code, loc)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	rec = get(shareURL)
+	if body := rec.Body.String(); rec.Code != http.StatusOK || !strings.Contains(body, "read-only view of Alice") ||
+		strings.Contains(body, "/logout") || strings.Contains(body, "Save profile") {
+		t.Fatalf("GET share link = %d", rec.Code)
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Cache-Control = %q", rec.Header().Get("Cache-Control"))
+	}
+	if got := get(shareURL[:len(shareURL)-1] + "x").Code; got != http.StatusNotFound {
+		t.Errorf("tampered link = %d, want 404", got)
+	}
+
+	user, _ := store.GetUserBySub(ctx, "alice")
+	links, _ := store.GetShareLinks(ctx, user.ID)
+	if len(links) != 1 || links[0].Label != "Mom" || len(links[0].Views) != 1 {
+		t.Fatalf("links = %+v", links)
+	}
+	if !strings.Contains(get("/users/alice").Body.String(), "1 · last") {
+		t.Error("dashboard does not list the view")
+	}
+
+	postForm(h, "/users/alice/share/revoke", url.Values{"id": {strconv.Itoa(links[0].ID)}})
+	if got := get(shareURL).Code; got != http.StatusNotFound {
+		t.Errorf("revoked link = %d, want 404", got)
+	}
+
+	if rec := postForm(h, "/users/alice/share", url.Values{"expires_days": {"365"}}); !strings.Contains(rec.Header().Get("Location"), "error=") {
+		t.Errorf("unoffered expiry not rejected: %q", rec.Header().Get("Location"))
+	}
+}
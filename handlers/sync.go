@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"who-owes-me/actual"
+)
+
+const maxNotifications = 50
+
+var (
+	notificationsMu sync.Mutex
+	notifications   []actual.ChangeEvent
+)
+
+// StartSync runs the background Actual sync for the split tag and wires its
+// change events into auto-splitting and the admin notification feed.
+func (h *Handler) StartSync(ctx context.Context, interval time.Duration) {
+	h.syncer = actual.NewSyncer(actual.NewClient(), splitTag, interval)
+	h.syncer.OnChange(h.handleSyncEvent)
+	go h.syncer.Run(ctx)
+}
+
+func (h *Handler) handleSyncEvent(ev actual.ChangeEvent) {
+	ev.Transaction.Notes = cleanNote(ev.Transaction.Notes)
+	if ev.Previous != nil {
+		prev := *ev.Previous
+		prev.Notes = cleanNote(prev.Notes)
+		ev.Previous = &prev
+	}
+
+	// Our own note write-back shows up as an update; it is not news.
+	if ev.Kind == actual.ChangeUpdated && *ev.Previous == ev.Transaction {
+		return
+	}
+
+	fmt.Printf("Actual sync: transaction %s %s\n", ev.Transaction.ID, ev.Kind)
+	addNotification(ev)
+
+	if ev.Kind == actual.ChangeCreated {
+		if _, err := h.autoSplitTransactions(context.Background(), []actual.Transaction{ev.Transaction}); err != nil {
+			fmt.Printf("Error auto-splitting transaction %s: %v\n", ev.Transaction.ID, err)
+		}
+	}
+
+	// Any change to a tagged transaction can move balances.
+	h.scheduleWriteback()
+}
+
+func addNotification(ev actual.ChangeEvent) {
+	notificationsMu.Lock()
+	defer notificationsMu.Unlock()
+	notifications = append([]actual.ChangeEvent{ev}, notifications...)
+	if len(notifications) > maxNotifications {
+		notifications = notifications[:maxNotifications]
+	}
+}
+
+func getNotifications() []actual.ChangeEvent {
+	notificationsMu.Lock()
+	defer notificationsMu.Unlock()
+	return append([]actual.ChangeEvent{}, notifications...)
+}
+
+// autoSplitTransactions credits positive transactions in full to the user
+// owning their payee, as long as the transaction has no splits yet. It
+// returns how many splits were created.
+func (h *Handler) autoSplitTransactions(ctx context.Context, txns []actual.Transaction) (int, error) {
+	users, err := h.store.GetAllUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	owners, err := h.store.GetPayeeOwners(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// Deposits from players who have left stay unsplit for an admin to
+	// look at.
+	active := map[int]bool{}
+	for _, u := range users {
+		active[u.ID] = u.Active
+	}
+
+	splitTxSet, err := h.store.GetSplitTransactionIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, tx := range txns {
+		if splitTxSet[tx.ID] || tx.Amount <= 0 {
+			continue
+		}
+		if userID, ok := owners[tx.Payee]; ok && active[userID] {
+			if err := h.store.SetAutoSplit(ctx, tx.ID, userID, tx.Amount, tx.Date, tx.Notes); err != nil {
+				return created, err
+			}
+			splitTxSet[tx.ID] = true
+			created++
+		}
+	}
+	return created, nil
+}
+
+func (h *Handler) handleSyncNow(w http.ResponseWriter, r *http.Request) {
+	syncer := h.syncer
+	if syncer == nil {
+		http.Redirect(w, r, "/admin?error=Background sync is not enabled", http.StatusFound)
+		return
+	}
+	if _, err := syncer.SyncOnce(); err != nil {
+		http.Redirect(w, r, "/admin?error=Sync failed", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
+
+func (h *Handler) handleClearNotifications(w http.ResponseWriter, r *http.Request) {
+	notificationsMu.Lock()
+	notifications = nil
+	notificationsMu.Unlock()
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"who-owes-me/auth"
+	"who-owes-me/db"
+)
+
+// tokenLifetimes are the expiries offered for new API tokens, in days.
+var tokenLifetimes = []int{7, 30, 90, 365}
+
+// serveAPIToken authenticates a request by its Bearer token. The request
+// runs as the token's owner, with their role at their latest login but never
+// more than they had when they made it, limited to the token's scopes; only
+// write:splits tokens may change anything.
+func (h *Handler) serveAPIToken(w http.ResponseWriter, r *http.Request, next http.Handler, bearer string) {
+	token, err := h.store.GetAPIToken(r.Context(), auth.HashToken(bearer))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Invalid, expired or revoked API token", http.StatusUnauthorized)
+		return
+	}
+	scopes, err := auth.ParseScopes(token.Scopes)
+	if err != nil {
+		http.Error(w, "Invalid API token: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !slices.Contains(scopes, auth.ScopeWriteSplits) {
+		http.Error(w, "This API token is read-only", http.StatusForbidden)
+		return
+	}
+	if last, err := time.Parse(db.TimeLayout, token.LastUsedAt); err != nil || time.Since(last) > time.Minute {
+		if err := h.store.TouchAPIToken(r.Context(), token.ID); err != nil {
+			fmt.Printf("Error touching API token %d: %v\n", token.ID, err)
+		}
+	}
+
+	role := auth.Role(token.Role).Min(auth.Role(token.OwnerRole))
+	ctx := context.WithValue(r.Context(), roleCtxKey, role)
+	ctx = context.WithValue(ctx, scopesCtxKey, scopes)
+	ctx = context.WithValue(ctx, usernameCtxKey, token.Username+" (token "+token.Name+")")
+	user, err := h.sessionUser(r.Context(), &db.Session{Username: token.Username, Issuer: token.Issuer, Subject: token.Subject})
+	if err == nil {
+		ctx = context.WithValue(ctx, userCtxKey, user)
+	} else if !role.Can(auth.PermViewLedgers) {
+		http.Error(w, "The owner of this API token is not registered", http.StatusForbidden)
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// viaToken reports whether the request was authenticated by an API token.
+func viaToken(r *http.Request) bool {
+	_, ok := r.Context().Value(scopesCtxKey).([]auth.Scope)
+	return ok
+}
+
+// RequireSession keeps API tokens away from routes only a signed-in browser
+// should reach, such as minting more tokens, and admins viewing as someone
+// else from acting with that user's credentials.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if viaToken(r) {
+			http.Error(w, "API tokens cannot be used here", http.StatusForbidden)
+			return
+		}
+		if impersonationOf(r) != nil {
+			renderError(w, http.StatusForbidden, "This is not available while viewing as someone else.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// offeredScopes are the scopes the requester's role can hand to a token.
+func offeredScopes(r *http.Request) []auth.Scope {
+	var scopes []auth.Scope
+	for _, s := range auth.Scopes {
+		if p := s.Permission(); p == "" || role(r).Can(p) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// visibleTokens are the requester's own tokens, or everyone's for admins.
+func (h *Handler) visibleTokens(r *http.Request) ([]db.APIToken, error) {
+	if can(r, auth.PermManage) {
+		return h.store.GetAPITokens(r.Context(), "")
+	}
+	return h.store.GetAPITokens(r.Context(), actor(r))
+}
+
+type tokensPageData struct {
+	Tokens    []db.APIToken
+	Scopes    []auth.Scope
+	Lifetimes []int
+	AllUsers  bool   // listing everyone's tokens
+	NewToken  string // shown once, right after it is created
+	Error     string
+	Message   string
+}
+
+func (h *Handler) renderTokensPage(w http.ResponseWriter, r *http.Request, data tokensPageData) {
+	tokens, err := h.visibleTokens(r)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, "Could not load API tokens.")
+		return
+	}
+	data.Tokens, data.Scopes, data.Lifetimes = tokens, offeredScopes(r), tokenLifetimes
+	data.AllUsers = can(r, auth.PermManage)
+	renderTemplate(w, r, "tokens.html", data)
+}
+
+func (h *Handler) handleTokensPage(w http.ResponseWriter, r *http.Request) {
+	h.renderTokensPage(w, r, tokensPageData{
+		Error:   r.URL.Query().Get("error"),
+		Message: r.URL.Query().Get("message"),
+	})
+}
+
+// handleCreateToken makes a token for the signed-in login and shows it once.
+func (h *Handler) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fail := func(msg string) {
+		w.WriteHeader(http.StatusBadRequest)
+		h.renderTokensPage(w, r, tokensPageData{Error: msg})
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len(name) > 100 {
+		fail("Give the token a name of up to 100 characters")
+		return
+	}
+	scopes, err := auth.ParseScopes(r.Form["scope"])
+	if err != nil {
+		fail("Invalid scopes: " + err.Error())
+		return
+	}
+	for _, s := range scopes {
+		if !slices.Contains(offeredScopes(r), s) {
+			fail("Your role cannot grant " + string(s))
+			return
+		}
+	}
+	days, err := strconv.Atoi(r.FormValue("expires_days"))
+	if err != nil || !slices.Contains(tokenLifetimes, days) {
+		fail("Choose when the token expires")
+		return
+	}
+
+	token := &db.APIToken{Name: name, Username: actor(r), Role: string(role(r))}
+	if sess, ok := r.Context().Value(sessionCtxKey).(*db.Session); ok {
+		token.Issuer, token.Subject = sess.Issuer, sess.Subject
+	}
+	for _, s := range scopes {
+		token.Scopes = append(token.Scopes, string(s))
+	}
+	secret := auth.APITokenPrefix + auth.NewToken()
+	token.TokenHash = auth.HashToken(secret)
+	if err := h.store.CreateAPIToken(r.Context(), token, time.Now().AddDate(0, 0, days)); err != nil {
+		fmt.Printf("Error creating API token: %v\n", err)
+		fail("Could not create the token")
+		return
+	}
+	details := fmt.Sprintf("created token %q (#%d) with %s, expiring %s", token.Name, token.ID, strings.Join(token.Scopes, ", "), token.ExpiresAt)
+	if err := h.store.AddAuditEntry(r.Context(), actor(r), db.AuditCreateAPIToken, details); err != nil {
+		fmt.Printf("Error writing audit entry: %v\n", err)
+	}
+	h.renderTokensPage(w, r, tokensPageData{NewToken: secret, Message: "Created " + token.Name})
+}
+
+// handleRevokeToken deletes one of the requester's tokens, or anyone's for
+// admins.
+func (h *Handler) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	redirect := func(key, msg string) {
+		http.Redirect(w, r, "/tokens?"+key+"="+url.QueryEscape(msg), http.StatusFound)
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		redirect("error", "Invalid token ID")
+		return
+	}
+	tokens, err := h.visibleTokens(r)
+	if err != nil {
+		redirect("error", "Could not load API tokens")
+		return
+	}
+	i := slices.IndexFunc(tokens, func(t db.APIToken) bool { return t.ID == id })
+	if i < 0 {
+		redirect("error", "Token not found")
+		return
+	}
+	if err := h.store.DeleteAPIToken(r.Context(), id); err != nil {
+		fmt.Printf("Error revoking API token %d: %v\n", id, err)
+		redirect("error", "Failed to revoke the token")
+		return
+	}
+	details := fmt.Sprintf("revoked token %q (#%d) of %s", tokens[i].Name, id, tokens[i].Username)
+	if err := h.store.AddAuditEntry(r.Context(), actor(r), db.AuditRevokeAPIToken, details); err != nil {
+		fmt.Printf("Error writing audit entry: %v\n", err)
+	}
+	redirect("message", "Revoked "+tokens[i].Name)
+}
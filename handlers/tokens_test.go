@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"who-owes-me/auth"
+	"who-owes-me/db"
+)
+
+func TestAPITokens(t *testing.T) {
+	h, store := newTestServer(t)
+	ctx := context.Background()
+
+	create := func(scopes ...string) string {
+		t.Helper()
+		rec := postForm(h, "/tokens", url.Values{"name": {"bot"}, "scope": scopes, "expires_days": {"30"}})
+		token := regexp.MustCompile(`wom_[A-Za-z0-9_-]+`).FindString(rec.Body.String())
+		if rec.Code != http.StatusOK || token == "" {
+			t.Fatalf("POST /tokens = %d, no token shown", rec.Code)
+		}
+		return token
+	}
+	call := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	reader := create("read:all")
+	writer := create("read:all", "write:splits")
+	own := create("read:own")
+
+	for _, tt := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodGet, "/admin", reader, http.StatusOK},
+		{http.MethodGet, "/admin", own, http.StatusForbidden},
+		{http.MethodGet, "/users/dev_user", own, http.StatusOK},
+		{http.MethodPost, "/admin/notifications/clear", reader, http.StatusForbidden},
+		{http.MethodPost, "/admin/notifications/clear", writer, http.StatusFound},
+		{http.MethodPost, "/admin/users/delete", writer, http.StatusForbidden},
+		{http.MethodGet, "/admin/audit", writer, http.StatusForbidden},
+		{http.MethodGet, "/tokens", writer, http.StatusForbidden},
+		{http.MethodGet, "/admin", "wom_nope", http.StatusUnauthorized},
+	} {
+		if got := call(tt.method, tt.path, tt.token); got != tt.want {
+			t.Errorf("%s %s with %.8s… = %d, want %d", tt.method, tt.path, tt.token, got, tt.want)
+		}
+	}
+
+	tokens, _ := store.GetAPITokens(ctx, "dev_user")
+	if len(tokens) != 3 || tokens[0].OwnerRole != "admin" || tokens[0].LastUsedAt == "" {
+		t.Fatalf("tokens = %+v", tokens)
+	}
+
+	// Tokens follow their owner's role at the latest login, but never above
+	// the role they were made with.
+	login := tokens[0]
+	store.SetAPITokenOwnerRole(ctx, login.Issuer, login.Subject, login.Username, "viewer")
+	if got := call(http.MethodGet, "/admin", reader); got != http.StatusForbidden {
+		t.Errorf("GET /admin after demotion = %d, want 403", got)
+	}
+	limited := &db.APIToken{TokenHash: auth.HashToken("wom_limited"), Name: "limited", Username: login.Username,
+		Issuer: login.Issuer, Subject: login.Subject, Role: "viewer", Scopes: []string{"read:all"}}
+	if err := store.CreateAPIToken(ctx, limited, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	store.SetAPITokenOwnerRole(ctx, login.Issuer, login.Subject, login.Username, "admin")
+	if got := call(http.MethodGet, "/admin", reader); got != http.StatusOK {
+		t.Errorf("GET /admin after promotion = %d, want 200", got)
+	}
+	if got := call(http.MethodGet, "/admin", "wom_limited"); got != http.StatusForbidden {
+		t.Errorf("GET /admin with a viewer's token after promotion = %d, want 403", got)
+	}
+	postForm(h, "/tokens/revoke", url.Values{"id": {strconv.Itoa(tokens[0].ID)}})
+	if got := call(http.MethodGet, "/users/dev_user", own); got != http.StatusUnauthorized {
+		t.Errorf("revoked token = %d, want 401", got)
+	}
+
+	if rec := postForm(h, "/tokens", url.Values{"name": {"x"}, "scope": {"admin"}, "expires_days": {"30"}}); rec.Code != http.StatusBadRequest || strings.Contains(rec.Body.String(), "wom_") {
+		t.Errorf("unknown scope = %d", rec.Code)
+	}
+}
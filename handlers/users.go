@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"who-owes-me/db"
+)
+
+func redirectAdmin(w http.ResponseWriter, r *http.Request, key, msg string) {
+	http.Redirect(w, r, "/admin?"+key+"="+url.QueryEscape(msg), http.StatusFound)
+}
+
+// userFromForm loads the user named by the "id" form value.
+func (h *Handler) userFromForm(r *http.Request) (*db.User, error) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid user ID")
+	}
+	user, err := h.store.GetUserByID(r.Context(), id)
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+// handleSetUserActive deactivates or reactivates a user depending on the
+// "active" form value. Deactivated users keep their ledger.
+func (h *Handler) handleSetUserActive(w http.ResponseWriter, r *http.Request) {
+	user, err := h.userFromForm(r)
+	if err != nil {
+		redirectAdmin(w, r, "error", err.Error())
+		return
+	}
+	active := r.FormValue("active") == "1"
+	if err := h.store.SetUserActive(r.Context(), user.ID, active); err != nil {
+		fmt.Printf("Error updating %s: %v\n", user.Name, err)
+		redirectAdmin(w, r, "error", "Failed to update "+user.Name)
+		return
+	}
+	if active {
+		redirectAdmin(w, r, "message", user.Name+" is active again")
+	} else {
+		redirectAdmin(w, r, "message", user.Name+" was deactivated; their balance is kept")
+	}
+}
+
+// archivedClashHelp explains db.ErrArchivedSplitClash to an admin.
+const archivedClashHelp = "both users have a split on the same transaction, archived for one and active for the other, and those cannot be combined"
+
+// handleDeleteUser deletes a user. A user with splits is only deleted when the
+// form says what to do with them: "reassign" moves them to reassign_to, and
+// "write_off" deletes them.
+func (h *Handler) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.userFromForm(r)
+	if err != nil {
+		redirectAdmin(w, r, "error", err.Error())
+		return
+	}
+
+	var splits db.SplitDisposition
+	var target *db.User
+	switch r.FormValue("splits") {
+	case "reassign":
+		toID, err := strconv.Atoi(r.FormValue("reassign_to"))
+		if err != nil {
+			redirectAdmin(w, r, "error", "Choose who should take over "+user.Name+"'s splits")
+			return
+		}
+		target, err = h.store.GetUserByID(r.Context(), toID)
+		if err != nil || target.ID == user.ID {
+			redirectAdmin(w, r, "error", "Choose another user to take over "+user.Name+"'s splits")
+			return
+		}
+		splits.ReassignTo = target.ID
+	case "write_off":
+		splits.WriteOff = true
+	}
+
+	n, err := h.store.DeleteUser(r.Context(), user.ID, splits)
+	if err != nil {
+		if errors.Is(err, db.ErrUserHasSplits) {
+			redirectAdmin(w, r, "error", user.Name+" still has splits; reassign them or write them off first")
+			return
+		}
+		if errors.Is(err, db.ErrArchivedSplitClash) {
+			redirectAdmin(w, r, "error", "Cannot move "+user.Name+"'s splits: "+archivedClashHelp)
+			return
+		}
+		fmt.Printf("Error deleting %s: %v\n", user.Name, err)
+		redirectAdmin(w, r, "error", "Failed to delete "+user.Name)
+		return
+	}
+
+	var note string
+	switch {
+	case target != nil:
+		note = fmt.Sprintf(" and moved %d split(s) to %s", n, target.Name)
+	case splits.WriteOff:
+		note = fmt.Sprintf(" and wrote off %d split(s)", n)
+	}
+	if n > 0 {
+		h.scheduleWriteback()
+	}
+	details := fmt.Sprintf("deleted %s (%s, #%d)%s", user.Name, user.OIDCSub, user.ID, note)
+	if err := h.store.AddAuditEntry(r.Context(), actor(r), db.AuditDeleteUser, details); err != nil {
+		fmt.Printf("Error writing audit entry: %v\n", err)
+	}
+	redirectAdmin(w, r, "message", "Deleted "+user.Name+note)
+}
+
+// handleMergeUsers folds the user "id" into "into", for duplicates created
+// under a second login. The survivor keeps its identity and payee.
+func (h *Handler) handleMergeUsers(w http.ResponseWriter, r *http.Request) {
+	from, err := h.userFromForm(r)
+	if err != nil {
+		redirectAdmin(w, r, "error", err.Error())
+		return
+	}
+	intoID, err := strconv.Atoi(r.FormValue("into"))
+	if err != nil || intoID == from.ID {
+		redirectAdmin(w, r, "error", "Choose another user to merge "+from.Name+" into")
+		return
+	}
+
+	result, err := h.store.MergeUsers(r.Context(), from.ID, intoID, actor(r))
+	if errors.Is(err, db.ErrArchivedSplitClash) {
+		redirectAdmin(w, r, "error", "Cannot merge "+from.Name+": "+archivedClashHelp)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error merging %s: %v\n", from.Name, err)
+		redirectAdmin(w, r, "error", "Failed to merge "+from.Name)
+		return
+	}
+	h.scheduleWriteback()
+	redirectAdmin(w, r, "message", fmt.Sprintf("Merged %s into %s: %d split(s) moved, %d combined",
+		result.From.Name, result.Into.Name, result.Moved, result.Combined))
+}
+
+func (h *Handler) handleAuditPage(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.store.GetAuditLog(r.Context(), 500)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, "Could not load the audit log.")
+		return
+	}
+	renderTemplate(w, r, "audit.html", struct {
+		Entries []db.AuditEntry
+	}{
+		Entries: entries,
+	})
+}
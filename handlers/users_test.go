@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"who-owes-me/actual"
+)
+
+func TestDeactivateAndDeleteUser(t *testing.T) {
+	h, store := newTestServer(t)
+	ctx := context.Background()
+	store.CreateUser(ctx, "Alice", "alice", "regular", "p1")
+	store.CreateUser(ctx, "Bob", "bob", "regular", "p2")
+	alice, _ := store.GetUserBySub(ctx, "alice")
+	bob, _ := store.GetUserBySub(ctx, "bob")
+	store.SetSplit(ctx, "t1", alice.ID, 500, "", "")
+	aliceID := strconv.Itoa(alice.ID)
+
+	rec := postForm(h, "/admin/users/active", url.Values{"id": {aliceID}, "active": {"0"}})
+	if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, "/admin?message=") {
+		t.Fatalf("deactivate redirected to %q", loc)
+	}
+	if got, _ := store.GetUserByID(ctx, alice.ID); got.Active {
+		t.Error("alice is still active")
+	}
+
+	rec = postForm(h, "/admin/users/delete", url.Values{"id": {aliceID}, "splits": {""}})
+	if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, "/admin?error=") {
+		t.Fatalf("delete with splits redirected to %q, want an error", loc)
+	}
+	if _, err := store.GetUserByID(ctx, alice.ID); err != nil {
+		t.Fatalf("alice was deleted while she had splits: %v", err)
+	}
+
+	rec = postForm(h, "/admin/users/delete", url.Values{"id": {aliceID}, "splits": {"reassign"}, "reassign_to": {strconv.Itoa(bob.ID)}})
+	if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, "/admin?message=") {
+		t.Fatalf("delete with reassign redirected to %q", loc)
+	}
+	if _, err := store.GetUserByID(ctx, alice.ID); err == nil {
+		t.Error("alice was not deleted")
+	}
+	if splits, _ := store.GetSplitsForUser(ctx, bob.ID); len(splits) != 1 || splits[0].AmountOwed != 500 {
+		t.Errorf("bob's splits = %+v, want alice's split", splits)
+	}
+}
+
+func TestAutoSplitSkipsInactiveUsers(t *testing.T) {
+	_, store := newTestServer(t)
+	ctx := context.Background()
+	store.CreateUser(ctx, "Alice", "alice", "regular", "p1")
+	store.CreateUser(ctx, "Bob", "bob", "regular", "p2")
+	bob, _ := store.GetUserBySub(ctx, "bob")
+	store.SetUserActive(ctx, bob.ID, false)
+
+	txns := []actual.Transaction{
+		{ID: "t1", Amount: 1000, Payee: "p1", Date: "2026-01-01"},
+		{ID: "t2", Amount: 1000, Payee: "p2", Date: "2026-01-01"},
+	}
+	created, err := New(store).autoSplitTransactions(ctx, txns)
+	if err != nil || created != 1 {
+		t.Fatalf("autoSplitTransactions = %d, %v; want only alice's deposit", created, err)
+	}
+	if splits, _ := store.GetSplitsForTx(ctx, "t2"); len(splits) != 0 {
+		t.Errorf("inactive bob was auto-credited: %+v", splits)
+	}
+}
+
+func TestMergeUsersHandler(t *testing.T) {
+	h, store := newTestServer(t)
+	ctx := context.Background()
+	store.CreateUser(ctx, "Alice", "alice", "regular", "p1")
+	store.CreateUser(ctx, "Alice S", "alice.s", "regular", "")
+	alice, _ := store.GetUserBySub(ctx, "alice")
+	dupe, _ := store.GetUserBySub(ctx, "alice.s")
+	store.SetSplit(ctx, "t1", dupe.ID, 500, "", "")
+
+	rec := postForm(h, "/admin/users/merge", url.Values{"id": {strconv.Itoa(dupe.ID)}, "into": {strconv.Itoa(alice.ID)}})
+	if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, "/admin?message=") {
+		t.Fatalf("merge redirected to %q", loc)
+	}
+	if splits, _ := store.GetSplitsForUser(ctx, alice.ID); len(splits) != 1 {
+		t.Errorf("alice's splits = %+v, want the duplicate's", splits)
+	}
+	entries, _ := store.GetAuditLog(ctx, 10)
+	if len(entries) != 1 || entries[0].Actor != "dev_user" {
+		t.Errorf("audit log = %+v, want one entry by dev_user", entries)
+	}
+}
+
+func TestAutoSplitCreditsOtherPayees(t *testing.T) {
+	h, store := newTestServer(t)
+	ctx := context.Background()
+	store.CreateUser(ctx, "Alice", "alice", "regular", "p1")
+	alice, _ := store.GetUserBySub(ctx, "alice")
+
+	// A parent paying from their own account is added as another payee.
+	rec := postForm(h, "/admin/users/update", url.Values{
+		"id":              {strconv.Itoa(alice.ID)},
+		"name":            {"Alice"},
+		"oidc_sub":        {"alice"},
+		"aid_class":       {"regular"},
+		"actual_payee_id": {"p1"},
+		"payee_ids":       {"", "p-parent"},
+	})
+	if loc := rec.Header().Get("Location"); loc != "/admin" {
+		t.Fatalf("update redirected to %q", loc)
+	}
+
+	txns := []actual.Transaction{{ID: "t1", Amount: 1000, Payee: "p-parent", Date: "2026-01-01"}}
+	created, err := New(store).autoSplitTransactions(ctx, txns)
+	if err != nil || created != 1 {
+		t.Fatalf("autoSplitTransactions = %d, %v", created, err)
+	}
+	if splits, _ := store.GetSplitsForUser(ctx, alice.ID); len(splits) != 1 || splits[0].AmountOwed != 1000 {
+		t.Errorf("alice's splits = %+v, want the parent's deposit", splits)
+	}
+
+	store.CreateUser(ctx, "Bob", "bob", "regular", "")
+	bob, _ := store.GetUserBySub(ctx, "bob")
+	rec = postForm(h, "/admin/users/update", url.Values{
+		"id": {strconv.Itoa(bob.ID)}, "name": {"Bob"}, "oidc_sub": {"bob"}, "aid_class": {"regular"},
+		"payee_ids": {"p-parent"},
+	})
+	if loc := rec.Header().Get("Location"); !strings.Contains(loc, "already+linked") {
+		t.Errorf("linking alice's payee to bob redirected to %q", loc)
+	}
+}
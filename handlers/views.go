@@ -1,15 +1,19 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 	"who-owes-me/actual"
+	"who-owes-me/auth"
 
 	"github.com/go-chi/chi/v5"
 	"who-owes-me/db"
@@ -24,6 +28,7 @@ var splitTag = func() string {
 }()
 
 func cleanNote(note string) string {
+	note = splitSummaryExpr.ReplaceAllString(note, "")
 	result := strings.TrimSpace(strings.ReplaceAll(note, splitTag, ""))
 	if result == "" {
 		return "(no notes)"
@@ -31,12 +36,17 @@ func cleanNote(note string) string {
 	return result
 }
 
+func formatCents(cents int) string {
+	if cents < 0 {
+		return fmt.Sprintf("-$%.2f", float64(-cents)/100.0)
+	}
+	return fmt.Sprintf("$%.2f", float64(cents)/100.0)
+}
+
 var funcMap = template.FuncMap{
-	"formatMoney": func(cents int) string {
-		if cents < 0 {
-			return fmt.Sprintf("-$%.2f", float64(-cents)/100.0)
-		}
-		return fmt.Sprintf("$%.2f", float64(cents)/100.0)
+	"formatMoney": formatCents,
+	"formatPercent": func(f float64) string {
+		return fmt.Sprintf("%.1f%%", f*100)
 	},
 	"formatDate": func(dateStr string) string {
 		t, err := time.Parse("2006-01-02", dateStr)
@@ -58,6 +68,12 @@ var funcMap = template.FuncMap{
 	"negate": func(cents int) int {
 		return -cents
 	},
+	// signedIn is false on pages shown to visitors without a login.
+	"signedIn": func() bool { return true },
+	// Replaced by pageFuncs for pages rendered for a request.
+	"csrfToken":     func() string { return "" },
+	"csrfField":     func() template.HTML { return "" },
+	"impersonating": func() *impersonation { return nil },
 	"formatAidClassColor": func(class string) string {
 		switch class {
 		case "needs_help":
@@ -70,8 +86,22 @@ var funcMap = template.FuncMap{
 	},
 }
 
-func renderTemplate(w http.ResponseWriter, tmplName string, data interface{}) {
-	tmpl, err := template.New("").Funcs(funcMap).ParseFiles("templates/base.html", "templates/"+tmplName)
+func renderTemplate(w http.ResponseWriter, r *http.Request, tmplName string, data interface{}) {
+	renderWithFuncs(w, tmplName, data, pageFuncs(r))
+}
+
+// pageFuncs are the template funcs that depend on the request: its CSRF
+// token and whether an admin is viewing as someone.
+func pageFuncs(r *http.Request) template.FuncMap {
+	funcs := csrfFuncs(r)
+	imp := impersonationOf(r)
+	funcs["impersonating"] = func() *impersonation { return imp }
+	return funcs
+}
+
+// renderWithFuncs is renderTemplate with some of funcMap replaced.
+func renderWithFuncs(w http.ResponseWriter, tmplName string, data interface{}, funcs template.FuncMap) {
+	tmpl, err := template.New("").Funcs(funcMap).Funcs(funcs).ParseFiles("templates/base.html", "templates/"+tmplName)
 	if err != nil {
 		fmt.Printf("Template parsing error: %v\n", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -87,10 +117,10 @@ func renderTemplate(w http.ResponseWriter, tmplName string, data interface{}) {
 
 func renderError(w http.ResponseWriter, code int, message string) {
 	w.WriteHeader(code)
-	renderTemplate(w, "error.html", struct {
+	renderWithFuncs(w, "error.html", struct {
 		Code    int
 		Message string
-	}{Code: code, Message: message})
+	}{Code: code, Message: message}, nil)
 }
 
 type LedgerRow struct {
@@ -101,10 +131,27 @@ type LedgerRow struct {
 	RunningBalance int
 }
 
-func getUserDashboardData(user *db.User) (interface{}, error) {
+// userDashboardData is what user.html renders.
+type userDashboardData struct {
+	User           *db.User
+	LedgerRows     []LedgerRow
+	Balance        int
+	SplitTag       string
+	Payment        *PaymentInfo
+	Message        string
+	Error          string
+	Shared         bool // opened from a share link, without a login
+	CanShare       bool
+	ShareLinks     []db.ShareLink
+	ShareURL       string // a link just created, shown once
+	ShareLifetimes []int  // days
+	CanImpersonate bool   // may "View as" this user
+}
+
+func (h *Handler) getUserDashboardData(ctx context.Context, user *db.User) (*userDashboardData, error) {
 	actClient := actual.NewClient()
 	
-	splits, _ := db.GetSplitsForUser(user.ID)
+	splits, _ := h.store.GetSplitsForUser(ctx, user.ID)
 	if splits == nil {
 		splits = []db.ExpenseSplit{}
 	}
@@ -168,23 +215,17 @@ func getUserDashboardData(user *db.User) (interface{}, error) {
 		rows[i], rows[j] = rows[j], rows[i]
 	}
 
-	return struct {
-		User       *db.User
-		LedgerRows []LedgerRow
-		Balance    int
-		SplitTag   string
-	}{
+	return &userDashboardData{
 		User:       user,
 		LedgerRows: rows,
 		Balance:    balance,
 		SplitTag:   splitTag,
+		Payment:    h.paymentInfo(ctx, user, balance),
 	}, nil
 }
 
-func handleDashboard(w http.ResponseWriter, r *http.Request) {
-	isAdmin := r.Context().Value(isAdminCtxKey).(bool)
-
-	if isAdmin {
+func (h *Handler) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if can(r, auth.PermViewLedgers) {
 		http.Redirect(w, r, "/admin", http.StatusFound)
 		return
 	}
@@ -193,33 +234,60 @@ func handleDashboard(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/users/"+user.OIDCSub, http.StatusFound)
 }
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{"status":"ok"}`))
 }
 
-func handleUserDashboardBySub(w http.ResponseWriter, r *http.Request) {
-	isAdmin := r.Context().Value(isAdminCtxKey).(bool)
+func (h *Handler) handleUserDashboardBySub(w http.ResponseWriter, r *http.Request) {
 	userVal := r.Context().Value(userCtxKey)
 	
 	sub := chi.URLParam(r, "sub")
 
-	// Must be admin, or the user requesting their own page.
-	if !isAdmin {
+	// Must be staff, or the user requesting their own page.
+	if !can(r, auth.PermViewLedgers) {
 		if userVal == nil || userVal.(*db.User).OIDCSub != sub {
 			renderError(w, http.StatusForbidden, "You don't have permission to view this page.")
 			return
 		}
 	}
 
-	user, err := db.GetUserBySub(sub)
+	user, err := h.store.GetUserBySub(r.Context(), sub)
 	if err != nil {
 		renderError(w, http.StatusNotFound, "User not found.")
 		return
 	}
 
-	data, _ := getUserDashboardData(user)
-	renderTemplate(w, "user.html", data)
+	data, _ := h.getUserDashboardData(r.Context(), user)
+	data.Message = r.URL.Query().Get("message")
+	data.Error = r.URL.Query().Get("error")
+	if data.CanShare = canShare(r, user); data.CanShare {
+		if data.ShareLinks, err = h.store.GetShareLinks(r.Context(), user.ID); err != nil {
+			fmt.Printf("Error fetching share links for %s: %v\n", user.Name, err)
+		}
+		data.ShareURL = r.URL.Query().Get("share_url")
+		data.ShareLifetimes = shareLifetimes
+	}
+	data.CanImpersonate = can(r, auth.PermManage) && !viaToken(r)
+	renderTemplate(w, r, "user.html", data)
+}
+
+// computeBalances returns each user's balance keyed by user ID. Credits
+// (deposits) count toward the user, expense shares against them.
+func computeBalances(splits []db.ExpenseSplit, txMap map[string]actual.Transaction) map[int]int {
+	balances := map[int]int{}
+	for _, s := range splits {
+		isCredit := s.AutoCreated
+		if tx, ok := txMap[s.ActualTransactionID]; ok {
+			isCredit = tx.Amount > 0
+		}
+		if isCredit {
+			balances[s.UserID] += s.AmountOwed
+		} else {
+			balances[s.UserID] -= s.AmountOwed
+		}
+	}
+	return balances
 }
 
 type UserWithBalance struct {
@@ -227,8 +295,8 @@ type UserWithBalance struct {
 	Balance int `json:"balance"`
 }
 
-func handleAdminDashboard(w http.ResponseWriter, r *http.Request) {
-	users, _ := db.GetAllUsers()
+func (h *Handler) handleAdminDashboard(w http.ResponseWriter, r *http.Request) {
+	users, _ := h.store.GetAllUsers(r.Context())
 	if users == nil {
 		users = []db.User{}
 	}
@@ -260,12 +328,16 @@ func handleAdminDashboard(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var usersWithBalance []UserWithBalance
-	payeeToUser := map[string]db.User{}
-	for _, u := range users {
-		payeeToUser[u.ActualPayeeID] = u
+
+	// Auto-split: for any positive transaction without a split whose payee maps to a user,
+	// create a split for the full amount
+	if created, err := h.autoSplitTransactions(r.Context(), allTagged); err != nil {
+		fmt.Printf("Error auto-splitting transactions: %v\n", err)
+	} else if created > 0 {
+		h.scheduleWriteback()
 	}
 
-	allSplits, _ := db.GetAllSplits()
+	allSplits, _ := h.store.GetAllSplits(r.Context())
 	if allSplits == nil {
 		allSplits = []db.ExpenseSplit{}
 	}
@@ -275,61 +347,27 @@ func handleAdminDashboard(w http.ResponseWriter, r *http.Request) {
 		splitTxSet[s.ActualTransactionID] = true
 	}
 
-	// Auto-split: for any positive transaction without a split whose payee maps to a user,
-	// create a split for the full amount
-	for _, tx := range allTagged {
-		if splitTxSet[tx.ID] {
-			continue
-		}
-		if user, ok := payeeToUser[tx.Payee]; ok {
-			if tx.Amount > 0 {
-				db.SetAutoSplit(tx.ID, user.ID, tx.Amount, tx.Date, tx.Notes)
-				splitTxSet[tx.ID] = true
-			}
-		}
-	}
-
-	// Re-read splits after auto-split
-	allSplits, _ = db.GetAllSplits()
-	if allSplits == nil {
-		allSplits = []db.ExpenseSplit{}
-	}
-
 	txMap := map[string]actual.Transaction{}
 	for _, t := range allTagged {
 		txMap[t.ID] = t
 	}
 
 	// Calculate balances from splits
+	balances := computeBalances(allSplits, txMap)
 	for _, u := range users {
-		balance := 0
-		for _, s := range allSplits {
-			if s.UserID == u.ID {
-				isCredit := s.AutoCreated
-				if tx, ok := txMap[s.ActualTransactionID]; ok {
-					isCredit = tx.Amount > 0
-				}
-				if isCredit {
-					balance += s.AmountOwed
-				} else {
-					balance -= s.AmountOwed
-				}
-			}
-		}
 		usersWithBalance = append(usersWithBalance, UserWithBalance{
 			User:    u,
-			Balance: balance,
+			Balance: balances[u.ID],
 		})
 	}
 
 	usersJSON, _ := json.Marshal(usersWithBalance)
 
 	// Build payee-to-user map for the frontend
-	payeeToUserMap := map[string]int{}
-	for _, u := range users {
-		if u.ActualPayeeID != "" {
-			payeeToUserMap[u.ActualPayeeID] = u.ID
-		}
+	payeeToUserMap, err := h.store.GetPayeeOwners(r.Context())
+	if err != nil {
+		fmt.Printf("Error fetching payee owners: %v\n", err)
+		payeeToUserMap = map[string]int{}
 	}
 	payeeToUserMapJSON, _ := json.Marshal(payeeToUserMap)
 
@@ -349,6 +387,13 @@ func handleAdminDashboard(w http.ResponseWriter, r *http.Request) {
 		PayeeToUserMapJSON template.JS
 		SplitTxSet         map[string]bool
 		SplitTag           string
+		Notifications      []actual.ChangeEvent
+		SyncEnabled        bool
+		SyncStatus         actual.SyncStatus
+		Message            string
+		WritebackMode      string
+		Can                struct{ EditUsers, EditSplits, Manage bool }
+		Registrations      []db.Registration
 	}{
 		Users:              usersWithBalance,
 		UsersJSON:          template.JS(usersJSON),
@@ -362,12 +407,27 @@ func handleAdminDashboard(w http.ResponseWriter, r *http.Request) {
 		PayeeToUserMapJSON: template.JS(payeeToUserMapJSON),
 		SplitTxSet:         splitTxSet,
 		SplitTag:           splitTag,
+		Notifications:      getNotifications(),
+		Message:            r.URL.Query().Get("message"),
+		WritebackMode:      writebackMode(),
+	}
+	data.Can.EditUsers = can(r, auth.PermEditUsers)
+	data.Can.EditSplits = can(r, auth.PermEditSplits)
+	data.Can.Manage = can(r, auth.PermManage)
+	if data.Can.EditUsers {
+		if data.Registrations, err = h.store.GetRegistrations(r.Context()); err != nil {
+			fmt.Printf("Error fetching registrations: %v\n", err)
+		}
+	}
+	if syncer := h.syncer; syncer != nil {
+		data.SyncEnabled = true
+		data.SyncStatus = syncer.Status()
 	}
 
-	renderTemplate(w, "admin.html", data)
+	renderTemplate(w, r, "admin.html", data)
 }
 
-func handleCreateUser(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -378,8 +438,33 @@ func handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	aidClasses := r.Form["aid_class"]
 	payeeIDs := r.Form["actual_payee_id"]
 
+	existing, err := h.store.GetAllUsers(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/admin?error="+url.QueryEscape("Could not load users"), http.StatusFound)
+		return
+	}
+	owners, err := h.store.GetPayeeOwners(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/admin?error="+url.QueryEscape("Could not load users"), http.StatusFound)
+		return
+	}
+	takenSubs := map[string]bool{}
+	takenPayees := map[string]bool{}
+	for _, u := range existing {
+		takenSubs[u.OIDCSub] = true
+	}
+	for payeeID := range owners {
+		takenPayees[payeeID] = true
+	}
+
+	var failed []string
 	for i := range names {
-		if strings.TrimSpace(names[i]) == "" || strings.TrimSpace(oidcSubs[i]) == "" {
+		name := strings.TrimSpace(names[i])
+		var sub string
+		if i < len(oidcSubs) {
+			sub = strings.TrimSpace(oidcSubs[i])
+		}
+		if name == "" || sub == "" {
 			continue
 		}
 		
@@ -393,17 +478,41 @@ func handleCreateUser(w http.ResponseWriter, r *http.Request) {
 			payeeID = payeeIDs[i]
 		}
 
-		err := db.CreateUser(names[i], oidcSubs[i], aidClass, payeeID)
+		// Validate before creating anything in Actual, so a rejected row does
+		// not leave an orphan payee behind.
+		if takenSubs[sub] {
+			failed = append(failed, name+" (login "+sub+" is already in use)")
+			continue
+		}
+		if payeeID != "" && !strings.HasPrefix(payeeID, newPayeePrefix) && takenPayees[payeeID] {
+			failed = append(failed, name+" (payee is already linked)")
+			continue
+		}
+
+		payeeID, err := resolvePayeeID(payeeID, name)
 		if err != nil {
-			// Log error but continue with other users
-			fmt.Printf("Error creating user %s: %v\n", names[i], err)
+			fmt.Printf("Error creating payee for %s: %v\n", name, err)
+			failed = append(failed, name+" (could not create payee)")
+			continue
+		}
+
+		if err := h.store.CreateUser(r.Context(), name, sub, aidClass, payeeID); err != nil {
+			fmt.Printf("Error creating user %s: %v\n", name, err)
+			failed = append(failed, name)
+			continue
 		}
+		takenSubs[sub] = true
+		takenPayees[payeeID] = true
 	}
 
+	if len(failed) > 0 {
+		http.Redirect(w, r, "/admin?error="+url.QueryEscape("Could not add "+strings.Join(failed, ", ")), http.StatusFound)
+		return
+	}
 	http.Redirect(w, r, "/admin", http.StatusFound)
 }
 
-func handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
 	idStr := r.FormValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -416,7 +525,20 @@ func handleUpdateUser(w http.ResponseWriter, r *http.Request) {
 	aidClass := r.FormValue("aid_class")
 	payeeID := r.FormValue("actual_payee_id")
 
-	err = db.UpdateUser(id, name, oidcSub, aidClass, payeeID)
+	err = h.store.UpdateUser(r.Context(), id, name, oidcSub, aidClass, payeeID)
+	if err == nil && r.Form.Has("payee_ids") {
+		var others []string
+		for _, p := range r.Form["payee_ids"] {
+			if p != "" && p != payeeID {
+				others = append(others, p)
+			}
+		}
+		err = h.store.SetUserPayees(r.Context(), id, others)
+	}
+	if errors.Is(err, db.ErrPayeeTaken) {
+		http.Redirect(w, r, "/admin?error="+url.QueryEscape("Failed to update user: a payee is already linked to another user"), http.StatusFound)
+		return
+	}
 	if err != nil {
 		http.Redirect(w, r, "/admin?error=Failed to update user", http.StatusFound)
 		return
@@ -425,7 +547,7 @@ func handleUpdateUser(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/admin", http.StatusFound)
 }
 
-func handleGetPayees(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handleGetPayees(w http.ResponseWriter, r *http.Request) {
 	actClient := actual.NewClient()
 	payees, err := actClient.GetPayees()
 	if err != nil {
@@ -437,12 +559,14 @@ func handleGetPayees(w http.ResponseWriter, r *http.Request) {
 	tmpl.ExecuteTemplate(w, "payee_options.html", payees)
 }
 
-func handleRefreshCache(w http.ResponseWriter, r *http.Request) {
-	actual.ClearCache()
+// handleRefreshCache drops cached transactions so the dashboard reloads them.
+// Payees rarely change and are kept; they can be refreshed from /admin/cache.
+func (h *Handler) handleRefreshCache(w http.ResponseWriter, r *http.Request) {
+	actual.InvalidateCachePrefix(actual.TransactionCachePrefix)
 	http.Redirect(w, r, "/admin", http.StatusFound)
 }
 
-func handleCreateSplits(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handleCreateSplits(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Invalid form data", http.StatusBadRequest)
 		return
@@ -458,7 +582,7 @@ func handleCreateSplits(w http.ResponseWriter, r *http.Request) {
 
 	// Always clear existing splits first so removed participants are actually deleted,
 	// and "Clear Splits" action can just submit an empty list.
-	db.ClearSplitsForTx(txID)
+	h.store.ClearSplitsForTx(r.Context(), txID)
 
 	// Read dynamic form fields: split_amount_USERID=AMOUNT
 	for key, values := range r.Form {
@@ -475,22 +599,32 @@ func handleCreateSplits(w http.ResponseWriter, r *http.Request) {
 				amount = 0
 			}
 
-			db.SetSplit(txID, userID, amount, txDate, txNote)
+			h.store.SetSplit(r.Context(), txID, userID, amount, txDate, txNote)
 		}
 	}
 
-	// Optional: map a payee to a user (one-user-save popup)
+	// Optional: add the payee to a user's payees (one-user-save popup)
 	mapUserIDStr := r.FormValue("map_payee_to_user_id")
 	payeeIDToMap := r.FormValue("payee_id_to_map")
 	if mapUserIDStr != "" && payeeIDToMap != "" {
 		mapUserID, err := strconv.Atoi(mapUserIDStr)
 		if err == nil {
-			existingUser, err := db.GetUserByID(mapUserID)
-			if err == nil {
-				db.UpdateUser(existingUser.ID, existingUser.Name, existingUser.OIDCSub, existingUser.AidClass, payeeIDToMap)
+			if err := h.store.AddUserPayee(r.Context(), mapUserID, payeeIDToMap); err != nil {
+				fmt.Printf("Error adding payee %s to user %d: %v\n", payeeIDToMap, mapUserID, err)
 			}
 		}
 	}
 
+	h.scheduleWriteback()
+
 	http.Redirect(w, r, "/admin", http.StatusFound)
 }
+
+func (h *Handler) handleWriteback(w http.ResponseWriter, r *http.Request) {
+	result, err := h.runWriteback(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/admin?error="+url.QueryEscape("Write-back failed: "+err.Error()), http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/admin?message="+url.QueryEscape(result.String()), http.StatusFound)
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"who-owes-me/actual"
+	"who-owes-me/db"
+	"who-owes-me/internal/envutil"
+)
+
+const (
+	writebackReceivable = "receivable"
+	writebackNote       = "note"
+)
+
+var (
+	writebackMu      sync.Mutex
+	writebackQueued  atomic.Bool
+	splitSummaryExpr = regexp.MustCompile(`\s*\[split: [^\]]*\]`)
+)
+
+// writebackMode is "receivable", "note" or "" when write-back is disabled.
+func writebackMode() string {
+	return strings.TrimSpace(envutil.Getenv("WRITEBACK_MODE"))
+}
+
+type WritebackResult struct {
+	Created   int
+	Updated   int
+	Unchanged int
+	Errors    []string
+}
+
+func (r WritebackResult) String() string {
+	msg := fmt.Sprintf("Write-back: %d created, %d updated, %d unchanged", r.Created, r.Updated, r.Unchanged)
+	if len(r.Errors) > 0 {
+		msg += fmt.Sprintf(", %d failed (%s)", len(r.Errors), strings.Join(r.Errors, "; "))
+	}
+	return msg
+}
+
+// scheduleWriteback pushes the current splits to Actual in the background when
+// write-back is enabled. Calls made while a run is already waiting are folded
+// into it, so a burst of sync events costs one run.
+func (h *Handler) scheduleWriteback() {
+	if writebackMode() == "" {
+		return
+	}
+	if !writebackQueued.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		result, err := h.runWriteback(context.Background())
+		if err != nil {
+			fmt.Printf("Error writing back to Actual: %v\n", err)
+			return
+		}
+		fmt.Println(result)
+	}()
+}
+
+// runWriteback syncs the configured write-back mode into Actual. Every
+// transaction it touches is recorded in actual_writebacks, so running it
+// repeatedly only patches what changed.
+func (h *Handler) runWriteback(ctx context.Context) (WritebackResult, error) {
+	writebackMu.Lock()
+	defer writebackMu.Unlock()
+	// Changes made from here on need another run to be picked up.
+	writebackQueued.Store(false)
+
+	// Bypass the cache: notes written below are derived from these, so they
+	// must be what Actual holds right now.
+	actClient := actual.NewClient()
+	txns, err := actClient.FetchTransactionsByTag(splitTag)
+	if err != nil {
+		return WritebackResult{}, err
+	}
+	splits, err := h.store.GetAllSplits(ctx)
+	if err != nil {
+		return WritebackResult{}, err
+	}
+
+	var result WritebackResult
+	switch mode := writebackMode(); mode {
+	case writebackReceivable:
+		result, err = h.writebackReceivables(ctx, actClient, txns, splits)
+	case writebackNote:
+		result, err = h.writebackNotes(ctx, actClient, txns, splits)
+	case "":
+		return result, fmt.Errorf("write-back is disabled; set WRITEBACK_MODE")
+	default:
+		return result, fmt.Errorf("unknown WRITEBACK_MODE %q", mode)
+	}
+
+	if result.Created > 0 || result.Updated > 0 {
+		actual.InvalidateCachePrefix(actual.TransactionCachePrefix)
+	}
+	return result, err
+}
+
+// writebackReceivables keeps one transaction per player in the receivables
+// account whose amount is what the player owes the team.
+func (h *Handler) writebackReceivables(ctx context.Context, actClient *actual.Client, txns []actual.Transaction, splits []db.ExpenseSplit) (WritebackResult, error) {
+	var result WritebackResult
+	accountID := envutil.Getenv("WRITEBACK_ACCOUNT_ID")
+	if accountID == "" {
+		return result, fmt.Errorf("WRITEBACK_ACCOUNT_ID is required for receivable write-back")
+	}
+
+	users, err := h.store.GetAllUsers(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	txMap := map[string]actual.Transaction{}
+	for _, t := range txns {
+		txMap[t.ID] = t
+	}
+	balances := computeBalances(splits, txMap)
+	today := time.Now().Format("2006-01-02")
+
+	for _, u := range users {
+		ref := strconv.Itoa(u.ID)
+		amount := -balances[u.ID]
+		notes := fmt.Sprintf("Receivable: %s (who-owes-me)", u.Name)
+
+		existing, err := h.store.GetWriteback(ctx, writebackReceivable, ref)
+		if err != nil {
+			return result, err
+		}
+		if existing != nil && existing.Amount == amount && existing.Notes == notes {
+			result.Unchanged++
+			continue
+		}
+
+		txID := ""
+		if existing != nil {
+			txID = existing.ActualTransactionID
+		} else {
+			// Recover the mapping if the local record was lost.
+			found, err := actClient.FindTransactionByImportedID(receivableImportedID(ref))
+			if err != nil {
+				result.Errors = append(result.Errors, u.Name+": "+err.Error())
+				continue
+			}
+			if found != nil {
+				txID = found.ID
+			}
+		}
+
+		if txID == "" {
+			if amount == 0 {
+				result.Unchanged++
+				continue
+			}
+			txID, err = actClient.CreateTransaction(accountID, actual.Transaction{
+				Date:       today,
+				Amount:     amount,
+				Payee:      u.ActualPayeeID,
+				Notes:      notes,
+				ImportedID: receivableImportedID(ref),
+			})
+			if err != nil {
+				result.Errors = append(result.Errors, u.Name+": "+err.Error())
+				continue
+			}
+			result.Created++
+		} else {
+			err = actClient.UpdateTransaction(txID, map[string]interface{}{
+				"date":   today,
+				"amount": amount,
+				"notes":  notes,
+			})
+			if err != nil {
+				result.Errors = append(result.Errors, u.Name+": "+err.Error())
+				continue
+			}
+			result.Updated++
+		}
+
+		if err := h.store.SetWriteback(ctx, writebackReceivable, ref, txID, amount, notes); err != nil {
+			return result, err
+		}
+	}
+
+	// Deleted users no longer owe anything; zero their receivables.
+	current := map[string]bool{}
+	for _, u := range users {
+		current[strconv.Itoa(u.ID)] = true
+	}
+	recorded, err := h.store.GetWritebacks(ctx, writebackReceivable)
+	if err != nil {
+		return result, err
+	}
+	for _, wb := range recorded {
+		if current[wb.Ref] || wb.Amount == 0 {
+			continue
+		}
+		err := actClient.UpdateTransaction(wb.ActualTransactionID, map[string]interface{}{
+			"date":   today,
+			"amount": 0,
+		})
+		if err != nil {
+			result.Errors = append(result.Errors, wb.Notes+": "+err.Error())
+			continue
+		}
+		result.Updated++
+		if err := h.store.SetWriteback(ctx, writebackReceivable, wb.Ref, wb.ActualTransactionID, 0, wb.Notes); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func receivableImportedID(ref string) string {
+	return "who-owes-me:receivable:" + ref
+}
+
+// writebackNotes appends a "[split: ...]" summary of the participants to each
+// split transaction's notes, replacing any summary written earlier.
+func (h *Handler) writebackNotes(ctx context.Context, actClient *actual.Client, txns []actual.Transaction, splits []db.ExpenseSplit) (WritebackResult, error) {
+	var result WritebackResult
+
+	users, err := h.store.GetAllUsers(ctx)
+	if err != nil {
+		return result, err
+	}
+	userNames := map[int]string{}
+	for _, u := range users {
+		userNames[u.ID] = u.Name
+	}
+
+	byTx := map[string][]db.ExpenseSplit{}
+	for _, s := range splits {
+		byTx[s.ActualTransactionID] = append(byTx[s.ActualTransactionID], s)
+	}
+
+	for _, tx := range txns {
+		notes := splitSummaryExpr.ReplaceAllString(tx.Notes, "")
+		if summary := splitSummary(byTx[tx.ID], userNames); summary != "" {
+			notes += " " + summary
+		}
+		if notes == tx.Notes {
+			result.Unchanged++
+			continue
+		}
+		// Never write notes that would drop the transaction out of the tag
+		// query; it would stop being tracked at all.
+		if !strings.Contains(notes, splitTag) {
+			result.Errors = append(result.Errors, tx.ID+": refusing to write notes without "+splitTag)
+			continue
+		}
+
+		if err := actClient.UpdateTransaction(tx.ID, map[string]interface{}{"notes": notes}); err != nil {
+			result.Errors = append(result.Errors, tx.ID+": "+err.Error())
+			continue
+		}
+		result.Updated++
+
+		if err := h.store.SetWriteback(ctx, writebackNote, tx.ID, tx.ID, 0, notes); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// splitSummary lists the participants of a split. Single auto-created credits
+// are left alone since the payee already says who paid.
+func splitSummary(splits []db.ExpenseSplit, userNames map[int]string) string {
+	if len(splits) == 0 || (len(splits) == 1 && splits[0].AutoCreated) {
+		return ""
+	}
+	parts := make([]string, 0, len(splits))
+	for _, s := range splits {
+		name := userNames[s.UserID]
+		if name == "" {
+			name = fmt.Sprintf("User #%d", s.UserID)
+		}
+		parts = append(parts, name+" "+formatCents(s.AmountOwed))
+	}
+	sort.Strings(parts)
+	return "[split: " + strings.Join(parts, ", ") + "]"
+}
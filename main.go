@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
 	"who-owes-me/actual"
@@ -20,19 +23,79 @@ func main() {
 	_ = godotenv.Load(".env")
 	_ = godotenv.Load(".env.dev")
 
-	db.InitDB()
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
+	store := db.InitDB()
+	defer store.Close()
+	h := handlers.New(store)
+
+	generated, err := auth.InitSessionKeys()
+	if err != nil {
+		log.Fatalf("Invalid session secret: %v", err)
+	}
+	if generated {
+		log.Printf("WARNING: SESSION_SECRET is not set — using a random key, so restarts log everyone out")
+	}
+	if err := auth.InitRoleMapping(); err != nil {
+		log.Fatalf("Invalid ROLE_MAPPING: %v", err)
+	}
 
 	if err := auth.InitOIDC(); err != nil {
-		log.Printf("WARNING: OIDC not configured (%v) — running without authentication", err)
+		log.Printf("WARNING: OIDC not configured (%v)", err)
+	}
+	if err := auth.InitMail(); err != nil {
+		log.Fatalf("Invalid email sign-in settings: %v", err)
+	}
+	if auth.Mail != nil {
+		log.Printf("Email sign-in links enabled via %s", auth.Mail.Addr)
+	}
+	if err := auth.InitForwardAuth(); err != nil {
+		log.Fatalf("Invalid forward-auth settings: %v", err)
+	}
+	if auth.ForwardAuth != nil {
+		log.Printf("Trusting %s from proxies in %v", auth.ForwardAuth.UserHeader, auth.ForwardAuth.Trusted)
+	}
+	if !auth.Enabled() {
+		log.Printf("WARNING: no login configured — running without authentication")
+	}
+
+	cacheMaxEntries := 256
+	if v := envutil.Getenv("CACHE_MAX_ENTRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cacheMaxEntries = n
+		} else {
+			log.Printf("WARNING: invalid CACHE_MAX_ENTRIES %q, using %d", v, cacheMaxEntries)
+		}
+	}
+	actual.InitCache(5*time.Minute, cacheMaxEntries)
+
+	if v := envutil.Getenv("SYNC_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			log.Fatalf("Invalid SYNC_INTERVAL %q: expected a duration like 5m", v)
+		}
+		h.StartSync(context.Background(), interval)
+		log.Printf("Background Actual sync every %s", interval)
 	}
 
-	actual.InitCache(5 * time.Minute)
+	if v := envutil.Getenv("BACKUP_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			log.Fatalf("Invalid BACKUP_INTERVAL %q: expected a duration like 24h", v)
+		}
+		if err := h.StartBackups(context.Background(), interval); err != nil {
+			log.Fatalf("Cannot schedule backups: %v", err)
+		}
+		log.Printf("Scheduled database backups every %s", interval)
+	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
-	handlers.RegisterRoutes(r)
+	h.RegisterRoutes(r)
 
 	port := envutil.Getenv("PORT")
 	if port == "" {